@@ -1,6 +1,11 @@
 package _115
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -14,19 +19,57 @@ import (
 const (
 	UserAgent = "Mozilla/5.0 115Browser/23.9.3.2"
 
-	APIURLGetFiles       = "https://webapi.115.com/files"
-	APIURLGetDownloadURL = "https://proapi.115.com/app/chrome/downurl"
-	APIURLGetDirID       = "https://webapi.115.com/files/getid"
-	APIURLDeleteFile     = "https://webapi.115.com/rb/delete"
-	APIURLAddDir         = "https://webapi.115.com/files/add"
-	APIURLMoveFile       = "https://webapi.115.com/files/move"
-	APIURLRenameFile     = "https://webapi.115.com/files/batch_rename"
-	APIURLLoginCheck     = "https://passportapi.115.com/app/1.0/web/1.0/check/sso"
+	// Paths of the apis, below their base urls, see APIBaseURLs.
+	APIPathGetFiles       = "/files"
+	APIPathGetDownloadURL = "/app/chrome/downurl"
+	APIPathGetDirID       = "/files/getid"
+	APIPathDeleteFile     = "/rb/delete"
+	APIPathAddDir         = "/files/add"
+	APIPathMoveFile       = "/files/move"
+	APIPathCopyFile       = "/files/copy"
+	APIPathRenameFile     = "/files/batch_rename"
+	APIPathLoginCheck     = "/app/1.0/web/1.0/check/sso"
+	APIPathUploadInfo     = "/app/uploadinfo"
+	APIPathInitUpload     = "/3.0/initupload.php"
+	APIPathGetUploadToken = "/3.0/gettoken.php"
+	APIPathLifeList       = "/api/1.0/web/1.0/life/life_list"
+
+	APIURLQRCodeToken  = "https://qrcodeapi.115.com/api/1.0/%s/1.0/token/"
+	APIURLQRCodeStatus = "https://qrcodeapi.115.com/get/status/"
+	APIURLQRCodeImage  = "https://qrcodeapi.115.com/api/1.0/%s/1.0/qrcode"
+	APIURLQRCodeLogin  = "https://passportapi.115.com/app/1.0/%s/1.0/login/qrcode/"
+
+	UploadAppVersion = "2.0.0.0"
+	uploadTokenSalt  = "Qclm8MGWUv59TnrR0XPg"
 )
 
-func APIGetFiles(client *resty.Client, cid string, pageSize int64, offset int64) (*APIGetFilesResp, error) {
+// APIBaseURLs are the base urls of the 115 apis called by a DriveClient,
+// see WithAPIBaseURLs.
+type APIBaseURLs struct {
+	WebAPI      string
+	ProAPI      string
+	PassportAPI string
+	UploadAPI   string
+	LifeAPI     string
+}
+
+// DefaultAPIBaseURLs are the base urls of the apis of 115.
+var DefaultAPIBaseURLs = APIBaseURLs{
+	WebAPI:      "https://webapi.115.com",
+	ProAPI:      "https://proapi.115.com",
+	PassportAPI: "https://passportapi.115.com",
+	UploadAPI:   "https://uplb.115.com",
+	LifeAPI:     "https://life.115.com",
+}
+
+func is115Host(host string) bool {
+	return host == "115.com" || strings.HasSuffix(host, ".115.com")
+}
+
+func APIGetFiles(ctx context.Context, client *resty.Client, baseURL string, cid string, pageSize int64, offset int64) (*APIGetFilesResp, error) {
 	result := APIGetFilesResp{}
 	_, err := client.R().
+		SetContext(ctx).
 		SetQueryParams(map[string]string{
 			"aid":              "1",
 			"cid":              cid,
@@ -42,36 +85,50 @@ func APIGetFiles(client *resty.Client, cid string, pageSize int64, offset int64)
 		}).
 		SetResult(&result).
 		ForceContentType("application/json").
-		Get(APIURLGetFiles)
+		Get(baseURL + APIPathGetFiles)
 	if err != nil {
-		return nil, fmt.Errorf("api get files fail, err: %v", err)
+		return nil, fmt.Errorf("api get files fail, err: %w", err)
 	}
 
 	return &result, nil
 }
 
-func APIGetDownloadURL(client *resty.Client, pickCode string) (*DownloadInfo, error) {
+// APIGetDownloadURL returns the download url of pickCode. The url is bound to
+// the User-Agent of the request, which is userAgent if not empty. serverKey
+// is the RSA key of the api server, nil for the key of 115.
+func APIGetDownloadURL(ctx context.Context, client *resty.Client, baseURL string, serverKey *rsa.PublicKey, pickCode string, userAgent string) (*DownloadInfo, error) {
+	if serverKey == nil {
+		serverKey = rsaServerKey
+	}
 	key := GenerateKey()
 	params, _ := json.Marshal(map[string]string{"pickcode": pickCode})
 
+	req := client.R().SetContext(ctx)
+	if len(userAgent) > 0 {
+		req.SetHeader("User-Agent", userAgent)
+	}
 	result := APIBaseResp{}
-	_, err := client.R().
+	_, err := req.
 		SetQueryParam("t", strconv.FormatInt(time.Now().Unix(), 10)).
 		SetFormData(map[string]string{
-			"data": string(Encode(params, key)),
+			"data": EncodeWithKey(params, key, serverKey),
 		}).
 		SetResult(&result).
 		ForceContentType("application/json").
-		Post(APIURLGetDownloadURL)
+		Post(baseURL + APIPathGetDownloadURL)
 	if err != nil {
-		return nil, fmt.Errorf("api get download url fail, err: %v", err)
+		return nil, fmt.Errorf("api get download url fail, err: %w", err)
+	}
+
+	if !result.State {
+		return nil, newAPIError("api get download url", result.Errno, result.Msg)
 	}
 
 	var encodedData string
 	if err = json.Unmarshal(result.Data, &encodedData); err != nil {
 		return nil, fmt.Errorf("api get download url, call json.Unmarshal fail, body: %s", string(result.Data))
 	}
-	decodedData, err := Decode(encodedData, key)
+	decodedData, err := DecodeWithKey(encodedData, key, serverKey)
 	if err != nil {
 		return nil, fmt.Errorf("api get download url, call Decode fail, err: %w", err)
 	}
@@ -92,27 +149,29 @@ func APIGetDownloadURL(client *resty.Client, pickCode string) (*DownloadInfo, er
 	return nil, nil
 }
 
-func APIGetDirID(client *resty.Client, dir string) (*APIGetDirIDResp, error) {
+func APIGetDirID(ctx context.Context, client *resty.Client, baseURL string, dir string) (*APIGetDirIDResp, error) {
 	if strings.HasPrefix(dir, "/") {
 		dir = dir[1:]
 	}
 
 	result := APIGetDirIDResp{}
 	_, err := client.R().
+		SetContext(ctx).
 		SetQueryParam("path", dir).
 		SetResult(&result).
 		ForceContentType("application/json").
-		Get(APIURLGetDirID)
+		Get(baseURL + APIPathGetDirID)
 	if err != nil {
-		return nil, fmt.Errorf("api get dir id fail, err: %v", err)
+		return nil, fmt.Errorf("api get dir id fail, err: %w", err)
 	}
 
 	return &result, nil
 }
 
-func APIDeleteFile(client *resty.Client, fid string, pid string) (*APIDeleteFileResp, error) {
+func APIDeleteFile(ctx context.Context, client *resty.Client, baseURL string, fid string, pid string) (*APIDeleteFileResp, error) {
 	result := APIDeleteFileResp{}
 	_, err := client.R().
+		SetContext(ctx).
 		SetFormData(map[string]string{
 			"fid[0]":      fid,
 			"pid":         pid,
@@ -120,75 +179,269 @@ func APIDeleteFile(client *resty.Client, fid string, pid string) (*APIDeleteFile
 		}).
 		SetResult(&result).
 		ForceContentType("application/json").
-		Post(APIURLDeleteFile)
+		Post(baseURL + APIPathDeleteFile)
 	if err != nil {
-		return nil, fmt.Errorf("api delete file fail, err: %v", err)
+		return nil, fmt.Errorf("api delete file fail, err: %w", err)
 	}
 
 	return &result, nil
 }
 
-func APIAddDir(client *resty.Client, pid string, cname string) (*APIAddDirResp, error) {
+func APIAddDir(ctx context.Context, client *resty.Client, baseURL string, pid string, cname string) (*APIAddDirResp, error) {
 	result := APIAddDirResp{}
 	_, err := client.R().
+		SetContext(ctx).
 		SetFormData(map[string]string{
 			"pid":   pid,
 			"cname": cname,
 		}).
 		SetResult(&result).
 		ForceContentType("application/json").
-		Post(APIURLAddDir)
+		Post(baseURL + APIPathAddDir)
 	if err != nil {
-		return nil, fmt.Errorf("api add dir fail, err: %v", err)
+		return nil, fmt.Errorf("api add dir fail, err: %w", err)
 	}
 
 	return &result, nil
 }
 
-func APIMoveFile(client *resty.Client, fid string, pid string) (*APIMoveFileResp, error) {
+func APIMoveFile(ctx context.Context, client *resty.Client, baseURL string, fid string, pid string) (*APIMoveFileResp, error) {
 	result := APIMoveFileResp{}
 	_, err := client.R().
+		SetContext(ctx).
+		SetFormData(map[string]string{
+			"fid[0]": fid,
+			"pid":    pid,
+		}).
+		SetResult(&result).
+		ForceContentType("application/json").
+		Post(baseURL + APIPathMoveFile)
+	if err != nil {
+		return nil, fmt.Errorf("api move file fail, err: %w", err)
+	}
+
+	return &result, nil
+}
+
+func APICopyFile(ctx context.Context, client *resty.Client, baseURL string, fid string, pid string) (*APICopyFileResp, error) {
+	result := APICopyFileResp{}
+	_, err := client.R().
+		SetContext(ctx).
 		SetFormData(map[string]string{
 			"fid[0]": fid,
 			"pid":    pid,
 		}).
 		SetResult(&result).
 		ForceContentType("application/json").
-		Post(APIURLMoveFile)
+		Post(baseURL + APIPathCopyFile)
 	if err != nil {
-		return nil, fmt.Errorf("api move file fail, err: %v", err)
+		return nil, fmt.Errorf("api copy file fail, err: %w", err)
 	}
 
 	return &result, nil
 }
 
-func APIRenameFile(client *resty.Client, fid string, name string) (*APIRenameFileResp, error) {
+func APIRenameFile(ctx context.Context, client *resty.Client, baseURL string, fid string, name string) (*APIRenameFileResp, error) {
 	result := APIRenameFileResp{}
 	_, err := client.R().
+		SetContext(ctx).
 		SetFormData(map[string]string{
 			fmt.Sprintf("files_new_name[%s]", fid): name,
 		}).
 		SetResult(&result).
 		ForceContentType("application/json").
-		Post(APIURLRenameFile)
+		Post(baseURL + APIPathRenameFile)
 	if err != nil {
-		return nil, fmt.Errorf("api rename file fail, err: %v", err)
+		return nil, fmt.Errorf("api rename file fail, err: %w", err)
 	}
 
 	return &result, nil
 
 }
 
-func APILoginCheck(client *resty.Client) (int64, error) {
+func APILoginCheck(ctx context.Context, client *resty.Client, baseURL string) (int64, error) {
 	result := APILoginCheckResp{}
 	_, err := client.R().
+		SetContext(ctx).
 		SetResult(&result).
 		ForceContentType("application/json").
-		Get(APIURLLoginCheck)
+		Get(baseURL + APIPathLoginCheck)
 	if err != nil {
-		return 0, fmt.Errorf("api login check fail, err: %v", err)
+		return 0, fmt.Errorf("api login check fail, err: %w", err)
 	}
 
 	userID, _ := result.Data.UserID.Int64()
 	return userID, nil
 }
+
+func APIGetUploadInfo(ctx context.Context, client *resty.Client, baseURL string) (*APIGetUploadInfoResp, error) {
+	result := APIGetUploadInfoResp{}
+	_, err := client.R().
+		SetContext(ctx).
+		SetResult(&result).
+		ForceContentType("application/json").
+		Get(baseURL + APIPathUploadInfo)
+	if err != nil {
+		return nil, fmt.Errorf("api get upload info fail, err: %w", err)
+	}
+
+	return &result, nil
+}
+
+func APIInitUpload(ctx context.Context, client *resty.Client, baseURL string, userID string, userKey string, cid string, fileName string, fileSize int64, sha1Sum string, preID string, signKey string, signVal string) (*APIInitUploadResp, error) {
+	target := "U_1_" + cid
+	fileSizeStr := strconv.FormatInt(fileSize, 10)
+	t := strconv.FormatInt(time.Now().Unix(), 10)
+
+	formData := map[string]string{
+		"appid":      "0",
+		"appfrom":    "10",
+		"appversion": UploadAppVersion,
+		"format":     "json",
+		"isp":        "0",
+		"userid":     userID,
+		"filename":   fileName,
+		"filesize":   fileSizeStr,
+		"fileid":     sha1Sum,
+		"quickid":    sha1Sum,
+		"preid":      preID,
+		"target":     target,
+		"sig":        uploadSig(userID, userKey, sha1Sum, target),
+		"t":          t,
+		"token":      uploadToken(userID, sha1Sum, fileSizeStr, signKey, signVal, t),
+	}
+	if len(signKey) > 0 {
+		formData["sign_key"] = signKey
+		formData["sign_val"] = signVal
+	}
+
+	result := APIInitUploadResp{}
+	_, err := client.R().
+		SetContext(ctx).
+		SetFormData(formData).
+		SetResult(&result).
+		ForceContentType("application/json").
+		Post(baseURL + APIPathInitUpload)
+	if err != nil {
+		return nil, fmt.Errorf("api init upload fail, err: %w", err)
+	}
+
+	return &result, nil
+}
+
+func APIGetUploadToken(ctx context.Context, client *resty.Client, baseURL string) (*APIGetUploadTokenResp, error) {
+	result := APIGetUploadTokenResp{}
+	_, err := client.R().
+		SetContext(ctx).
+		SetResult(&result).
+		ForceContentType("application/json").
+		Get(baseURL + APIPathGetUploadToken)
+	if err != nil {
+		return nil, fmt.Errorf("api get upload token fail, err: %w", err)
+	}
+	if result.StatusCode != "200" {
+		return nil, fmt.Errorf("api get upload token fail, status_code: %s", result.StatusCode)
+	}
+
+	return &result, nil
+}
+
+func APIGetQRCodeToken(client *resty.Client, app string) (*APIGetQRCodeTokenResp, error) {
+	result := APIGetQRCodeTokenResp{}
+	_, err := client.R().
+		SetResult(&result).
+		ForceContentType("application/json").
+		Get(fmt.Sprintf(APIURLQRCodeToken, app))
+	if err != nil {
+		return nil, fmt.Errorf("api get qrcode token fail, err: %w", err)
+	}
+
+	return &result, nil
+}
+
+func APIGetQRCodeStatus(client *resty.Client, uid string, t string, sign string) (*APIGetQRCodeStatusResp, error) {
+	result := APIGetQRCodeStatusResp{}
+	_, err := client.R().
+		SetQueryParams(map[string]string{
+			"uid":  uid,
+			"time": t,
+			"sign": sign,
+			"_":    strconv.FormatInt(time.Now().Unix(), 10),
+		}).
+		SetResult(&result).
+		ForceContentType("application/json").
+		Get(APIURLQRCodeStatus)
+	if err != nil {
+		return nil, fmt.Errorf("api get qrcode status fail, err: %w", err)
+	}
+
+	return &result, nil
+}
+
+func APIGetQRCodeImage(client *resty.Client, app string, uid string) ([]byte, error) {
+	resp, err := client.R().
+		SetQueryParam("uid", uid).
+		Get(fmt.Sprintf(APIURLQRCodeImage, app))
+	if err != nil {
+		return nil, fmt.Errorf("api get qrcode image fail, err: %w", err)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("api get qrcode image fail, http status: %d", resp.StatusCode())
+	}
+
+	return resp.Body(), nil
+}
+
+func APIQRCodeLogin(client *resty.Client, app string, uid string) (*APIQRCodeLoginResp, error) {
+	result := APIQRCodeLoginResp{}
+	_, err := client.R().
+		SetFormData(map[string]string{
+			"account": uid,
+			"app":     app,
+		}).
+		SetResult(&result).
+		ForceContentType("application/json").
+		Post(fmt.Sprintf(APIURLQRCodeLogin, app))
+	if err != nil {
+		return nil, fmt.Errorf("api qrcode login fail, err: %w", err)
+	}
+
+	return &result, nil
+}
+
+// APIGetLifeList returns the file events of the account since startTime, a
+// unix time, newest first, skipping the first start events.
+func APIGetLifeList(ctx context.Context, client *resty.Client, baseURL string, startTime int64, start int64, limit int64) (*APIGetLifeListResp, error) {
+	result := APIGetLifeListResp{}
+	_, err := client.R().
+		SetContext(ctx).
+		SetQueryParams(map[string]string{
+			"start":      strconv.FormatInt(start, 10),
+			"limit":      strconv.FormatInt(limit, 10),
+			"show_type":  "0",
+			"type":       "0",
+			"tab_type":   "0",
+			"start_time": strconv.FormatInt(startTime, 10),
+			"end_time":   strconv.FormatInt(time.Now().Unix(), 10),
+		}).
+		SetResult(&result).
+		ForceContentType("application/json").
+		Get(baseURL + APIPathLifeList)
+	if err != nil {
+		return nil, fmt.Errorf("api get life list fail, err: %w", err)
+	}
+
+	return &result, nil
+}
+
+func uploadSig(userID string, userKey string, fileID string, target string) string {
+	h := sha1.Sum([]byte(userID + fileID + target + "0"))
+	sig := sha1.Sum([]byte(userKey + hex.EncodeToString(h[:]) + "000000"))
+	return strings.ToUpper(hex.EncodeToString(sig[:]))
+}
+
+func uploadToken(userID string, fileID string, fileSize string, signKey string, signVal string, t string) string {
+	userIDHash := md5.Sum([]byte(userID))
+	token := md5.Sum([]byte(uploadTokenSalt + fileID + fileSize + signKey + signVal + userID + t + hex.EncodeToString(userIDHash[:]) + UploadAppVersion))
+	return hex.EncodeToString(token[:])
+}
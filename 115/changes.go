@@ -0,0 +1,188 @@
+package _115
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common/metastore"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	lifeListPageSize = 100
+	// lifeListMaxPages bounds a single poll, a longer backlog of events
+	// drops the whole cache instead.
+	lifeListMaxPages = 20
+)
+
+// ignoredBehaviors are the events that do not change any listing.
+var ignoredBehaviors = map[string]bool{
+	"browse_image":    true,
+	"browse_video":    true,
+	"browse_audio":    true,
+	"browse_document": true,
+	"star_image":      true,
+	"star_file":       true,
+	"folder_label":    true,
+}
+
+// WatchChanges polls the file event log of the account every interval, and
+// drops the cached listings of the dirs that changed, so that changes made
+// outside of this server, such as in the 115 app, show up without waiting
+// for the cache to expire. With a metadata store, the position in the log
+// is stored, and the changes made while the server was down are applied at
+// the first poll. WatchChanges never returns.
+func (c *DriveClient) WatchChanges(interval time.Duration) {
+	c.changes.reset(c.metaStore)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cursor, seen, gen := c.changes.get()
+		next, nextSeen, err := c.pollChanges(cursor, seen)
+		if err != nil {
+			logrus.WithError(err).Warnf("poll 115 file changes fail")
+		} else {
+			c.changes.set(gen, next, nextSeen, c.metaStore)
+		}
+		<-ticker.C
+	}
+}
+
+// changeCursor is the position of WatchChanges in the file event log: the
+// unix time of the newest event applied, and the events of that second.
+type changeCursor struct {
+	mu     sync.Mutex
+	cursor int64
+	seen   map[string]bool
+	// gen counts the resets, a poll started before a reset does not move
+	// the cursor.
+	gen int
+}
+
+// reset moves the cursor to the one of store, or to now if there is none.
+func (cc *changeCursor) reset(store *metastore.Store) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.cursor = time.Now().Unix()
+	if store != nil {
+		store.Get("life_cursor", &cc.cursor)
+	}
+	cc.seen = make(map[string]bool)
+	cc.gen++
+}
+
+func (cc *changeCursor) get() (int64, map[string]bool, int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.cursor, cc.seen, cc.gen
+}
+
+// set moves the cursor after a poll started at gen, and saves it to store.
+func (cc *changeCursor) set(gen int, cursor int64, seen map[string]bool, store *metastore.Store) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if gen != cc.gen {
+		return
+	}
+	cc.cursor, cc.seen = cursor, seen
+	if store != nil {
+		if err := store.Put("life_cursor", cursor); err != nil {
+			logrus.WithError(err).Errorf("call store.Put fail, key: life_cursor")
+		}
+	}
+}
+
+// pollChanges applies the events since cursor, a unix time, skipping those
+// in seen, which were applied by the previous poll. It returns the time of
+// the newest event and the events of that second.
+func (c *DriveClient) pollChanges(cursor int64, seen map[string]bool) (int64, map[string]bool, error) {
+	events := make([]LifeEvent, 0)
+	for page := 0; ; page++ {
+		if page == lifeListMaxPages {
+			logrus.Warnf("too many 115 file changes since %d, drop all cached listings", cursor)
+			c.forgetTree("/")
+			return time.Now().Unix(), make(map[string]bool), nil
+		}
+
+		resp, err := APIGetLifeList(context.Background(), c.HttpClient, c.apiBaseURLs.LifeAPI, cursor, int64(page*lifeListPageSize), lifeListPageSize)
+		if err != nil {
+			return cursor, seen, err
+		}
+		if !resp.State {
+			return cursor, seen, newAPIError("get life list", resp.ErrNo, resp.Error)
+		}
+		events = append(events, resp.Data.List...)
+		// Events come newest first, a page that reaches the cursor is
+		// the last one needed.
+		if len(resp.Data.List) < lifeListPageSize {
+			break
+		}
+		if t, _ := resp.Data.List[len(resp.Data.List)-1].UpdateTime.Int64(); t < cursor {
+			break
+		}
+	}
+
+	next, nextSeen := cursor, make(map[string]bool)
+	for _, event := range events {
+		t, _ := event.UpdateTime.Int64()
+		key := eventKey(event)
+		if t < cursor || (t == cursor && seen[key]) {
+			continue
+		}
+		if t > next {
+			next, nextSeen = t, make(map[string]bool)
+		}
+		if t == next {
+			nextSeen[key] = true
+		}
+		if !ignoredBehaviors[event.BehaviorType] {
+			c.applyChange(event)
+		}
+	}
+	// Events of the cursor second that were not returned again still
+	// count as seen.
+	if next == cursor {
+		for key := range seen {
+			nextSeen[key] = true
+		}
+	}
+	return next, nextSeen, nil
+}
+
+// applyChange drops the cached listings affected by event: the dirs the
+// items were in, the dirs they are in now, and the items themselves when
+// they are dirs that moved or were removed. Items and dirs missing from the
+// index have no cached listing to drop. Large operations list only some of
+// their items, the others may be anywhere, so all the cached listings and
+// ids are dropped.
+func (c *DriveClient) applyChange(event LifeEvent) {
+	if total, err := event.Total.Int64(); err == nil && total > int64(len(event.Items)) {
+		logrus.Infof("115 files changed, behavior: %s, total: %d, drop all cached listings", event.BehaviorType, total)
+		c.forgetTree("/")
+		return
+	}
+	for _, item := range event.Items {
+		if p, ok := c.index.lookup(item.FileID.String(), item.IsDir()); ok {
+			logrus.Infof("115 file changed, behavior: %s, path: %s", event.BehaviorType, p)
+			c.flushDir(path.Dir(p))
+			if item.IsDir() {
+				c.forgetTree(p)
+			}
+		}
+		if dir, ok := c.index.lookup(item.ParentID.String(), true); ok {
+			c.flushDir(dir)
+		}
+	}
+}
+
+func eventKey(event LifeEvent) string {
+	ids := make([]string, 0, len(event.Items))
+	for _, item := range event.Items {
+		ids = append(ids, item.FileID.String())
+	}
+	return event.BehaviorType + ":" + event.UpdateTime.String() + ":" + strings.Join(ids, ",")
+}
@@ -0,0 +1,111 @@
+package _115
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common/metastore"
+)
+
+func TestChangeCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changes-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := metastore.Open(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := changeCursor{}
+	cc.reset(store)
+	cursor, _, gen := cc.get()
+	if now := time.Now().Unix(); cursor < now-1 || cursor > now {
+		t.Errorf("reset with an empty store: got cursor %d, want now", cursor)
+	}
+
+	cc.set(gen, 100, map[string]bool{"event": true}, store)
+	if cursor, seen, _ := cc.get(); cursor != 100 || !seen["event"] {
+		t.Errorf("set: got %d, %v", cursor, seen)
+	}
+	var stored int64
+	if store.Get("life_cursor", &stored); stored != 100 {
+		t.Errorf("set: got stored cursor %d, want 100", stored)
+	}
+
+	// A poll started before a reset does not move the cursor.
+	cc.reset(store)
+	if cursor, seen, _ := cc.get(); cursor != 100 || len(seen) != 0 {
+		t.Errorf("reset: got %d, %v, want the stored cursor 100", cursor, seen)
+	}
+	cc.set(gen, 200, nil, store)
+	if cursor, _, _ := cc.get(); cursor != 100 {
+		t.Errorf("set of a poll started before a reset: got cursor %d, want 100", cursor)
+	}
+
+	// The store of another account is emptied.
+	store.DeletePrefix("")
+	cc.reset(store)
+	if cursor, _, _ := cc.get(); cursor == 100 {
+		t.Errorf("reset with an emptied store: got the cursor of the previous account")
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+	c := newThrottledClient(t, &throttlingServer{})
+	// The dir /a/d and the file /a/f share the id 7.
+	c.index.addListing("/a", "5", []FileInfo{
+		{CategoryID: "7", Name: "d"},
+		{CategoryID: "5", FileID: "7", Name: "f"},
+	})
+	c.index.addListing("/a/d", "7", nil)
+	listed := func(dir string) bool { return c.cache.Has("files:" + dir) }
+	list := func() {
+		for _, dir := range []string{"/", "/a", "/a/d"} {
+			c.cache.Set("files:"+dir, []FileInfo{})
+		}
+	}
+
+	list()
+	c.applyChange(LifeEvent{BehaviorType: "new_file", Items: []LifeEventItem{{FileID: "7", ParentID: "5", FileCategory: "1"}}})
+	if listed("/a") || !listed("/a/d") || !listed("/") {
+		t.Errorf("change of the file: got listings /, /a, /a/d %v, %v, %v, want only /a dropped", listed("/"), listed("/a"), listed("/a/d"))
+	}
+	if _, ok := c.index.dirID("/a/d"); !ok {
+		t.Errorf("change of the file: the dir with the same id was forgotten")
+	}
+
+	list()
+	c.applyChange(LifeEvent{BehaviorType: "move_file", Items: []LifeEventItem{{FileID: "7", ParentID: "5", FileCategory: "0"}}})
+	if listed("/a") || listed("/a/d") || !listed("/") {
+		t.Errorf("change of the dir: got listings /, /a, /a/d %v, %v, %v, want /a and /a/d dropped", listed("/"), listed("/a"), listed("/a/d"))
+	}
+	if _, ok := c.index.dirID("/a/d"); ok {
+		t.Errorf("change of the dir: the dir is still known")
+	}
+}
+
+func TestApplyTruncatedChange(t *testing.T) {
+	c := newThrottledClient(t, &throttlingServer{})
+	c.index.addListing("/a", "5", []FileInfo{{CategoryID: "7", Name: "d"}})
+	c.index.addListing("/a/d", "7", nil)
+	c.index.addListing("/b", "6", nil)
+	for _, dir := range []string{"/", "/a", "/a/d", "/b"} {
+		c.cache.Set("files:"+dir, []FileInfo{})
+	}
+
+	// Only the first of the 2 moved files is listed.
+	c.applyChange(LifeEvent{BehaviorType: "move_file", Total: "2", Items: []LifeEventItem{{FileID: "9", ParentID: "6", FileCategory: "1"}}})
+	for _, dir := range []string{"/", "/a", "/a/d", "/b"} {
+		if c.cache.Has("files:" + dir) {
+			t.Errorf("truncated change: the listing of %s was kept", dir)
+		}
+	}
+	if _, ok := c.index.dirID("/a/d"); ok {
+		t.Errorf("truncated change: the id of /a/d was kept")
+	}
+}
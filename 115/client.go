@@ -2,61 +2,97 @@ package _115
 
 import (
 	"context"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bluele/gcache"
 	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/gaoyb7/115drive-webdav/common/blockcache"
 	"github.com/gaoyb7/115drive-webdav/common/drive"
+	"github.com/gaoyb7/115drive-webdav/common/metastore"
 	"github.com/go-resty/resty/v2"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
 )
 
+const (
+	// defaultDirCacheExpire is how long listings are cached in memory by
+	// default, and after which stored listings are refreshed.
+	defaultDirCacheExpire = time.Minute * 2
+	// urlCacheExpire is how long download urls are cached.
+	urlCacheExpire = time.Minute * 2
+)
+
+// dirListing is a listing as kept in the metadata store.
+type dirListing struct {
+	ID    string     `json:"id"`
+	Files []FileInfo `json:"files"`
+}
+
 type DriveClient struct {
 	HttpClient   *resty.Client
 	cache        gcache.Cache
 	reserveProxy *httputil.ReverseProxy
-	limiter      *rate.Limiter
-}
-
-func MustNew115DriveClient(uid string, cid string, seid string, kid string) *DriveClient {
-	httpClient := resty.New().SetCookie(&http.Cookie{
-		Name:     "UID",
-		Value:    uid,
-		Domain:   "www.115.com",
-		Path:     "/",
-		HttpOnly: true,
-	}).SetCookie(&http.Cookie{
-		Name:     "CID",
-		Value:    cid,
-		Domain:   "www.115.com",
-		Path:     "/",
-		HttpOnly: true,
-	}).SetCookie(&http.Cookie{
-		Name:     "SEID",
-		Value:    seid,
-		Domain:   "www.115.com",
-		Path:     "/",
-		HttpOnly: true,
-	}).SetCookie(&http.Cookie{
-		Name:     "KID",
-		Value:    kid,
-		Domain:   "www.115.com",
-		Path:     "/",
-		HttpOnly: true,
-	}).SetHeader("User-Agent", UserAgent)
+	// apiLimiter and urlLimiter pace the api calls, download urls apart,
+	// contentLimiter the content fetched from the CDN.
+	apiLimiter     *adaptiveLimiter
+	urlLimiter     *adaptiveLimiter
+	contentLimiter *rate.Limiter
+	jar            *sessionJar
+	// apiBaseURLs are the servers of the api calls, see WithAPIBaseURLs,
+	// ossEndpoint the one of uploads, empty for the buckets of 115, see
+	// WithOSSEndpoint.
+	apiBaseURLs APIBaseURLs
+	ossEndpoint string
+	// serverKey is the RSA key of the download url api, nil for the key of
+	// 115, see WithServerKey.
+	serverKey *rsa.PublicKey
+
+	sessionMu            sync.RWMutex
+	session              SessionState
+	sessionCheckInterval time.Duration
+	reloadCookies        func() (*Cookies, error)
+
+	redirectUsers      map[string]bool
+	redirectUserAgents []string
+
+	connections int
+	chunkSize   int64
+	blockCache  *blockcache.Cache
+	readAhead   int64
+	readStreams *readStreamRegistry
+
+	metaStore      *metastore.Store
+	revalidating   sync.Map
+	dirCacheExpire time.Duration
+	index          *dirIndex
+	changeInterval time.Duration
+	changes        changeCursor
+}
+
+func MustNew115DriveClient(uid string, cid string, seid string, kid string, opts ...Option) *DriveClient {
+	jar := newSessionJar(Cookies{UID: uid, CID: cid, SEID: seid, KID: kid})
+	httpClient := resty.New().SetCookieJar(jar).SetHeader("User-Agent", UserAgent)
 
 	client := &DriveClient{
-		HttpClient: httpClient,
-		cache:      gcache.New(10000).LFU().Build(),
-		limiter:    rate.NewLimiter(5, 1),
+		HttpClient:     httpClient,
+		jar:            jar,
+		apiBaseURLs:    DefaultAPIBaseURLs,
+		cache:          gcache.New(10000).LFU().Build(),
+		apiLimiter:     newAdaptiveLimiter("api", defaultRateLimit, 1),
+		urlLimiter:     newAdaptiveLimiter("download url", defaultRateLimit, 1),
+		contentLimiter: newLimiter(defaultRateLimit, 1),
+		dirCacheExpire: defaultDirCacheExpire,
+		index:          newDirIndex(),
 		reserveProxy: &httputil.ReverseProxy{
 			Transport: httpClient.GetClient().Transport,
 			Director: func(req *http.Request) {
@@ -66,57 +102,231 @@ func MustNew115DriveClient(uid string, cid string, seid string, kid string) *Dri
 			},
 		},
 	}
+	client.setupRetry()
+	for _, opt := range opts {
+		opt(client)
+	}
 
-	// login check
-	userID, err := APILoginCheck(client.HttpClient)
-	if err != nil || userID <= 0 {
-		logrus.WithError(err).Panicf("115 drive login fail")
+	// login check, with a session monitor the client can wait for new
+	// cookies instead
+	if state := client.CheckSession(); !state.LoggedIn {
+		if client.sessionCheckInterval <= 0 {
+			logrus.Panicf("115 drive login fail, err: %s", state.Error)
+		}
+		logrus.Warnf("115 drive login fail, waiting for new cookies")
+	}
+	if client.sessionCheckInterval > 0 {
+		go client.MonitorSession(client.sessionCheckInterval, client.reloadCookies)
+	}
+	client.loadIndex()
+	if client.changeInterval > 0 {
+		go client.WatchChanges(client.changeInterval)
 	}
-	logrus.Infof("115 drive login succ, user_id: %d", userID)
 
 	return client
 }
 
-func (c *DriveClient) GetFiles(dir string) ([]drive.File, error) {
+func (c *DriveClient) GetFiles(ctx context.Context, dir string) ([]drive.File, error) {
 	dir = slashClean(dir)
 	cacheKey := fmt.Sprintf("files:%s", dir)
 	if value, err := c.cache.Get(cacheKey); err == nil {
 		return value.([]drive.File), nil
 	}
 
-	c.limiter.Wait(context.Background())
-	getDirIDResp, err := APIGetDirID(c.HttpClient, dir)
+	// A listing from the metadata store is served right away, and refreshed
+	// in the background once older than the memory cache expiry.
+	if c.metaStore != nil {
+		listing := dirListing{}
+		if updatedAt, ok := c.metaStore.Get(cacheKey, &listing); ok {
+			files := c.cacheListing(dir, listing)
+			if time.Since(updatedAt) > c.dirCacheExpire {
+				// Not bound to ctx, the refresh outlives the request.
+				go c.revalidateDir(dir)
+			}
+			return files, nil
+		}
+	}
+
+	return c.listDir(ctx, dir)
+}
+
+// loadIndex adds the listings of the metadata store to the index, so that
+// changes to them are seen before they are listed again.
+func (c *DriveClient) loadIndex() {
+	if c.metaStore == nil {
+		return
+	}
+	for _, key := range c.metaStore.Keys("files:") {
+		listing := dirListing{}
+		if _, ok := c.metaStore.Get(key, &listing); ok {
+			c.index.addListing(strings.TrimPrefix(key, "files:"), listing.ID, listing.Files)
+		}
+	}
+}
+
+// listDir fetches the listing of dir from 115 and caches it.
+func (c *DriveClient) listDir(ctx context.Context, dir string) ([]drive.File, error) {
+	listing, err := c.fetchFiles(ctx, dir)
 	if err != nil {
 		return nil, err
 	}
-	cid := getDirIDResp.CategoryID.String()
 
+	files := c.cacheListing(dir, *listing)
+	if c.metaStore != nil {
+		if err := c.metaStore.Put(fmt.Sprintf("files:%s", dir), listing); err != nil {
+			logrus.WithError(err).Errorf("call c.metaStore.Put fail, dir: %s", dir)
+		}
+	}
+
+	return files, nil
+}
+
+// cacheListing keeps the listing of dir in the memory cache and the index.
+func (c *DriveClient) cacheListing(dir string, listing dirListing) []drive.File {
+	files := make([]drive.File, 0, len(listing.Files))
+	for idx := range listing.Files {
+		files = append(files, &listing.Files[idx])
+	}
+	if err := c.cache.SetWithExpire(fmt.Sprintf("files:%s", dir), files, c.dirCacheExpire); err != nil {
+		logrus.WithError(err).Errorf("call c.cache.SetWithExpire fail, dir: %s", dir)
+	}
+	c.index.addListing(dir, listing.ID, listing.Files)
+	return files
+}
+
+// revalidateDir refreshes the stored listing of dir, unless a refresh is
+// already running.
+func (c *DriveClient) revalidateDir(dir string) {
+	if _, running := c.revalidating.LoadOrStore(dir, true); running {
+		return
+	}
+	defer c.revalidating.Delete(dir)
+
+	if _, err := c.listDir(context.Background(), dir); err != nil {
+		if errors.Is(err, common.ErrNotFound) {
+			c.forgetTree(dir)
+			return
+		}
+		logrus.WithError(err).Warnf("revalidate dir fail, dir: %s", dir)
+	}
+}
+
+// fetchFiles fetches the files of dir, page by page. The dir id comes from
+// the index when known, it is checked against the path returned with the
+// files, so that a dir moved or deleted outside of this server is looked up
+// again.
+func (c *DriveClient) fetchFiles(ctx context.Context, dir string) (*dirListing, error) {
+	cid, indexed, err := c.getDirID(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	infos, fetchedPath, err := c.fetchFilesByID(ctx, cid)
+	if indexed && dir != "/" && ctx.Err() == nil && (err != nil || (len(fetchedPath) > 0 && fetchedPath != dir)) {
+		c.index.removeTree(dir)
+		if cid, err = c.lookupDirID(ctx, dir); err != nil {
+			return nil, err
+		}
+		infos, _, err = c.fetchFilesByID(ctx, cid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dirListing{ID: cid, Files: infos}, nil
+}
+
+// fetchFilesByID fetches the files of the dir with id cid, and returns the
+// path of the dir along with them.
+func (c *DriveClient) fetchFilesByID(ctx context.Context, cid string) ([]FileInfo, string, error) {
 	pageSize := int64(1000)
 	offset := int64(0)
-	files := make([]drive.File, 0)
+	infos := make([]FileInfo, 0)
+	dirPath := ""
 	for {
-		resp, err := APIGetFiles(c.HttpClient, cid, pageSize, offset)
+		resp, err := APIGetFiles(ctx, c.HttpClient, c.apiBaseURLs.WebAPI, cid, pageSize, offset)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-
-		for idx := range resp.Data {
-			files = append(files, &resp.Data[idx])
+		if !resp.State {
+			return nil, "", newAPIError("get files", resp.ErrNo, resp.Error)
 		}
+		if offset == 0 {
+			dirPath = pathOf(resp.Path)
+			c.index.addAncestors(resp.Path)
+		}
+
+		infos = append(infos, resp.Data...)
 
 		offset = resp.Offset + pageSize
 		if offset >= resp.Count {
 			break
 		}
 	}
-	if err := c.cache.SetWithExpire(cacheKey, files, time.Minute*2); err != nil {
-		logrus.WithError(err).Errorf("call c.cache.SetWithExpire fail, dir: %s", dir)
+	return infos, dirPath, nil
+}
+
+// getDirID returns the id of dir, and whether it came from the index. A
+// dir missing from the index whose parent is in it is looked up in the
+// listing of the parent, which is usually cached. Otherwise 115 is asked
+// for the id of the whole path.
+func (c *DriveClient) getDirID(ctx context.Context, dir string) (cid string, indexed bool, err error) {
+	if cid, ok := c.index.dirID(dir); ok {
+		return cid, true, nil
 	}
 
-	return files, nil
+	parent, name := path.Split(dir)
+	parent = slashClean(parent)
+	if _, ok := c.index.dirID(parent); !ok {
+		cid, err := c.lookupDirID(ctx, dir)
+		return cid, false, err
+	}
+	files, err := c.GetFiles(ctx, parent)
+	if err != nil {
+		return "", false, err
+	}
+	for _, file := range files {
+		if file.GetName() == name && file.IsDir() {
+			return file.(*FileInfo).CategoryID.String(), false, nil
+		}
+	}
+	return "", false, common.ErrNotFound
 }
 
-func (c *DriveClient) GetFile(filePath string) (drive.File, error) {
+// lookupDirID asks 115 for the id of dir, and records it in the index.
+func (c *DriveClient) lookupDirID(ctx context.Context, dir string) (string, error) {
+	getDirIDResp, err := APIGetDirID(ctx, c.HttpClient, c.apiBaseURLs.WebAPI, dir)
+	if err != nil {
+		return "", err
+	}
+	cid := getDirIDResp.CategoryID.String()
+	// 115 answers 0, the id of the root, for a dir that does not exist.
+	if cid == "0" || len(cid) == 0 {
+		return "", common.ErrNotFound
+	}
+	c.index.addDir(dir, cid)
+	return cid, nil
+}
+
+// pathOf returns the path of a dir from the path field of its listing, the
+// dirs from the root down to the dir itself. It returns an empty string if
+// the field is empty or incomplete.
+func pathOf(dirs []PathItem) string {
+	if len(dirs) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		if d.CategoryID.String() == "0" {
+			continue
+		}
+		if len(d.Name) == 0 {
+			return ""
+		}
+		names = append(names, d.Name)
+	}
+	return slashClean(strings.Join(names, "/"))
+}
+
+func (c *DriveClient) GetFile(ctx context.Context, filePath string) (drive.File, error) {
 	filePath = slashClean(filePath)
 	if filePath == "/" || len(filePath) == 0 {
 		return &FileInfo{CategoryID: "0"}, nil
@@ -125,7 +335,7 @@ func (c *DriveClient) GetFile(filePath string) (drive.File, error) {
 	filePath = strings.TrimRight(filePath, "/")
 	dir, fileName := path.Split(filePath)
 
-	files, err := c.GetFiles(dir)
+	files, err := c.GetFiles(ctx, dir)
 	if err != nil {
 		return nil, err
 	}
@@ -139,7 +349,27 @@ func (c *DriveClient) GetFile(filePath string) (drive.File, error) {
 }
 
 func (c *DriveClient) ServeContent(w http.ResponseWriter, req *http.Request, fi drive.File) {
-	fileURL, err := c.GetFileURL(fi)
+	if c.shouldRedirect(req) {
+		// The download url only works with the User-Agent it was requested
+		// with, so ask for one bound to the client's User-Agent.
+		fileURL, err := c.getFileURL(req.Context(), fi, req.UserAgent())
+		if err == nil {
+			logrus.Infof("redirect open [name: %v] [url: %v] [user_agent: %v]", fi.GetName(), fileURL, req.UserAgent())
+			http.Redirect(w, req, fileURL, http.StatusFound)
+			return
+		}
+		logrus.WithError(err).Warnf("get redirect url fail, fallback to proxy, name: %s", fi.GetName())
+	}
+
+	if (c.connections > 1 || c.blockCache != nil || c.readStreams != nil) && fi.GetSize() > 0 && req.Method == http.MethodGet {
+		c.serveChunks(w, req, fi)
+		return
+	}
+	c.proxyContent(w, req, fi)
+}
+
+func (c *DriveClient) proxyContent(w http.ResponseWriter, req *http.Request, fi drive.File) {
+	fileURL, err := c.GetFileURL(req.Context(), fi)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
@@ -151,29 +381,50 @@ func (c *DriveClient) ServeContent(w http.ResponseWriter, req *http.Request, fi
 	c.Proxy(w, req, fileURL)
 }
 
-func (c *DriveClient) GetFileURL(file drive.File) (string, error) {
+func (c *DriveClient) shouldRedirect(req *http.Request) bool {
+	if user, _, ok := req.BasicAuth(); ok && c.redirectUsers[user] {
+		return true
+	}
+	userAgent := strings.ToLower(req.UserAgent())
+	for _, ua := range c.redirectUserAgents {
+		if strings.Contains(userAgent, ua) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *DriveClient) GetFileURL(ctx context.Context, file drive.File) (string, error) {
+	return c.getFileURL(ctx, file, "")
+}
+
+// getFileURL returns the download url of file bound to userAgent, or to the
+// client's own User-Agent if userAgent is empty.
+func (c *DriveClient) getFileURL(ctx context.Context, file drive.File, userAgent string) (string, error) {
 	pickCode := file.(*FileInfo).PickCode
-	cacheKey := fmt.Sprintf("url:%s", pickCode)
+	// Keyed by the User-Agent sent to 115, which the url is bound to.
+	if len(userAgent) == 0 {
+		userAgent = UserAgent
+	}
+	cacheKey := fmt.Sprintf("url:%s:%s", pickCode, userAgent)
 	if value, err := c.cache.Get(cacheKey); err == nil {
 		return value.(string), nil
 	}
 
-	c.limiter.Wait(context.Background())
-	info, err := APIGetDownloadURL(c.HttpClient, pickCode)
+	info, err := APIGetDownloadURL(ctx, c.HttpClient, c.apiBaseURLs.ProAPI, c.serverKey, pickCode, userAgent)
 	if err != nil {
 		return "", err
 	}
 
-	if err := c.cache.SetWithExpire(cacheKey, info.URL.URL, time.Minute*2); err != nil {
+	if err := c.cache.SetWithExpire(cacheKey, info.URL.URL, urlCacheExpire); err != nil {
 		logrus.WithError(err).Errorf("call c.cache.SetWithExpire fail, url: %s", info.URL.URL)
 	}
 
 	return info.URL.URL, nil
 }
 
-func (c *DriveClient) RemoveFile(filePath string) error {
-	c.limiter.Wait(context.Background())
-	fi, err := c.GetFile(filePath)
+func (c *DriveClient) RemoveFile(ctx context.Context, filePath string) error {
+	fi, err := c.GetFile(ctx, filePath)
 	if err != nil {
 		return err
 	}
@@ -187,63 +438,167 @@ func (c *DriveClient) RemoveFile(filePath string) error {
 		return err
 	}
 
-	resp, err := APIDeleteFile(c.HttpClient, fid, pid)
+	resp, err := APIDeleteFile(ctx, c.HttpClient, c.apiBaseURLs.WebAPI, fid, pid)
 	if err != nil {
 		return err
 	}
 
 	if !resp.State {
-		return fmt.Errorf("remove file fail, state is false")
+		return newAPIError("remove file", resp.ErrNo, resp.Error)
 	}
 
 	filePath = slashClean(filePath)
 	filePath = strings.TrimRight(filePath, "/")
 	dir, _ := path.Split(filePath)
 	c.flushDir(dir)
+	if fi.IsDir() {
+		c.forgetTree(filePath)
+	}
 
 	return nil
 }
 
-func (c *DriveClient) MakeDir(dir string) error {
-	c.limiter.Wait(context.Background())
-	getDirIDResp, err := APIGetDirID(c.HttpClient, dir)
+func (c *DriveClient) MakeDir(ctx context.Context, dir string) error {
+	dir = slashClean(dir)
+	if _, err := c.GetFile(ctx, dir); err == nil {
+		return common.ErrExists
+	}
+
+	parentDir, name := path.Split(dir)
+	pid, _, err := c.getDirID(ctx, slashClean(parentDir))
 	if err != nil {
 		return err
 	}
-	cid, _ := getDirIDResp.CategoryID.Int64()
-	if cid != 0 {
-		logrus.WithField("dir", dir).Infof("dir exists, ignore")
-		return nil
+	resp, err := APIAddDir(ctx, c.HttpClient, c.apiBaseURLs.WebAPI, pid, name)
+	if err != nil {
+		return err
+	}
+	if !resp.State {
+		return newAPIError("new dir", resp.ErrNo, resp.Error)
 	}
 
-	dir = slashClean(dir)
-	parentDir, name := path.Split(dir)
-	getDirIDResp, err = APIGetDirID(c.HttpClient, parentDir)
+	c.flushDir(parentDir)
+	if cid := resp.CategoryID.String(); len(cid) > 0 && cid != "0" {
+		c.index.addDir(dir, cid)
+	}
+	return nil
+}
+
+// MoveFile moves srcPath to dstPath, which must not exist. A move to
+// another dir under another name takes a move and a rename, in the order
+// that does not clash with the files of the dirs, or fails with
+// common.ErrNameConflict when both orders would.
+func (c *DriveClient) MoveFile(ctx context.Context, srcPath string, dstPath string) error {
+	logrus.Infof("move file, src: %s, dst: %s", srcPath, dstPath)
+
+	srcPath, dstPath = slashClean(srcPath), slashClean(dstPath)
+	if srcPath == "/" || dstPath == "/" {
+		return fmt.Errorf("invalid src_path: %s, dst_path: %s", srcPath, dstPath)
+	}
+	if inTree(dstPath, srcPath) {
+		return fmt.Errorf("%w: can not move %s into itself", common.ErrForbidden, srcPath)
+	}
+
+	fi, err := c.GetFile(ctx, srcPath)
 	if err != nil {
 		return err
 	}
+	fid := fi.(*FileInfo).FileID.String()
+	if fi.IsDir() {
+		fid = fi.(*FileInfo).CategoryID.String()
+	}
+	srcDir, srcFileName := path.Split(srcPath)
+	dstDir, dstFileName := path.Split(dstPath)
 
-	pid := getDirIDResp.CategoryID.String()
-	if pid == "0" && parentDir != "/" {
-		return nil
+	dstDirFi, err := c.GetFile(ctx, dstDir)
+	if err != nil {
+		return err
 	}
-	resp, err := APIAddDir(c.HttpClient, pid, name)
+	if !dstDirFi.IsDir() {
+		return common.ErrNotFound
+	}
+	if _, err := c.GetFile(ctx, dstPath); err == nil {
+		return common.ErrExists
+	} else if !errors.Is(err, common.ErrNotFound) {
+		return err
+	}
+	dstCID := dstDirFi.(*FileInfo).CategoryID.String()
+
+	switch {
+	case srcDir == dstDir:
+		err = c.renameFile(ctx, fid, dstFileName)
+	case srcFileName == dstFileName:
+		err = c.moveFile(ctx, fid, dstCID)
+	default:
+		var clashInDst, clashInSrc bool
+		if clashInDst, err = c.exists(ctx, path.Join(dstDir, srcFileName)); err != nil {
+			return err
+		}
+		if clashInSrc, err = c.exists(ctx, path.Join(srcDir, dstFileName)); err != nil {
+			return err
+		}
+		switch {
+		case !clashInDst:
+			if err = c.moveFile(ctx, fid, dstCID); err == nil {
+				err = c.renameFile(ctx, fid, dstFileName)
+			}
+		case !clashInSrc:
+			if err = c.renameFile(ctx, fid, dstFileName); err == nil {
+				err = c.moveFile(ctx, fid, dstCID)
+			}
+		default:
+			return fmt.Errorf("%w: move %s to %s", common.ErrNameConflict, srcPath, dstPath)
+		}
+	}
+
+	// The first step of a move and rename may have succeeded.
+	c.flushDir(srcDir)
+	c.flushDir(dstDir)
+	if fi.IsDir() {
+		if err != nil {
+			c.forgetTree(srcPath)
+		} else {
+			c.forgetListings(srcPath)
+			c.index.moveTree(srcPath, dstPath)
+		}
+	}
+	return err
+}
+
+func (c *DriveClient) exists(ctx context.Context, filePath string) (bool, error) {
+	_, err := c.GetFile(ctx, filePath)
+	if errors.Is(err, common.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *DriveClient) renameFile(ctx context.Context, fid string, name string) error {
+	resp, err := APIRenameFile(ctx, c.HttpClient, c.apiBaseURLs.WebAPI, fid, name)
 	if err != nil {
 		return err
 	}
 	if !resp.State {
-		return fmt.Errorf("new dir fail, state is false")
+		return newAPIError("rename file", resp.ErrNo, resp.Error)
 	}
+	return nil
+}
 
-	c.flushDir(parentDir)
+func (c *DriveClient) moveFile(ctx context.Context, fid string, cid string) error {
+	resp, err := APIMoveFile(ctx, c.HttpClient, c.apiBaseURLs.WebAPI, fid, cid)
+	if err != nil {
+		return err
+	}
+	if !resp.State {
+		return newAPIError("move file", resp.ErrNo, resp.Error)
+	}
 	return nil
 }
 
-func (c *DriveClient) MoveFile(srcPath string, dstPath string) error {
-	logrus.Infof("move file, src: %s, dst: %s", srcPath, dstPath)
+func (c *DriveClient) CopyFile(ctx context.Context, srcPath string, dstPath string) error {
+	logrus.Infof("copy file, src: %s, dst: %s", srcPath, dstPath)
 
-	c.limiter.Wait(context.Background())
-	fi, err := c.GetFile(srcPath)
+	fi, err := c.GetFile(ctx, srcPath)
 	if err != nil {
 		return err
 	}
@@ -254,58 +609,99 @@ func (c *DriveClient) MoveFile(srcPath string, dstPath string) error {
 
 	srcPath = slashClean(srcPath)
 	if srcPath == "/" || len(srcPath) == 0 {
-		logrus.Warnf("invalid src_path: %s", srcPath)
-		return nil
+		return fmt.Errorf("invalid src_path: %s", srcPath)
 	}
 	srcPath = strings.TrimRight(srcPath, "/")
-	srcDir, srcFileName := path.Split(srcPath)
+	_, srcFileName := path.Split(srcPath)
 
 	dstPath = slashClean(dstPath)
 	if dstPath == "/" || len(dstPath) == 0 {
-		logrus.Warnf("invalid src_path: %s", srcPath)
-		return nil
+		return fmt.Errorf("invalid dst_path: %s", dstPath)
 	}
 	dstPath = strings.TrimRight(dstPath, "/")
 	dstDir, dstFileName := path.Split(dstPath)
 
-	dstDirFi, err := c.GetFile(dstDir)
+	dstDirFi, err := c.GetFile(ctx, dstDir)
 	if err != nil {
 		return err
 	}
 	if !dstDirFi.IsDir() {
-		logrus.Errorf("dst dir not exists")
-		return nil
+		return common.ErrNotFound
 	}
+	if _, err := c.GetFile(ctx, dstPath); err == nil {
+		return common.ErrExists
+	} else if !errors.Is(err, common.ErrNotFound) {
+		return err
+	}
+	dstCID := dstDirFi.(*FileInfo).CategoryID.String()
 
-	if srcDir == dstDir {
-		resp, err := APIRenameFile(c.HttpClient, fid, dstFileName)
-		if err != nil {
-			return err
-		}
-		if !resp.State {
-			return fmt.Errorf("rename file fail, state is false")
-		}
-		c.flushDir(srcDir)
+	// 115 copies a file into a dir under its own name. A copy under another
+	// name, which a copy within the same dir is, goes through a temporary
+	// dir, where it can be found and renamed without clashing with the
+	// files of dstDir.
+	if srcFileName == dstFileName {
+		err = c.copyInto(ctx, fid, dstCID)
 	} else {
-		if srcFileName == dstFileName {
-			resp, err := APIMoveFile(c.HttpClient, fid, dstDirFi.(*FileInfo).CategoryID.String())
-			if err != nil {
-				return err
-			}
-			if !resp.State {
-				return fmt.Errorf("move file fail, state is false")
-			}
-			c.flushDir(srcDir)
-			c.flushDir(dstDir)
-		} else {
-			logrus.Errorf("invalid dst filename")
-			return nil
-		}
+		err = c.copyRenamed(ctx, fid, dstCID, dstFileName)
 	}
+	c.flushDir(dstDir)
+	return err
+}
 
+func (c *DriveClient) copyInto(ctx context.Context, fid string, cid string) error {
+	resp, err := APICopyFile(ctx, c.HttpClient, c.apiBaseURLs.WebAPI, fid, cid)
+	if err != nil {
+		return err
+	}
+	if !resp.State {
+		return newAPIError("copy file", resp.ErrNo, resp.Error)
+	}
 	return nil
 }
 
+// copyRenamed copies the file or dir fid into the dir cid under name, through
+// a temporary dir in cid, which is removed afterwards.
+func (c *DriveClient) copyRenamed(ctx context.Context, fid string, cid string, name string) error {
+	tmpName := ".copy-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	addResp, err := APIAddDir(ctx, c.HttpClient, c.apiBaseURLs.WebAPI, cid, tmpName)
+	if err != nil {
+		return err
+	}
+	if !addResp.State {
+		return newAPIError("new dir", addResp.ErrNo, addResp.Error)
+	}
+	tmpCID := addResp.CategoryID.String()
+	defer func() {
+		resp, err := APIDeleteFile(context.Background(), c.HttpClient, c.apiBaseURLs.WebAPI, tmpCID, cid)
+		if err == nil && !resp.State {
+			err = newAPIError("remove file", resp.ErrNo, resp.Error)
+		}
+		if err != nil {
+			logrus.WithError(err).Errorf("remove temporary copy dir fail, cid: %s", tmpCID)
+		}
+	}()
+
+	if err := c.copyInto(ctx, fid, tmpCID); err != nil {
+		return err
+	}
+	infos, _, err := c.fetchFilesByID(ctx, tmpCID)
+	if err != nil {
+		return err
+	}
+	if len(infos) != 1 {
+		return fmt.Errorf("copy file fail, expect 1 copy, got %d, fid: %s", len(infos), fid)
+	}
+	copiedID := infos[0].FileID.String()
+	if infos[0].IsDir() {
+		copiedID = infos[0].CategoryID.String()
+	}
+
+	if err := c.renameFile(ctx, copiedID, name); err != nil {
+		return err
+	}
+	return c.moveFile(ctx, copiedID, cid)
+}
+
 func (c *DriveClient) Proxy(w http.ResponseWriter, req *http.Request, targetURL string) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -323,7 +719,10 @@ func (c *DriveClient) Proxy(w http.ResponseWriter, req *http.Request, targetURL
 	u, _ := url.Parse(targetURL)
 	req.URL = u
 	req.Host = u.Host
-	c.limiter.Wait(context.Background())
+	if err := c.contentLimiter.Wait(req.Context()); err != nil {
+		// The client went away while waiting.
+		return
+	}
 	c.reserveProxy.ServeHTTP(w, req)
 }
 
@@ -334,6 +733,36 @@ func (c *DriveClient) flushDir(dir string) {
 		dir = "/"
 	}
 	c.cache.Remove(fmt.Sprintf("files:%s", dir))
+	if c.metaStore != nil {
+		c.metaStore.Delete(fmt.Sprintf("files:%s", dir))
+	}
+}
+
+// forgetTree drops the cached listings and ids of dir and of the dirs below
+// it, after dir was moved or removed.
+func (c *DriveClient) forgetTree(dir string) {
+	dir = slashClean(dir)
+	c.index.removeTree(dir)
+	c.forgetListings(dir)
+}
+
+// forgetListings drops the cached listings of dir and of the dirs below it.
+func (c *DriveClient) forgetListings(dir string) {
+	dir = slashClean(dir)
+	for _, key := range c.cache.Keys(false) {
+		if key, ok := key.(string); ok && strings.HasPrefix(key, "files:") && inTree(strings.TrimPrefix(key, "files:"), dir) {
+			c.cache.Remove(key)
+		}
+	}
+	if c.metaStore != nil {
+		c.metaStore.Delete("files:" + dir)
+		c.metaStore.DeletePrefix("files:" + strings.TrimSuffix(dir, "/") + "/")
+	}
+}
+
+// inTree reports whether p is dir or below it.
+func inTree(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
 }
 
 func slashClean(name string) string {
@@ -0,0 +1,427 @@
+package _115_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	_115 "github.com/gaoyb7/115drive-webdav/115"
+	"github.com/gaoyb7/115drive-webdav/115/fake115"
+	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.WarnLevel)
+	os.Exit(m.Run())
+}
+
+// newTestClient returns a fake 115 server and a client of it, without rate
+// limits.
+func newTestClient(t *testing.T, opts ..._115.Option) (*fake115.Server, *_115.DriveClient) {
+	s := fake115.New(42)
+	t.Cleanup(s.Close)
+	opts = append([]_115.Option{_115.WithRateLimits(-1, -1, -1, 1)}, opts...)
+	return s, s.NewClient(opts...)
+}
+
+func TestGetFilesPagination(t *testing.T) {
+	s, c := newTestClient(t)
+	const n = 2500
+	for i := 0; i < n; i++ {
+		s.WriteFile(fmt.Sprintf("/big/f%04d", i), []byte("x"))
+	}
+
+	files, err := c.GetFiles(context.Background(), "/big")
+	if err != nil {
+		t.Fatalf("GetFiles: %v", err)
+	}
+	if len(files) != n {
+		t.Fatalf("GetFiles: got %d files, want %d", len(files), n)
+	}
+	seen := make(map[string]bool)
+	for _, fi := range files {
+		if seen[fi.GetName()] {
+			t.Fatalf("GetFiles: %s listed twice", fi.GetName())
+		}
+		seen[fi.GetName()] = true
+	}
+	if calls := s.Calls(fake115.PathFiles); calls < 3 {
+		t.Errorf("GetFiles: got %d listing calls, want one per page", calls)
+	}
+
+	// The listing is cached.
+	calls := s.Calls(fake115.PathFiles)
+	if _, err := c.GetFiles(context.Background(), "/big"); err != nil {
+		t.Fatalf("GetFiles: %v", err)
+	}
+	if got := s.Calls(fake115.PathFiles); got != calls {
+		t.Errorf("GetFiles: cached listing made %d calls", got-calls)
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	s, c := newTestClient(t)
+	s.WriteFile("/a/b/hello.txt", []byte("hello world"))
+	ctx := context.Background()
+
+	fi, err := c.GetFile(ctx, "/a/b/hello.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if fi.IsDir() || fi.GetName() != "hello.txt" || fi.GetSize() != 11 {
+		t.Errorf("GetFile: got dir %v, name %q, size %d", fi.IsDir(), fi.GetName(), fi.GetSize())
+	}
+	if fi, err := c.GetFile(ctx, "/a/b"); err != nil || !fi.IsDir() {
+		t.Errorf("GetFile of a dir: got %v, %v", fi, err)
+	}
+	for _, p := range []string{"/a/b/nope", "/nope/x", "/a/b/hello.txt/x"} {
+		if _, err := c.GetFile(ctx, p); !errors.Is(err, common.ErrNotFound) {
+			t.Errorf("GetFile(%q): got %v, want ErrNotFound", p, err)
+		}
+	}
+}
+
+func TestFileInfoGetID(t *testing.T) {
+	dir := &_115.FileInfo{CategoryID: "7"}
+	file := &_115.FileInfo{CategoryID: "5", FileID: "7"}
+	if dir.GetID() == file.GetID() {
+		t.Errorf("GetID of a dir and a file with the same id: got %q for both", dir.GetID())
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	s, c := newTestClient(t)
+	s.MakeDir("/a")
+	ctx := context.Background()
+
+	if err := c.MakeDir(ctx, "/a"); !errors.Is(err, common.ErrExists) {
+		t.Errorf("MakeDir of an existing dir: got %v, want ErrExists", err)
+	}
+	if err := c.MakeDir(ctx, "/nope/b"); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("MakeDir without parent: got %v, want ErrNotFound", err)
+	}
+
+	testCases := []struct {
+		failure fake115.Failure
+		want    error
+	}{
+		{fake115.Failure{ErrNo: 20004, Error: "该目录名称已存在"}, common.ErrExists},
+		{fake115.Failure{ErrNo: 990001, Error: "登录超时，请重新登录"}, common.ErrLoginExpired},
+		{fake115.Failure{ErrNo: 1, Error: "文件正在操作中，请稍后再试"}, common.ErrLocked},
+	}
+	for _, tc := range testCases {
+		s.Fail(fake115.PathAddDir, tc.failure)
+		err := c.MakeDir(ctx, "/a/c")
+		if !errors.Is(err, tc.want) {
+			t.Errorf("MakeDir failing with errno %d: got %v, want %v", tc.failure.ErrNo, err, tc.want)
+		}
+		apiErr := &_115.APIError{}
+		if !errors.As(err, &apiErr) || apiErr.ErrNo != fmt.Sprint(tc.failure.ErrNo) {
+			t.Errorf("MakeDir failing with errno %d: got %#v, want an APIError", tc.failure.ErrNo, err)
+		}
+	}
+	if s.Exists("/a/c") {
+		t.Errorf("MakeDir failing: created /a/c")
+	}
+}
+
+func TestThrottledCallIsRetried(t *testing.T) {
+	s, c := newTestClient(t)
+	ctx := context.Background()
+
+	s.Fail(fake115.PathAddDir, fake115.Failure{Status: http.StatusMethodNotAllowed})
+	calls := s.Calls(fake115.PathAddDir)
+	if err := c.MakeDir(ctx, "/a"); err != nil {
+		t.Fatalf("MakeDir: %v", err)
+	}
+	if got := s.Calls(fake115.PathAddDir) - calls; got != 2 {
+		t.Errorf("MakeDir: got %d calls, want 2", got)
+	}
+	if !s.Exists("/a") {
+		t.Errorf("MakeDir: /a not created")
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	s, c := newTestClient(t)
+	s.MakeDir("/a")
+	s.MakeDir("/b")
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	if err := c.UploadFile(ctx, "/a/f.bin", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got, _ := s.ReadFile("/a/f.bin"); !bytes.Equal(got, content) {
+		t.Fatalf("UploadFile: got %d bytes, want %d", len(got), len(content))
+	}
+	if calls := s.Calls(fake115.PathOSS); calls != 3 {
+		t.Errorf("UploadFile: got %d OSS calls, want initiate, part and complete", calls)
+	}
+	fi, err := c.GetFile(ctx, "/a/f.bin")
+	if err != nil || fi.GetSize() != int64(len(content)) {
+		t.Errorf("GetFile after UploadFile: got %v, %v", fi, err)
+	}
+
+	// The same content is linked by the fast upload, after a sign check.
+	ossCalls, initCalls := s.Calls(fake115.PathOSS), s.Calls(fake115.PathInitUpload)
+	if err := c.UploadFile(ctx, "/b/g.bin", bytes.NewReader(content), -1); err != nil {
+		t.Fatalf("UploadFile of known content: %v", err)
+	}
+	if got, _ := s.ReadFile("/b/g.bin"); !bytes.Equal(got, content) {
+		t.Fatalf("UploadFile of known content: got %d bytes, want %d", len(got), len(content))
+	}
+	if got := s.Calls(fake115.PathOSS) - ossCalls; got != 0 {
+		t.Errorf("UploadFile of known content: got %d OSS calls, want 0", got)
+	}
+	if got := s.Calls(fake115.PathInitUpload) - initCalls; got != 2 {
+		t.Errorf("UploadFile of known content: got %d init calls, want 2", got)
+	}
+
+	if err := c.UploadFile(ctx, "/nope/f.bin", bytes.NewReader(content), -1); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("UploadFile without parent: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	s, c := newTestClient(t)
+	s.WriteFile("/a/f.txt", []byte("f"))
+	s.WriteFile("/a/d/g.txt", []byte("g"))
+	s.MakeDir("/b")
+	ctx := context.Background()
+
+	if err := c.CopyFile(ctx, "/a/f.txt", "/b/f.txt"); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	if err := c.CopyFile(ctx, "/a/d", "/b/d"); err != nil {
+		t.Fatalf("CopyFile of a dir: %v", err)
+	}
+	for _, p := range []string{"/a/f.txt", "/b/f.txt", "/a/d/g.txt", "/b/d/g.txt"} {
+		if !s.Exists(p) {
+			t.Errorf("CopyFile: %s is missing", p)
+		}
+	}
+	files, err := c.GetFiles(ctx, "/b/d")
+	if err != nil || len(files) != 1 || files[0].GetName() != "g.txt" {
+		t.Errorf("GetFiles after CopyFile: got %v, %v", files, err)
+	}
+	if err := c.CopyFile(ctx, "/a/nope", "/b/nope"); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("CopyFile of a missing file: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestServeContent(t *testing.T) {
+	s, c := newTestClient(t)
+	s.WriteFile("/a/hello.txt", []byte("hello world"))
+	fi, err := c.GetFile(context.Background(), "/a/hello.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/a/hello.txt", nil)
+	req.Header.Set("Range", "bytes=6-")
+	w := httptest.NewRecorder()
+	c.ServeContent(w, req, fi)
+	body, _ := ioutil.ReadAll(w.Result().Body)
+	if w.Code != http.StatusPartialContent || string(body) != "world" {
+		t.Errorf("ServeContent: got %d %q, want 206 %q", w.Code, body, "world")
+	}
+	if calls := s.Calls(fake115.PathContent); calls != 1 {
+		t.Errorf("ServeContent: got %d content calls, want 1", calls)
+	}
+}
+
+func TestServeContentRedirect(t *testing.T) {
+	s, c := newTestClient(t, _115.WithRedirect([]string{"alice"}, []string{"VLC"}))
+	s.WriteFile("/a/hello.txt", []byte("hello world"))
+	s.WriteFile("/a/other.txt", []byte("other"))
+	fi, err := c.GetFile(context.Background(), "/a/hello.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	testCases := []struct {
+		user      string
+		userAgent string
+		want      int
+	}{
+		{"alice", "", http.StatusFound},
+		{"alice", "curl/7.0", http.StatusFound},
+		{"bob", "vlc/3.0", http.StatusFound},
+		{"bob", "", http.StatusOK},
+		{"bob", "curl/7.0", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/a/hello.txt", nil)
+		req.SetBasicAuth(tc.user, "secret")
+		req.Header.Set("User-Agent", tc.userAgent)
+		w := httptest.NewRecorder()
+		c.ServeContent(w, req, fi)
+		if w.Code != tc.want {
+			t.Errorf("ServeContent as %s with User-Agent %q: got %d, want %d", tc.user, tc.userAgent, w.Code, tc.want)
+		}
+	}
+
+	// The url of the proxy is bound to the User-Agent it sends to 115, and
+	// shared with the redirects of clients sending the same or none.
+	fi, err = c.GetFile(context.Background(), "/a/other.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if _, err := c.GetFileURL(context.Background(), fi); err != nil {
+		t.Fatal(err)
+	}
+	calls := s.Calls(fake115.PathDownloadURL)
+	for _, userAgent := range []string{"", _115.UserAgent} {
+		req := httptest.NewRequest(http.MethodGet, "/a/other.txt", nil)
+		req.SetBasicAuth("alice", "secret")
+		req.Header.Set("User-Agent", userAgent)
+		c.ServeContent(httptest.NewRecorder(), req, fi)
+	}
+	if got := s.Calls(fake115.PathDownloadURL); got != calls {
+		t.Errorf("redirects with the User-Agent of the proxy: got %d download url calls, want none", got-calls)
+	}
+}
+
+func TestCopyFileRenamed(t *testing.T) {
+	s, c := newTestClient(t)
+	s.WriteFile("/a/f.txt", []byte("f"))
+	s.WriteFile("/a/d/g.txt", []byte("g"))
+	s.WriteFile("/b/f.txt", []byte("other"))
+	ctx := context.Background()
+
+	testCases := []struct {
+		src, dst string
+	}{
+		// The name of the copy is taken in the destination.
+		{"/a/f.txt", "/b/g.txt"},
+		// Within the same dir.
+		{"/a/f.txt", "/a/f copy.txt"},
+		{"/a/d", "/b/e"},
+	}
+	for _, tc := range testCases {
+		if err := c.CopyFile(ctx, tc.src, tc.dst); err != nil {
+			t.Fatalf("CopyFile(%q, %q): %v", tc.src, tc.dst, err)
+		}
+	}
+
+	want := map[string]string{
+		"/a/f.txt":      "f",
+		"/a/f copy.txt": "f",
+		"/a/d/g.txt":    "g",
+		"/b/f.txt":      "other",
+		"/b/g.txt":      "f",
+		"/b/e/g.txt":    "g",
+	}
+	for p, content := range want {
+		if got, ok := s.ReadFile(p); !ok || string(got) != content {
+			t.Errorf("after CopyFile: %s is %q, %v, want %q", p, got, ok, content)
+		}
+	}
+	for dir, n := range map[string]int{"/a": 3, "/b": 3} {
+		files, err := c.GetFiles(ctx, dir)
+		if err != nil || len(files) != n {
+			t.Errorf("GetFiles(%q) after CopyFile: got %d files, %v, want %d", dir, len(files), err, n)
+		}
+	}
+
+	if err := c.CopyFile(ctx, "/a/f.txt", "/b/f.txt"); !errors.Is(err, common.ErrExists) {
+		t.Errorf("CopyFile onto an existing file: got %v, want ErrExists", err)
+	}
+	if got, _ := s.ReadFile("/b/f.txt"); string(got) != "other" {
+		t.Errorf("CopyFile onto an existing file: got %q, want it kept", got)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	s, c := newTestClient(t)
+	s.WriteFile("/a/f.txt", []byte("f"))
+	s.WriteFile("/a/d/g.txt", []byte("g"))
+	s.WriteFile("/a/h.txt", []byte("h"))
+	s.WriteFile("/a/i.txt", []byte("i"))
+	s.WriteFile("/b/h.txt", []byte("other h"))
+	s.WriteFile("/b/x.txt", []byte("other x"))
+	s.WriteFile("/c/i.txt", []byte("other i"))
+	s.WriteFile("/c/y.txt", []byte("other y"))
+	s.WriteFile("/a/y.txt", []byte("other y"))
+	ctx := context.Background()
+
+	testCases := []struct {
+		src, dst string
+	}{
+		{"/a/f.txt", "/a/f2.txt"},
+		{"/a/f2.txt", "/b/f2.txt"},
+		{"/b/f2.txt", "/a/f3.txt"},
+		{"/a/d", "/b/e"},
+		// h.txt is taken in /b, the file is renamed before the move.
+		{"/a/h.txt", "/b/h2.txt"},
+	}
+	for _, tc := range testCases {
+		if err := c.MoveFile(ctx, tc.src, tc.dst); err != nil {
+			t.Fatalf("MoveFile(%q, %q): %v", tc.src, tc.dst, err)
+		}
+	}
+	want := map[string]string{
+		"/a/f3.txt":  "f",
+		"/b/e/g.txt": "g",
+		"/b/h2.txt":  "h",
+		"/b/h.txt":   "other h",
+	}
+	for p, content := range want {
+		if got, ok := s.ReadFile(p); !ok || string(got) != content {
+			t.Errorf("after MoveFile: %s is %q, %v, want %q", p, got, ok, content)
+		}
+	}
+	for _, p := range []string{"/a/f.txt", "/a/f2.txt", "/b/f2.txt", "/a/d", "/a/h.txt"} {
+		if s.Exists(p) {
+			t.Errorf("after MoveFile: %s still exists", p)
+		}
+	}
+	if files, err := c.GetFiles(ctx, "/b/e"); err != nil || len(files) != 1 {
+		t.Errorf("GetFiles of a moved dir: got %v, %v", files, err)
+	}
+
+	errCases := []struct {
+		src, dst string
+		want     error
+	}{
+		{"/a/nope", "/b/nope", common.ErrNotFound},
+		{"/a/f3.txt", "/nope/f3.txt", common.ErrNotFound},
+		{"/a/f3.txt", "/b/x.txt", common.ErrExists},
+		// i.txt is taken in /c and y.txt in /a.
+		{"/a/i.txt", "/c/y2.txt", nil},
+		{"/a/y.txt", "/c/i.txt", common.ErrExists},
+		{"/b/e", "/b/e/f", common.ErrForbidden},
+	}
+	for _, tc := range errCases {
+		err := c.MoveFile(ctx, tc.src, tc.dst)
+		if tc.want == nil {
+			if err != nil {
+				t.Errorf("MoveFile(%q, %q): %v", tc.src, tc.dst, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tc.want) {
+			t.Errorf("MoveFile(%q, %q): got %v, want %v", tc.src, tc.dst, err, tc.want)
+		}
+	}
+
+	// Both orders of the move and the rename clash.
+	s.WriteFile("/a/z.txt", []byte("z"))
+	s.WriteFile("/c/z.txt", []byte("other z"))
+	s.WriteFile("/a/w.txt", []byte("other w"))
+	c = s.NewClient(_115.WithRateLimits(-1, -1, -1, 1))
+	if err := c.MoveFile(ctx, "/a/z.txt", "/c/w.txt"); !errors.Is(err, common.ErrNameConflict) {
+		t.Errorf("MoveFile with clashing names: got %v, want ErrNameConflict", err)
+	}
+	if got, _ := s.ReadFile("/a/z.txt"); string(got) != "z" {
+		t.Errorf("MoveFile with clashing names: got %q, want the file kept", got)
+	}
+}
@@ -2,11 +2,13 @@ package _115
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"io"
 	"math/big"
 )
@@ -62,23 +64,40 @@ func GenerateKey() Key {
 	return key
 }
 
+// Encode encodes input for the download url api of 115, key is the key
+// the response is encoded with.
 func Encode(input []byte, key Key) (output string) {
+	return EncodeWithKey(input, key, rsaServerKey)
+}
+
+// Decode decodes a response of the download url api of 115 to a request
+// encoded with key.
+func Decode(input string, key Key) (output []byte, err error) {
+	return DecodeWithKey(input, key, rsaServerKey)
+}
+
+// EncodeWithKey is Encode for a server whose RSA key is serverKey.
+func EncodeWithKey(input []byte, key Key, serverKey *rsa.PublicKey) (output string) {
 	buf := make([]byte, 16+len(input))
 	copy(buf, key[:])
 	copy(buf[16:], input)
 	xorTransform(buf[16:], xorDeriveKey(key[:], 4))
 	reverseBytes(buf[16:])
 	xorTransform(buf[16:], xorClientKey)
-	output = base64.StdEncoding.EncodeToString(rsaEncrypt(buf))
+	output = base64.StdEncoding.EncodeToString(rsaEncrypt(buf, serverKey))
 	return
 }
 
-func Decode(input string, key Key) (output []byte, err error) {
+// DecodeWithKey is Decode for a server whose RSA key is serverKey.
+func DecodeWithKey(input string, key Key, serverKey *rsa.PublicKey) (output []byte, err error) {
 	data, err := base64.StdEncoding.DecodeString(input)
 	if err != nil {
 		return
 	}
-	data = rsaDecrypt(data)
+	data = rsaDecrypt(data, serverKey)
+	if len(data) < 16 {
+		return nil, errors.New("decode fail, invalid data")
+	}
 	output = make([]byte, len(data)-16)
 	copy(output, data[16:])
 	xorTransform(output, xorDeriveKey(data[:16], 12))
@@ -87,6 +106,68 @@ func Decode(input string, key Key) (output []byte, err error) {
 	return
 }
 
+// DecodeRequest is the server side of EncodeWithKey, for fake 115 servers.
+// It returns the input of a request and the key its response is encoded
+// with.
+func DecodeRequest(input string, serverKey *rsa.PrivateKey) (output []byte, key Key, err error) {
+	data, err := base64.StdEncoding.DecodeString(input)
+	if err != nil {
+		return nil, key, err
+	}
+	buf := bytes.Buffer{}
+	blockSize := serverKey.Size()
+	for offset := 0; offset < len(data); offset += blockSize {
+		end := offset + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		slice, err := rsa.DecryptPKCS1v15(nil, serverKey, data[offset:end])
+		if err != nil {
+			return nil, key, err
+		}
+		buf.Write(slice)
+	}
+	if buf.Len() < 16 {
+		return nil, key, errors.New("decode request fail, invalid data")
+	}
+	data = buf.Bytes()
+	copy(key[:], data[:16])
+	output = data[16:]
+	xorTransform(output, xorClientKey)
+	reverseBytes(output)
+	xorTransform(output, xorDeriveKey(key[:], 4))
+	return output, key, nil
+}
+
+// EncodeResponse is the server side of DecodeWithKey, for fake 115 servers.
+// It encodes the response to a request whose key is key.
+func EncodeResponse(input []byte, key Key, serverKey *rsa.PrivateKey) (string, error) {
+	responseKey := GenerateKey()
+	buf := make([]byte, 16+len(input))
+	copy(buf, responseKey[:])
+	copy(buf[16:], input)
+	xorTransform(buf[16:], xorDeriveKey(key[:], 4))
+	reverseBytes(buf[16:])
+	xorTransform(buf[16:], xorDeriveKey(responseKey[:], 12))
+
+	// The client recovers the blocks with the public key, as signatures
+	// of the raw data.
+	out := bytes.Buffer{}
+	blockSize := serverKey.Size() - 11
+	for offset := 0; offset < len(buf); offset += blockSize {
+		end := offset + blockSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		slice, err := rsa.SignPKCS1v15(nil, serverKey, crypto.Hash(0), buf[offset:end])
+		if err != nil {
+			return "", err
+		}
+		out.Write(slice)
+	}
+	return base64.StdEncoding.EncodeToString(out.Bytes()), nil
+}
+
 func xorDeriveKey(seed []byte, size int) []byte {
 	key := make([]byte, size)
 	for i := 0; i < size; i++ {
@@ -109,8 +190,8 @@ func xorTransform(data []byte, key []byte) {
 	}
 }
 
-func rsaEncrypt(input []byte) []byte {
-	plainSize, blockSize := len(input), rsaServerKey.Size()-11
+func rsaEncrypt(input []byte, serverKey *rsa.PublicKey) []byte {
+	plainSize, blockSize := len(input), serverKey.Size()-11
 	buf := bytes.Buffer{}
 	for offset := 0; offset < plainSize; offset += blockSize {
 		sliceSize := blockSize
@@ -118,15 +199,15 @@ func rsaEncrypt(input []byte) []byte {
 			sliceSize = plainSize - offset
 		}
 		slice, _ := rsa.EncryptPKCS1v15(
-			rand.Reader, rsaServerKey, input[offset:offset+sliceSize])
+			rand.Reader, serverKey, input[offset:offset+sliceSize])
 		buf.Write(slice)
 	}
 	return buf.Bytes()
 }
 
-func rsaDecrypt(input []byte) []byte {
+func rsaDecrypt(input []byte, serverKey *rsa.PublicKey) []byte {
 	output := make([]byte, 0)
-	cipherSize, blockSize := len(input), rsaServerKey.Size()
+	cipherSize, blockSize := len(input), serverKey.Size()
 	for offset := 0; offset < cipherSize; offset += blockSize {
 		sliceSize := blockSize
 		if offset+sliceSize > cipherSize {
@@ -134,7 +215,7 @@ func rsaDecrypt(input []byte) []byte {
 		}
 
 		n := big.NewInt(0).SetBytes(input[offset : offset+sliceSize])
-		m := big.NewInt(0).Exp(n, big.NewInt(int64(rsaServerKey.E)), rsaServerKey.N)
+		m := big.NewInt(0).Exp(n, big.NewInt(int64(serverKey.E)), serverKey.N)
 		b := m.Bytes()
 		index := bytes.IndexByte(b, '\x00')
 		if index < 0 {
@@ -0,0 +1,80 @@
+package _115
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+)
+
+// APIError is a failure reported by a 115 api in its response, with the
+// errno and message of the response. It unwraps to the common error of the
+// failure, if it is known, so that it can be tested with errors.Is.
+type APIError struct {
+	API     string
+	ErrNo   string
+	Message string
+	Kind    error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s fail, errno: %s, error: %s", e.API, e.ErrNo, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// errnoKinds maps the errnos of 115 apis to common errors.
+var errnoKinds = map[string]error{
+	"911":    common.ErrRateLimited,  // 请验证账号, asked after too many calls
+	"20004":  common.ErrExists,       // 该目录名称已存在
+	"20009":  common.ErrNotFound,     // 父目录不存在
+	"20018":  common.ErrNotFound,     // 文件不存在或已删除
+	"90008":  common.ErrNotFound,     // 文件（夹）不存在或已经删除
+	"91002":  common.ErrForbidden,    // 不能将文件复制到自身或其子目录下
+	"990001": common.ErrLoginExpired, // 登录超时，请重新登录
+}
+
+// messageKinds maps keywords of the messages of 115 apis to common errors,
+// for the errnos missing from errnoKinds, and for apis that report no errno.
+var messageKinds = []struct {
+	keyword string
+	kind    error
+}{
+	{"频繁", common.ErrRateLimited},
+	{"登录", common.ErrLoginExpired},
+	{"空间不足", common.ErrQuotaExceeded},
+	{"容量不足", common.ErrQuotaExceeded},
+	{"已存在", common.ErrExists},
+	{"同名", common.ErrNameConflict},
+	{"重名", common.ErrNameConflict},
+	{"操作中", common.ErrLocked},
+	{"锁定", common.ErrLocked},
+	{"非法字符", common.ErrInvalidName},
+	{"名称不能", common.ErrInvalidName},
+	{"不存在", common.ErrNotFound},
+	{"已删除", common.ErrNotFound},
+	{"已经删除", common.ErrNotFound},
+	{"无权", common.ErrForbidden},
+	{"没有权限", common.ErrForbidden},
+	{"违规", common.ErrForbidden},
+}
+
+// newAPIError returns the error of a response of api that failed with errno
+// and message.
+func newAPIError(api string, errno json.Number, message string) error {
+	e := &APIError{API: api, ErrNo: errno.String(), Message: message}
+	if kind, ok := errnoKinds[e.ErrNo]; ok {
+		e.Kind = kind
+		return e
+	}
+	for _, m := range messageKinds {
+		if strings.Contains(message, m.keyword) {
+			e.Kind = m.kind
+			break
+		}
+	}
+	return e
+}
@@ -0,0 +1,659 @@
+// Package fake115 implements an in-process fake of the 115 apis used by the
+// 115 DriveClient, so that the client can be exercised without a 115
+// account, in integration tests or offline development.
+//
+// The fake keeps a tree of dirs and files in memory and serves the file
+// listing, getid, add, move, copy, batch_rename, rb/delete, downurl, login
+// check and upload apis, in the wire format of 115, along with the OSS
+// multipart upload api. Download urls point back to the fake, which serves
+// the content of the files. Failures of the apis can be injected with Fail.
+package fake115
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_115 "github.com/gaoyb7/115drive-webdav/115"
+)
+
+// Paths of the apis, as counted by Calls and given to Fail.
+var (
+	PathFiles       = _115.APIPathGetFiles
+	PathDirID       = _115.APIPathGetDirID
+	PathAddDir      = _115.APIPathAddDir
+	PathMove        = _115.APIPathMoveFile
+	PathCopy        = _115.APIPathCopyFile
+	PathRename      = _115.APIPathRenameFile
+	PathDelete      = _115.APIPathDeleteFile
+	PathDownloadURL = _115.APIPathGetDownloadURL
+	PathLoginCheck  = _115.APIPathLoginCheck
+	// PathContent is the prefix of the download urls, followed by the pick
+	// code of the file.
+	PathContent = "/content/"
+)
+
+// Failure is a failure of an api call injected with Fail.
+type Failure struct {
+	// Status is the http status of the response. A status other than 0
+	// and 200 is sent with an empty body.
+	Status int
+	// ErrNo and Error are the errno and the message of the response.
+	ErrNo int
+	Error string
+}
+
+type node struct {
+	id       int64
+	parent   int64
+	name     string
+	dir      bool
+	content  []byte
+	pickCode string
+	mtime    time.Time
+}
+
+// Server is a fake 115 api server. The zero value is not usable, see New.
+type Server struct {
+	*httptest.Server
+	// Key signs the download urls, clients check them with its public key.
+	Key *rsa.PrivateKey
+	// UserID is the id of the account of the fake.
+	UserID int64
+
+	mu       sync.Mutex
+	nodes    map[int64]*node
+	nextID   int64
+	calls    map[string]int
+	failures map[string][]Failure
+	uploads  map[string]*ossUpload
+}
+
+// New starts a fake 115 server for the account userID, with an empty drive.
+// It is stopped by Close.
+func New(userID int64) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(fmt.Sprintf("fake115: call rsa.GenerateKey fail, err: %v", err))
+	}
+	s := &Server{
+		Key:      key,
+		UserID:   userID,
+		nodes:    map[int64]*node{0: {id: 0, dir: true}},
+		nextID:   1000,
+		calls:    make(map[string]int),
+		failures: make(map[string][]Failure),
+		uploads:  make(map[string]*ossUpload),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathFiles, s.api(s.handleFiles))
+	mux.HandleFunc(PathDirID, s.api(s.handleDirID))
+	mux.HandleFunc(PathAddDir, s.api(s.handleAddDir))
+	mux.HandleFunc(PathMove, s.api(s.handleMove))
+	mux.HandleFunc(PathCopy, s.api(s.handleCopy))
+	mux.HandleFunc(PathRename, s.api(s.handleRename))
+	mux.HandleFunc(PathDelete, s.api(s.handleDelete))
+	mux.HandleFunc(PathDownloadURL, s.api(s.handleDownloadURL))
+	mux.HandleFunc(PathLoginCheck, s.handleLoginCheck)
+	mux.HandleFunc(PathUploadInfo, s.api(s.handleUploadInfo))
+	mux.HandleFunc(PathInitUpload, s.api(s.handleInitUpload))
+	mux.HandleFunc(PathUploadToken, s.api(s.handleUploadToken))
+	mux.HandleFunc(PathContent, s.handleContent)
+	mux.HandleFunc("/"+fakeBucket+"/", s.handleOSS)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Cookies returns the session cookies the fake accepts.
+func (s *Server) Cookies() _115.Cookies {
+	return _115.Cookies{
+		UID:  fmt.Sprintf("%d_A1_%d", s.UserID, time.Now().Unix()),
+		CID:  "fakecid",
+		SEID: "fakeseid",
+		KID:  "fakekid",
+	}
+}
+
+// Option returns the option that sends the api calls and the uploads of a
+// DriveClient to the fake.
+func (s *Server) Option() _115.Option {
+	opts := []_115.Option{
+		_115.WithAPIBaseURLs(_115.APIBaseURLs{
+			WebAPI:      s.URL,
+			ProAPI:      s.URL,
+			PassportAPI: s.URL,
+			UploadAPI:   s.URL,
+			LifeAPI:     s.URL,
+		}),
+		_115.WithOSSEndpoint(s.URL),
+		_115.WithServerKey(&s.Key.PublicKey),
+	}
+	return func(c *_115.DriveClient) {
+		for _, opt := range opts {
+			opt(c)
+		}
+	}
+}
+
+// NewClient returns a DriveClient of the account of the fake.
+func (s *Server) NewClient(opts ..._115.Option) *_115.DriveClient {
+	cookies := s.Cookies()
+	opts = append([]_115.Option{s.Option()}, opts...)
+	return _115.MustNew115DriveClient(cookies.UID, cookies.CID, cookies.SEID, cookies.KID, opts...)
+}
+
+// MakeDir creates dir and its missing parents, and returns the id of dir.
+func (s *Server) MakeDir(dir string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.makeDirLocked(dir)
+}
+
+func (s *Server) makeDirLocked(dir string) int64 {
+	id := int64(0)
+	for _, name := range splitPath(dir) {
+		child := s.child(id, name)
+		if child == nil {
+			child = s.addNode(id, name, true, nil)
+		}
+		if !child.dir {
+			panic(fmt.Sprintf("fake115: %s is a file", dir))
+		}
+		id = child.id
+	}
+	return id
+}
+
+// WriteFile creates or replaces the file at filePath with content, creating
+// its missing parents, and returns the id of the file.
+func (s *Server) WriteFile(filePath string, content []byte) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir, name := path.Split(path.Clean("/" + filePath))
+	parent := s.makeDirLocked(dir)
+	if old := s.child(parent, name); old != nil {
+		s.removeLocked(old.id)
+	}
+	return s.addNode(parent, name, false, content).id
+}
+
+// ReadFile returns the content of the file at filePath.
+func (s *Server) ReadFile(filePath string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.lookup(filePath)
+	if n == nil || n.dir {
+		return nil, false
+	}
+	return n.content, true
+}
+
+// Exists reports whether there is a dir or a file at p.
+func (s *Server) Exists(p string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(p) != nil
+}
+
+// Calls returns the number of calls of the api at apiPath, such as
+// PathFiles, failed calls included.
+func (s *Server) Calls(apiPath string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[apiPath]
+}
+
+// Fail makes the next calls of the api at apiPath fail, one call for each
+// of failures, in order.
+func (s *Server) Fail(apiPath string, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[apiPath] = append(s.failures[apiPath], failures...)
+}
+
+func (s *Server) addNode(parent int64, name string, dir bool, content []byte) *node {
+	s.nextID++
+	n := &node{
+		id:       s.nextID,
+		parent:   parent,
+		name:     name,
+		dir:      dir,
+		content:  content,
+		pickCode: "pc" + strconv.FormatInt(s.nextID, 36),
+		mtime:    time.Now(),
+	}
+	s.nodes[n.id] = n
+	return n
+}
+
+func (s *Server) child(parent int64, name string) *node {
+	for _, n := range s.nodes {
+		if n.id != 0 && n.parent == parent && n.name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// children returns the children of the dir id, newest first, which is the
+// order of the listings of the client.
+func (s *Server) children(id int64) []*node {
+	children := make([]*node, 0)
+	for _, n := range s.nodes {
+		if n.id != 0 && n.parent == id {
+			children = append(children, n)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].id > children[j].id
+	})
+	return children
+}
+
+func (s *Server) lookup(p string) *node {
+	n := s.nodes[0]
+	for _, name := range splitPath(p) {
+		if n = s.child(n.id, name); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+func (s *Server) removeLocked(id int64) {
+	for _, child := range s.children(id) {
+		s.removeLocked(child.id)
+	}
+	delete(s.nodes, id)
+}
+
+func (s *Server) copyLocked(id int64, parent int64) {
+	n := s.nodes[id]
+	c := s.addNode(parent, n.name, n.dir, n.content)
+	for _, child := range s.children(id) {
+		s.copyLocked(child.id, c.id)
+	}
+}
+
+// ancestors returns the dirs from the root down to the dir id.
+func (s *Server) ancestors(id int64) []*node {
+	dirs := make([]*node, 0)
+	for n := s.nodes[id]; n != nil; n = s.nodes[n.parent] {
+		dirs = append([]*node{n}, dirs...)
+		if n.id == 0 {
+			break
+		}
+	}
+	return dirs
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if len(p) == 0 {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// apiResult is the result of an api handler, written as json. A non nil
+// failure is written instead.
+type apiResult struct {
+	body    interface{}
+	failure *Failure
+}
+
+func fail(errNo int, message string) apiResult {
+	return apiResult{failure: &Failure{ErrNo: errNo, Error: message}}
+}
+
+func ok(body interface{}) apiResult {
+	return apiResult{body: body}
+}
+
+// api wraps the handler of an api with the checks shared by all apis: call
+// counting, injected failures and the session cookie. Handlers run with the
+// lock of s held.
+func (s *Server) api(handler func(r *http.Request) apiResult) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.calls[r.URL.Path]++
+
+		result := apiResult{}
+		if failures := s.failures[r.URL.Path]; len(failures) > 0 {
+			s.failures[r.URL.Path] = failures[1:]
+			result.failure = &failures[0]
+		} else if !s.loggedIn(r) {
+			result = fail(990001, "登录超时，请重新登录")
+		} else if err := r.ParseForm(); err != nil {
+			result = fail(1, err.Error())
+		} else {
+			result = handler(r)
+		}
+
+		if f := result.failure; f != nil {
+			if f.Status != 0 && f.Status != http.StatusOK {
+				w.WriteHeader(f.Status)
+				return
+			}
+			// The files api spells errno as errNo, and the download url
+			// api has msg for error, send every spelling.
+			result.body = map[string]interface{}{
+				"state": false,
+				"errno": f.ErrNo,
+				"errNo": f.ErrNo,
+				"error": f.Error,
+				"msg":   f.Error,
+			}
+		}
+		writeJSON(w, result.body)
+	}
+}
+
+func (s *Server) loggedIn(r *http.Request) bool {
+	cookie, err := r.Cookie("UID")
+	return err == nil && strings.HasPrefix(cookie.Value, strconv.FormatInt(s.UserID, 10)+"_")
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// fileEntry returns n as an entry of a listing. Dirs have their id in cid,
+// files in fid, with the id of their dir in cid.
+func (s *Server) fileEntry(n *node) map[string]interface{} {
+	entry := map[string]interface{}{
+		"aid": "1",
+		"n":   n.name,
+		"pc":  n.pickCode,
+		"t":   strconv.FormatInt(n.mtime.Unix(), 10),
+		"te":  strconv.FormatInt(n.mtime.Unix(), 10),
+		"tp":  strconv.FormatInt(n.mtime.Unix(), 10),
+	}
+	if n.dir {
+		entry["cid"] = strconv.FormatInt(n.id, 10)
+		entry["pid"] = strconv.FormatInt(n.parent, 10)
+		return entry
+	}
+	sum := sha1.Sum(n.content)
+	entry["fid"] = strconv.FormatInt(n.id, 10)
+	entry["cid"] = strconv.FormatInt(n.parent, 10)
+	entry["s"] = len(n.content)
+	entry["sha"] = strings.ToUpper(hex.EncodeToString(sum[:]))
+	entry["ico"] = strings.TrimPrefix(path.Ext(n.name), ".")
+	return entry
+}
+
+func formInt(r *http.Request, key string) int64 {
+	v, _ := strconv.ParseInt(r.Form.Get(key), 10, 64)
+	return v
+}
+
+func (s *Server) handleFiles(r *http.Request) apiResult {
+	cid := formInt(r, "cid")
+	dir := s.nodes[cid]
+	if dir == nil || !dir.dir {
+		return fail(20018, "文件不存在或已删除")
+	}
+	offset, limit := formInt(r, "offset"), formInt(r, "limit")
+	if limit <= 0 {
+		limit = 20
+	}
+
+	children := s.children(cid)
+	data := make([]map[string]interface{}, 0)
+	for i := offset; i < int64(len(children)) && i < offset+limit; i++ {
+		data = append(data, s.fileEntry(children[i]))
+	}
+	pathItems := make([]map[string]interface{}, 0)
+	for _, d := range s.ancestors(cid) {
+		name := d.name
+		if d.id == 0 {
+			name = "根目录"
+		}
+		pathItems = append(pathItems, map[string]interface{}{
+			"cid":  strconv.FormatInt(d.id, 10),
+			"pid":  strconv.FormatInt(d.parent, 10),
+			"name": name,
+		})
+	}
+	return ok(map[string]interface{}{
+		"aid":       "1",
+		"cid":       strconv.FormatInt(cid, 10),
+		"count":     len(children),
+		"data":      data,
+		"offset":    offset,
+		"limit":     limit,
+		"page_size": limit,
+		"path":      pathItems,
+		"state":     true,
+		"errNo":     0,
+		"error":     "",
+	})
+}
+
+func (s *Server) handleDirID(r *http.Request) apiResult {
+	id := int64(0)
+	if n := s.lookup(r.Form.Get("path")); n != nil && n.dir {
+		id = n.id
+	}
+	// 115 answers 0 for a dir that does not exist.
+	return ok(map[string]interface{}{
+		"id":         strconv.FormatInt(id, 10),
+		"is_private": "0",
+		"state":      true,
+		"error":      "",
+		"errno":      0,
+	})
+}
+
+func (s *Server) handleAddDir(r *http.Request) apiResult {
+	pid, name := formInt(r, "pid"), r.Form.Get("cname")
+	if parent := s.nodes[pid]; parent == nil || !parent.dir {
+		return fail(20009, "父目录不存在")
+	}
+	if len(name) == 0 || strings.ContainsAny(name, `/\:*?"<>|`) {
+		return fail(20001, "目录名称不能包含非法字符")
+	}
+	if s.child(pid, name) != nil {
+		return fail(20004, "该目录名称已存在")
+	}
+	n := s.addNode(pid, name, true, nil)
+	return ok(map[string]interface{}{
+		"state":     true,
+		"error":     "",
+		"errno":     0,
+		"aid":       1,
+		"cid":       strconv.FormatInt(n.id, 10),
+		"cname":     name,
+		"file_id":   strconv.FormatInt(n.id, 10),
+		"file_name": name,
+	})
+}
+
+// target returns the node of the first file id of a move, copy or delete,
+// and the dir of pid.
+func (s *Server) target(r *http.Request) (*node, *node, *apiResult) {
+	n := s.nodes[formInt(r, "fid[0]")]
+	if n == nil || n.id == 0 {
+		result := fail(90008, "文件（夹）不存在或已经删除")
+		return nil, nil, &result
+	}
+	dir := s.nodes[formInt(r, "pid")]
+	if r.URL.Path != PathDelete && (dir == nil || !dir.dir) {
+		result := fail(20009, "父目录不存在")
+		return nil, nil, &result
+	}
+	return n, dir, nil
+}
+
+func stateOK() apiResult {
+	return ok(map[string]interface{}{"state": true, "error": "", "errno": 0})
+}
+
+func (s *Server) handleMove(r *http.Request) apiResult {
+	n, dir, failure := s.target(r)
+	if failure != nil {
+		return *failure
+	}
+	for _, d := range s.ancestors(dir.id) {
+		if d.id == n.id {
+			return fail(91002, "不能将文件移动到自身或其子目录下")
+		}
+	}
+	n.parent = dir.id
+	n.mtime = time.Now()
+	return stateOK()
+}
+
+func (s *Server) handleCopy(r *http.Request) apiResult {
+	n, dir, failure := s.target(r)
+	if failure != nil {
+		return *failure
+	}
+	for _, d := range s.ancestors(dir.id) {
+		if d.id == n.id {
+			return fail(91002, "不能将文件复制到自身或其子目录下")
+		}
+	}
+	s.copyLocked(n.id, dir.id)
+	return stateOK()
+}
+
+func (s *Server) handleRename(r *http.Request) apiResult {
+	for key, values := range r.PostForm {
+		if !strings.HasPrefix(key, "files_new_name[") || len(values) == 0 {
+			continue
+		}
+		id, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(key, "files_new_name["), "]"), 10, 64)
+		n := s.nodes[id]
+		if n == nil || id == 0 {
+			return fail(90008, "文件（夹）不存在或已经删除")
+		}
+		if other := s.child(n.parent, values[0]); other != nil && other != n {
+			return fail(20004, "该名称已存在")
+		}
+		n.name = values[0]
+		n.mtime = time.Now()
+	}
+	return stateOK()
+}
+
+func (s *Server) handleDelete(r *http.Request) apiResult {
+	n, _, failure := s.target(r)
+	if failure != nil {
+		return *failure
+	}
+	s.removeLocked(n.id)
+	return stateOK()
+}
+
+// handleDownloadURL answers with the download url of a file, in the
+// encrypted envelope of the real api.
+func (s *Server) handleDownloadURL(r *http.Request) apiResult {
+	input, key, err := _115.DecodeRequest(r.PostForm.Get("data"), s.Key)
+	if err != nil {
+		return fail(1, "decode request fail")
+	}
+	params := struct {
+		PickCode string `json:"pickcode"`
+	}{}
+	if err := json.Unmarshal(input, &params); err != nil {
+		return fail(1, "invalid request")
+	}
+
+	var file *node
+	for _, n := range s.nodes {
+		if !n.dir && n.pickCode == params.PickCode {
+			file = n
+		}
+	}
+	if file == nil {
+		return fail(50003, "文件不存在")
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		strconv.FormatInt(file.id, 10): map[string]interface{}{
+			"file_name": file.name,
+			"file_size": strconv.Itoa(len(file.content)),
+			"pick_code": file.pickCode,
+			"url": map[string]interface{}{
+				"url":    s.URL + PathContent + file.pickCode,
+				"client": 1,
+				"desc":   "",
+				"oss_id": "",
+			},
+		},
+	})
+	encoded, err := _115.EncodeResponse(data, key, s.Key)
+	if err != nil {
+		return fail(1, "encode response fail")
+	}
+	return ok(map[string]interface{}{
+		"state": true,
+		"msg":   "",
+		"errno": 0,
+		"data":  encoded,
+	})
+}
+
+func (s *Server) handleLoginCheck(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.calls[r.URL.Path]++
+	loggedIn := s.loggedIn(r)
+	s.mu.Unlock()
+
+	if !loggedIn {
+		writeJSON(w, map[string]interface{}{
+			"state": 0,
+			"errno": 99,
+			"error": "请重新登录",
+			"data":  map[string]interface{}{},
+		})
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"state": 1,
+		"data": map[string]interface{}{
+			"expire":  time.Now().Add(24 * time.Hour).Unix(),
+			"user_id": s.UserID,
+			"link":    "",
+		},
+	})
+}
+
+// handleContent serves the content of the file of a download url, with
+// range requests.
+func (s *Server) handleContent(w http.ResponseWriter, r *http.Request) {
+	pickCode := strings.TrimPrefix(r.URL.Path, PathContent)
+	s.mu.Lock()
+	s.calls[PathContent]++
+	var file *node
+	for _, n := range s.nodes {
+		if !n.dir && n.pickCode == pickCode {
+			file = n
+		}
+	}
+	s.mu.Unlock()
+
+	if file == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, file.name, file.mtime, bytes.NewReader(file.content))
+}
@@ -0,0 +1,245 @@
+package fake115
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	_115 "github.com/gaoyb7/115drive-webdav/115"
+)
+
+// Paths of the upload apis, as counted by Calls and given to Fail.
+var (
+	PathUploadInfo  = _115.APIPathUploadInfo
+	PathInitUpload  = _115.APIPathInitUpload
+	PathUploadToken = _115.APIPathGetUploadToken
+	// PathOSS is the key of the requests to the OSS bucket of uploads,
+	// which are sent to the paths of the bucket and the uploaded objects,
+	// see WithOSSEndpoint. A failure given
+	// to Fail answers with its status, 500 if it has none.
+	PathOSS = "oss"
+	// PathOSSPart is the key of the part uploads to OSS alone, given to
+	// Fail to fail an upload once it is initiated.
+	PathOSSPart = "oss part"
+)
+
+const (
+	fakeBucket = "fake-bucket"
+	// signCheckSize is the length of the head of a file whose SHA1 is
+	// asked for by the sign check of a fast upload.
+	signCheckSize = 1024
+)
+
+// ossUpload is a multipart upload in progress.
+type ossUpload struct {
+	parts map[int][]byte
+}
+
+// callbackVar is the callback_var of an upload, handed back by the client
+// with the completion of the upload.
+type callbackVar struct {
+	CategoryID string `json:"x:cid"`
+	Name       string `json:"x:name"`
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func (s *Server) handleUploadInfo(r *http.Request) apiResult {
+	return ok(map[string]interface{}{
+		"state":   true,
+		"errno":   0,
+		"error":   "",
+		"user_id": s.UserID,
+		"userkey": "fakeuserkey",
+	})
+}
+
+func (s *Server) handleUploadToken(r *http.Request) apiResult {
+	return ok(map[string]interface{}{
+		"StatusCode":      "200",
+		"AccessKeyId":     "fakeaccesskeyid",
+		"AccessKeySecret": "fakeaccesskeysecret",
+		"SecurityToken":   "fakesecuritytoken",
+		"Expiration":      "",
+	})
+}
+
+// handleInitUpload links the file to the content of a file of the drive
+// with the same SHA1 and size, after a sign check, or asks the client to
+// upload it to OSS.
+func (s *Server) handleInitUpload(r *http.Request) apiResult {
+	cid, err := strconv.ParseInt(strings.TrimPrefix(r.PostForm.Get("target"), "U_1_"), 10, 64)
+	if dir := s.nodes[cid]; err != nil || dir == nil || !dir.dir {
+		return fail(20009, "父目录不存在")
+	}
+	name, sha := r.PostForm.Get("filename"), r.PostForm.Get("fileid")
+	size, _ := strconv.Atoi(r.PostForm.Get("filesize"))
+
+	var same *node
+	for _, n := range s.nodes {
+		if !n.dir && len(n.content) == size && sha1Hex(n.content) == sha {
+			same = n
+		}
+	}
+	if same != nil {
+		checked := same.content
+		if len(checked) > signCheckSize {
+			checked = checked[:signCheckSize]
+		}
+		signKey := "fakesignkey"
+		if len(checked) > 0 && r.PostForm.Get("sign_key") != signKey {
+			return ok(map[string]interface{}{
+				"status":     7,
+				"statuscode": 701,
+				"statusmsg":  "",
+				"sign_key":   signKey,
+				"sign_check": fmt.Sprintf("0-%d", len(checked)-1),
+			})
+		}
+		if len(checked) > 0 && r.PostForm.Get("sign_val") != sha1Hex(checked) {
+			return fail(402, "校验失败")
+		}
+		n := s.writeFileLocked(cid, name, same.content)
+		return ok(map[string]interface{}{
+			"status":     2,
+			"statuscode": 0,
+			"statusmsg":  "",
+			"pickcode":   n.pickCode,
+		})
+	}
+
+	vars, _ := json.Marshal(callbackVar{CategoryID: strconv.FormatInt(cid, 10), Name: name})
+	s.nextID++
+	return ok(map[string]interface{}{
+		"status":     1,
+		"statuscode": 0,
+		"statusmsg":  "",
+		"bucket":     fakeBucket,
+		"object":     "upload/" + strconv.FormatInt(s.nextID, 36),
+		"callback": map[string]interface{}{
+			"callback":     `{"callbackUrl":"https://uplb.115.com/3.0/callback.php"}`,
+			"callback_var": string(vars),
+		},
+	})
+}
+
+// writeFileLocked creates the file name in the dir parent, replacing a file
+// with the same name.
+func (s *Server) writeFileLocked(parent int64, name string, content []byte) *node {
+	if old := s.child(parent, name); old != nil && !old.dir {
+		s.removeLocked(old.id)
+	}
+	return s.addNode(parent, name, false, content)
+}
+
+// handleOSS serves the multipart upload api of OSS: initiate, upload part,
+// abort and complete, which creates the file given by the callback var.
+func (s *Server) handleOSS(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	keys := []string{PathOSS}
+	if r.Method == http.MethodPut {
+		keys = append(keys, PathOSSPart)
+	}
+	for _, key := range keys {
+		s.calls[key]++
+		if failures := s.failures[key]; len(failures) > 0 {
+			s.failures[key] = failures[1:]
+			status := failures[0].Status
+			if status == 0 || status == http.StatusOK {
+				status = http.StatusInternalServerError
+			}
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		s.nextID++
+		uploadID = strconv.FormatInt(s.nextID, 36)
+		s.uploads[uploadID] = &ossUpload{parts: make(map[int][]byte)}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><UploadId>%s</UploadId></InitiateMultipartUploadResult>", fakeBucket, uploadID)
+
+	case r.Method == http.MethodPut && s.uploads[uploadID] != nil:
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil || partNumber <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.uploads[uploadID].parts[partNumber] = data
+		sum := md5.Sum(data)
+		w.Header().Set("ETag", `"`+strings.ToUpper(hex.EncodeToString(sum[:]))+`"`)
+
+	case r.Method == http.MethodPost && s.uploads[uploadID] != nil:
+		upload := s.uploads[uploadID]
+		delete(s.uploads, uploadID)
+		complete := struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}{}
+		body, _ := ioutil.ReadAll(r.Body)
+		rawVars, _ := base64.StdEncoding.DecodeString(r.Header.Get("x-oss-callback-var"))
+		vars := callbackVar{}
+		if xml.Unmarshal(body, &complete) != nil || json.Unmarshal(rawVars, &vars) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sort.Slice(complete.Parts, func(i, j int) bool {
+			return complete.Parts[i].PartNumber < complete.Parts[j].PartNumber
+		})
+		content := make([]byte, 0)
+		for _, part := range complete.Parts {
+			content = append(content, upload.parts[part.PartNumber]...)
+		}
+
+		cid, _ := strconv.ParseInt(vars.CategoryID, 10, 64)
+		if dir := s.nodes[cid]; dir == nil || !dir.dir {
+			writeJSON(w, map[string]interface{}{"state": false, "code": 20009, "message": "父目录不存在"})
+			return
+		}
+		n := s.writeFileLocked(cid, vars.Name, content)
+		writeJSON(w, map[string]interface{}{
+			"state":   true,
+			"code":    0,
+			"message": "",
+			"data": map[string]interface{}{
+				"file_id":   strconv.FormatInt(n.id, 10),
+				"file_name": n.name,
+				"pick_code": n.pickCode,
+			},
+		})
+
+	case r.Method == http.MethodDelete && s.uploads[uploadID] != nil:
+		delete(s.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Uploads returns the number of the multipart uploads to OSS that are
+// neither completed nor aborted.
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
@@ -0,0 +1,180 @@
+package _115
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// dirIndex maps the ids of the dirs and files of cached listings to their
+// paths, so that changes reported by id can be applied to the listings, and
+// the paths of dirs to their ids, so that a dir is listed without first
+// asking 115 for its id. Paths are keyed by FileInfo.GetID, which keeps
+// dirs and files apart.
+type dirIndex struct {
+	mu    sync.Mutex
+	paths map[string]string
+	ids   map[string]string
+	// children holds the keys added by the listing of each dir, so that
+	// they are replaced when the listing is.
+	children map[string][]string
+}
+
+func newDirIndex() *dirIndex {
+	x := &dirIndex{}
+	x.reset()
+	return x
+}
+
+// reset forgets every path but the root.
+func (x *dirIndex) reset() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.paths = map[string]string{"d0": "/"}
+	x.ids = map[string]string{"/": "0"}
+	x.children = make(map[string][]string)
+}
+
+// addListing records dir, whose id is cid, and the files of its listing.
+func (x *dirIndex) addListing(dir string, cid string, infos []FileInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if len(cid) > 0 {
+		x.setDir(dir, cid)
+	}
+	for _, key := range x.children[dir] {
+		if p, ok := x.paths[key]; ok && path.Dir(p) == dir {
+			delete(x.paths, key)
+			if strings.HasPrefix(key, "d") && x.ids[p] == key[1:] {
+				delete(x.ids, p)
+			}
+		}
+	}
+	keys := make([]string, 0, len(infos))
+	for idx := range infos {
+		info := &infos[idx]
+		p := path.Join(dir, info.Name)
+		if info.IsDir() {
+			x.setDir(p, info.CategoryID.String())
+		} else {
+			x.paths[info.GetID()] = p
+		}
+		keys = append(keys, info.GetID())
+	}
+	x.children[dir] = keys
+}
+
+// addAncestors records the dirs of the path field of a listing, the dirs
+// from the root down to the listed dir.
+func (x *dirIndex) addAncestors(dirs []PathItem) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	p := "/"
+	for _, d := range dirs {
+		if d.CategoryID.String() == "0" {
+			continue
+		}
+		if len(d.Name) == 0 {
+			return
+		}
+		p = path.Join(p, d.Name)
+		x.setDir(p, d.CategoryID.String())
+	}
+}
+
+// addDir records dir, whose id is cid.
+func (x *dirIndex) addDir(dir string, cid string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.setDir(dir, cid)
+}
+
+func (x *dirIndex) setDir(dir string, cid string) {
+	if old, ok := x.ids[dir]; ok && old != cid && x.paths["d"+old] == dir {
+		delete(x.paths, "d"+old)
+	}
+	x.ids[dir] = cid
+	x.paths["d"+cid] = dir
+}
+
+// dirID returns the id of dir.
+func (x *dirIndex) dirID(dir string) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	cid, ok := x.ids[dir]
+	return cid, ok
+}
+
+// lookup returns the path of the dir with id if isDir, else of the file
+// with id.
+func (x *dirIndex) lookup(id string, isDir bool) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	key := "f" + id
+	if isDir {
+		key = "d" + id
+	}
+	p, ok := x.paths[key]
+	return p, ok
+}
+
+// moveTree moves dir and everything below it to dst, after a rename or a
+// move, which keep the ids.
+func (x *dirIndex) moveTree(dir string, dst string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeTreeLocked(dst)
+	rebase := func(p string) string {
+		return dst + strings.TrimPrefix(p, dir)
+	}
+	for key, p := range x.paths {
+		if inTree(p, dir) && key != "d0" {
+			x.paths[key] = rebase(p)
+		}
+	}
+	ids := make(map[string]string)
+	for p, cid := range x.ids {
+		if inTree(p, dir) && p != "/" {
+			delete(x.ids, p)
+			ids[rebase(p)] = cid
+		}
+	}
+	for p, cid := range ids {
+		x.ids[p] = cid
+	}
+	children := make(map[string][]string)
+	for d, keys := range x.children {
+		if inTree(d, dir) {
+			delete(x.children, d)
+			children[rebase(d)] = keys
+		}
+	}
+	for d, keys := range children {
+		x.children[d] = keys
+	}
+}
+
+// removeTree drops dir and everything below it.
+func (x *dirIndex) removeTree(dir string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeTreeLocked(dir)
+}
+
+func (x *dirIndex) removeTreeLocked(dir string) {
+	for key, p := range x.paths {
+		if inTree(p, dir) && key != "d0" {
+			delete(x.paths, key)
+		}
+	}
+	for p := range x.ids {
+		if inTree(p, dir) && p != "/" {
+			delete(x.ids, p)
+		}
+	}
+	for d := range x.children {
+		if inTree(d, dir) {
+			delete(x.children, d)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package _115
+
+import (
+	"crypto/rsa"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common/blockcache"
+	"github.com/gaoyb7/115drive-webdav/common/metastore"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultChunkSize = 4 * 1024 * 1024
+)
+
+// Option configures optional behaviour of a DriveClient.
+type Option func(*DriveClient)
+
+// WithRedirect makes ServeContent answer downloads with a 302 redirect to
+// the 115 CDN instead of proxying the content, for requests authenticated as
+// one of users, or whose User-Agent contains one of userAgents, ignoring
+// case. Other clients keep being proxied.
+func WithRedirect(users []string, userAgents []string) Option {
+	return func(c *DriveClient) {
+		c.redirectUsers = make(map[string]bool)
+		for _, user := range users {
+			c.redirectUsers[user] = true
+		}
+		c.redirectUserAgents = nil
+		for _, ua := range userAgents {
+			if len(ua) > 0 {
+				c.redirectUserAgents = append(c.redirectUserAgents, strings.ToLower(ua))
+			}
+		}
+	}
+}
+
+// WithParallelRange makes ServeContent split proxied downloads into chunks
+// of chunkSize bytes, fetched from the CDN over up to connections concurrent
+// range requests and written to the client in order. A single CDN connection
+// is throttled, so this speeds up streaming of large files. connections of 1
+// or less keeps proxying each request over a single connection.
+func WithParallelRange(connections int, chunkSize int64) Option {
+	return func(c *DriveClient) {
+		c.connections = connections
+		c.chunkSize = chunkSize
+		if c.chunkSize <= 0 {
+			c.chunkSize = defaultChunkSize
+		}
+	}
+}
+
+// WithBlockCache makes ServeContent keep downloaded content in cache, in
+// blocks keyed by the file SHA1, or the pick code if it is unknown. Blocks
+// found in the cache are served without contacting 115, which makes seeking
+// back and forth in videos much cheaper. The chunks of parallel downloads
+// become the cache blocks, so the chunk size is replaced by the block size.
+func WithBlockCache(cache *blockcache.Cache) Option {
+	return func(c *DriveClient) {
+		c.blockCache = cache
+	}
+}
+
+// WithReadAhead makes ServeContent keep fetching up to size bytes past the
+// position of a client that reads a file sequentially, across its range
+// requests, so that latency spikes of the CDN do not stall playback. The
+// read ahead is dropped when the client seeks, disconnects or stays idle.
+// size of 0 or less disables read ahead.
+func WithReadAhead(size int64) Option {
+	return func(c *DriveClient) {
+		c.readAhead = size
+		c.readStreams = nil
+		if size > 0 {
+			c.readStreams = newReadStreamRegistry()
+		}
+	}
+}
+
+// WithSessionMonitor makes the client run a login check every interval, and
+// replace its cookies with the ones returned by reload, if not nil, when
+// they change. A client with a session monitor starts even if the initial
+// login check fails, and waits for new cookies.
+func WithSessionMonitor(interval time.Duration, reload func() (*Cookies, error)) Option {
+	return func(c *DriveClient) {
+		c.sessionCheckInterval = interval
+		c.reloadCookies = reload
+	}
+}
+
+// WithMetadataStore makes the client keep directory listings and the ids of
+// dirs in store, so that they survive restarts. A stored listing is served
+// without contacting 115, and refreshed in the background when it is older
+// than the memory cache expiry.
+func WithMetadataStore(store *metastore.Store) Option {
+	return func(c *DriveClient) {
+		c.metaStore = store
+	}
+}
+
+// WithDirCacheExpire sets how long directory listings are cached, 2 minutes
+// by default. With WithChangeWatch, changes made outside of this server no
+// longer wait for the expiry, and it can be much longer.
+func WithDirCacheExpire(expire time.Duration) Option {
+	return func(c *DriveClient) {
+		c.dirCacheExpire = expire
+		if c.dirCacheExpire <= 0 {
+			c.dirCacheExpire = defaultDirCacheExpire
+		}
+	}
+}
+
+// WithChangeWatch makes the client poll the file event log of the account
+// every interval, and drop the cached listings of the dirs that changed.
+func WithChangeWatch(interval time.Duration) Option {
+	return func(c *DriveClient) {
+		c.changeInterval = interval
+	}
+}
+
+// WithRateLimits sets the rates, in requests per second, of the api calls,
+// of the download url calls, which 115 throttles apart, and of the content
+// fetches from the CDN, along with the burst of the three. A rate of 0 is
+// the default of 5 requests per second, a negative rate is unlimited. The
+// api rates are halved while 115 throttles the calls.
+func WithRateLimits(api float64, downloadURL float64, content float64, burst int) Option {
+	return func(c *DriveClient) {
+		c.apiLimiter = newAdaptiveLimiter("api", api, burst)
+		c.urlLimiter = newAdaptiveLimiter("download url", downloadURL, burst)
+		c.contentLimiter = newLimiter(content, burst)
+	}
+}
+
+// WithRetry sets how many times an api call is retried, 3 by default, when
+// 115 throttles it, or on transient errors of GET calls. A count of 0 keeps
+// the default, a negative count disables retries.
+func WithRetry(count int) Option {
+	return func(c *DriveClient) {
+		if count == 0 {
+			count = defaultRetryCount
+		}
+		if count < 0 {
+			count = 0
+		}
+		c.HttpClient.SetRetryCount(count)
+	}
+}
+
+// WithAPIBaseURLs sends the api calls of the client to the servers at the
+// urls of urls instead of the ones of 115, such as to a fake 115 server in
+// tests. Empty urls keep the servers of 115. The session cookies are sent
+// to the servers as to 115.
+func WithAPIBaseURLs(urls APIBaseURLs) Option {
+	return func(c *DriveClient) {
+		for _, p := range []struct {
+			field *string
+			url   string
+		}{
+			{&c.apiBaseURLs.WebAPI, urls.WebAPI},
+			{&c.apiBaseURLs.ProAPI, urls.ProAPI},
+			{&c.apiBaseURLs.PassportAPI, urls.PassportAPI},
+			{&c.apiBaseURLs.UploadAPI, urls.UploadAPI},
+			{&c.apiBaseURLs.LifeAPI, urls.LifeAPI},
+		} {
+			if len(p.url) == 0 {
+				continue
+			}
+			u := mustParseBaseURL(p.url)
+			*p.field = strings.TrimSuffix(u.String(), "/")
+			c.jar.addHost(u.Host)
+		}
+	}
+}
+
+// WithOSSEndpoint sends the uploads of the client to the OSS compatible
+// server at endpointURL, with the bucket in the path of the object urls,
+// instead of the buckets of 115 on aliyun OSS.
+func WithOSSEndpoint(endpointURL string) Option {
+	return func(c *DriveClient) {
+		c.ossEndpoint = strings.TrimSuffix(mustParseBaseURL(endpointURL).String(), "/")
+	}
+}
+
+// WithServerKey makes the client decode download urls with serverKey, the
+// RSA key of the server set with WithAPIBaseURLs, instead of the key of 115.
+func WithServerKey(serverKey *rsa.PublicKey) Option {
+	return func(c *DriveClient) {
+		c.serverKey = serverKey
+	}
+}
+
+func mustParseBaseURL(rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		logrus.Panicf("invalid base url: %s", rawURL)
+	}
+	return u
+}
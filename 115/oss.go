@@ -0,0 +1,229 @@
+package _115
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	OSSEndpoint = "oss-cn-shenzhen.aliyuncs.com"
+
+	ossMinPartSize = 10 * 1024 * 1024
+	ossMaxParts    = 10000
+
+	ossAbortTimeout = 30 * time.Second
+)
+
+// ossClient uploads objects to 115's aliyun OSS buckets with the temporary
+// credentials returned by APIGetUploadToken.
+type ossClient struct {
+	httpClient *resty.Client
+	token      *APIGetUploadTokenResp
+	// bucketURL is the url of the bucket, which object urls start with.
+	bucketURL string
+	bucket    string
+	object    string
+}
+
+type ossInitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type ossPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type ossCompleteMultipartUpload struct {
+	XMLName xml.Name  `xml:"CompleteMultipartUpload"`
+	Parts   []ossPart `xml:"Part"`
+}
+
+// newOSSClient returns a client of the object of bucket, sending its
+// requests with transport to endpoint, a url of a server with the buckets
+// in its paths, or to the bucket on aliyun OSS if endpoint is empty.
+func newOSSClient(transport http.RoundTripper, token *APIGetUploadTokenResp, endpoint string, bucket string, object string) *ossClient {
+	bucketURL := fmt.Sprintf("https://%s.%s", bucket, OSSEndpoint)
+	if len(endpoint) > 0 {
+		bucketURL = endpoint + "/" + bucket
+	}
+	return &ossClient{
+		httpClient: resty.New().SetTransport(transport),
+		token:      token,
+		bucketURL:  bucketURL,
+		bucket:     bucket,
+		object:     object,
+	}
+}
+
+// multipartUpload uploads size bytes of r with the OSS multipart API, then
+// completes the upload with 115's callback so the file shows up in the drive.
+func (o *ossClient) multipartUpload(ctx context.Context, r io.ReaderAt, size int64, callback string, callbackVar string) (*OSSCallbackResp, error) {
+	uploadID, err := o.initiateMultipartUpload(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	partSize := int64(ossMinPartSize)
+	for size/partSize >= ossMaxParts {
+		partSize *= 2
+	}
+
+	parts := make([]ossPart, 0)
+	bufSize := partSize
+	if size < bufSize {
+		bufSize = size
+	}
+	buf := make([]byte, bufSize)
+	for offset, partNumber := int64(0), 1; offset < size || partNumber == 1; offset, partNumber = offset+partSize, partNumber+1 {
+		n := partSize
+		if offset+n > size {
+			n = size - offset
+		}
+		if _, err := r.ReadAt(buf[:n], offset); err != nil && err != io.EOF {
+			o.abortMultipartUpload(uploadID)
+			return nil, fmt.Errorf("oss read part fail, part_number: %d, err: %v", partNumber, err)
+		}
+		etag, err := o.uploadPart(ctx, uploadID, partNumber, buf[:n])
+		if err != nil {
+			o.abortMultipartUpload(uploadID)
+			return nil, err
+		}
+		parts = append(parts, ossPart{PartNumber: partNumber, ETag: etag})
+	}
+
+	result, err := o.completeMultipartUpload(ctx, uploadID, parts, callback, callbackVar)
+	if err != nil {
+		o.abortMultipartUpload(uploadID)
+		return nil, err
+	}
+	return result, nil
+}
+
+func (o *ossClient) initiateMultipartUpload(ctx context.Context) (string, error) {
+	resp, err := o.do(ctx, http.MethodPost, "uploads", nil, "application/octet-stream", nil)
+	if err != nil {
+		return "", fmt.Errorf("oss initiate multipart upload fail, err: %v", err)
+	}
+
+	result := ossInitiateMultipartUploadResult{}
+	if err := xml.Unmarshal(resp.Body(), &result); err != nil {
+		return "", fmt.Errorf("oss initiate multipart upload, call xml.Unmarshal fail, body: %s", resp.String())
+	}
+	return result.UploadID, nil
+}
+
+func (o *ossClient) uploadPart(ctx context.Context, uploadID string, partNumber int, data []byte) (string, error) {
+	subResource := fmt.Sprintf("partNumber=%d&uploadId=%s", partNumber, uploadID)
+	resp, err := o.do(ctx, http.MethodPut, subResource, nil, "application/octet-stream", data)
+	if err != nil {
+		return "", fmt.Errorf("oss upload part fail, part_number: %d, err: %v", partNumber, err)
+	}
+	return resp.Header().Get("ETag"), nil
+}
+
+// abortMultipartUpload drops the parts uploaded so far, which OSS keeps
+// otherwise. It runs on its own context, as the one of the upload may be
+// what failed it.
+func (o *ossClient) abortMultipartUpload(uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), ossAbortTimeout)
+	defer cancel()
+	if _, err := o.do(ctx, http.MethodDelete, "uploadId="+uploadID, nil, "", nil); err != nil {
+		logrus.Warnf("oss abort multipart upload fail, upload_id: %s, err: %v", uploadID, err)
+	}
+}
+
+func (o *ossClient) completeMultipartUpload(ctx context.Context, uploadID string, parts []ossPart, callback string, callbackVar string) (*OSSCallbackResp, error) {
+	body, err := xml.Marshal(ossCompleteMultipartUpload{Parts: parts})
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"x-oss-callback":     base64.StdEncoding.EncodeToString([]byte(callback)),
+		"x-oss-callback-var": base64.StdEncoding.EncodeToString([]byte(callbackVar)),
+	}
+	resp, err := o.do(ctx, http.MethodPost, "uploadId="+uploadID, headers, "application/xml", body)
+	if err != nil {
+		return nil, fmt.Errorf("oss complete multipart upload fail, err: %v", err)
+	}
+
+	result := OSSCallbackResp{}
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, fmt.Errorf("oss complete multipart upload, call json.Unmarshal fail, body: %s", resp.String())
+	}
+	if !result.State {
+		return nil, fmt.Errorf("oss complete multipart upload fail, state is false, message: %s", result.Message)
+	}
+	return &result, nil
+}
+
+func (o *ossClient) do(ctx context.Context, method string, subResource string, headers map[string]string, contentType string, body []byte) (*resty.Response, error) {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers["x-oss-security-token"] = o.token.SecurityToken
+	date := time.Now().UTC().Format(http.TimeFormat)
+
+	req := o.httpClient.R().
+		SetContext(ctx).
+		SetHeaders(headers).
+		SetHeader("Date", date).
+		SetHeader("Content-Type", contentType).
+		SetHeader("Authorization", o.authorization(method, contentType, date, headers, subResource))
+	if body != nil {
+		req.SetBody(body)
+	}
+
+	url := fmt.Sprintf("%s/%s?%s", o.bucketURL, o.object, subResource)
+	resp, err := req.Execute(method, url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("http status: %d, body: %s", resp.StatusCode(), resp.String())
+	}
+	return resp, nil
+}
+
+// authorization signs a request with the OSS header signature, see
+// https://help.aliyun.com/document_detail/31951.html
+func (o *ossClient) authorization(method string, contentType string, date string, headers map[string]string, subResource string) string {
+	ossHeaders := make([]string, 0, len(headers))
+	for k, v := range headers {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-oss-") {
+			ossHeaders = append(ossHeaders, k+":"+v)
+		}
+	}
+	sort.Strings(ossHeaders)
+
+	var buf bytes.Buffer
+	buf.WriteString(method + "\n")
+	buf.WriteString("\n") // Content-MD5
+	buf.WriteString(contentType + "\n")
+	buf.WriteString(date + "\n")
+	for _, h := range ossHeaders {
+		buf.WriteString(h + "\n")
+	}
+	buf.WriteString("/" + o.bucket + "/" + o.object + "?" + subResource)
+
+	mac := hmac.New(sha1.New, []byte(o.token.AccessKeySecret))
+	mac.Write(buf.Bytes())
+	return "OSS " + o.token.AccessKeyID + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,98 @@
+package _115
+
+import (
+	"fmt"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	QRCodeStatusWaiting   = 0
+	QRCodeStatusScanned   = 1
+	QRCodeStatusConfirmed = 2
+	QRCodeStatusExpired   = -1
+	QRCodeStatusCanceled  = -2
+)
+
+// QRCodeApps are the app types a QR code login can sign in as. 115 keeps one
+// session per app type, so signing in as an app that is used elsewhere signs
+// the other device out.
+var QRCodeApps = []string{"web", "android", "ios", "linux", "mac", "windows", "tv", "alipaymini", "wechatmini", "qandroid"}
+
+// QRCodeSession is a QR code login in progress: the QR code is scanned and
+// confirmed in the 115 mobile app, then the session cookies can be fetched.
+type QRCodeSession struct {
+	client *resty.Client
+
+	App  string
+	UID  string
+	Time string
+	Sign string
+}
+
+// NewQRCodeSession requests a QR code to sign in as app.
+func NewQRCodeSession(app string) (*QRCodeSession, error) {
+	if !isQRCodeApp(app) {
+		return nil, fmt.Errorf("invalid app type: %s", app)
+	}
+
+	client := resty.New().SetHeader("User-Agent", UserAgent)
+	resp, err := APIGetQRCodeToken(client, app)
+	if err != nil {
+		return nil, err
+	}
+	if resp.State != 1 || len(resp.Data.UID) == 0 {
+		return nil, fmt.Errorf("get qrcode token fail, state: %d, message: %s", resp.State, resp.Message)
+	}
+
+	return &QRCodeSession{
+		client: client,
+		App:    app,
+		UID:    resp.Data.UID,
+		Time:   resp.Data.Time.String(),
+		Sign:   resp.Data.Sign,
+	}, nil
+}
+
+// Image returns the QR code as a PNG image.
+func (s *QRCodeSession) Image() ([]byte, error) {
+	return APIGetQRCodeImage(s.client, s.App, s.UID)
+}
+
+// Status returns the QRCodeStatus of the login. 115 holds the call for a
+// while when the status does not change, so it can be called in a loop.
+func (s *QRCodeSession) Status() (int, error) {
+	resp, err := APIGetQRCodeStatus(s.client, s.UID, s.Time, s.Sign)
+	if err != nil {
+		return 0, err
+	}
+	if resp.State != 1 {
+		return 0, fmt.Errorf("get qrcode status fail, state: %d, message: %s", resp.State, resp.Message)
+	}
+	return resp.Data.Status, nil
+}
+
+// Login returns the session cookies once the login has been confirmed.
+func (s *QRCodeSession) Login() (*Cookies, error) {
+	resp, err := APIQRCodeLogin(s.client, s.App, s.UID)
+	if err != nil {
+		return nil, err
+	}
+	if resp.State != 1 {
+		return nil, fmt.Errorf("qrcode login fail, state: %d, errno: %d, error: %s", resp.State, resp.ErrNo, resp.Error)
+	}
+	cookies := resp.Data.Cookie
+	if len(cookies.UID) == 0 || len(cookies.CID) == 0 || len(cookies.SEID) == 0 {
+		return nil, fmt.Errorf("qrcode login fail, incomplete cookies, user_id: %s", resp.Data.UserID)
+	}
+	return &cookies, nil
+}
+
+func isQRCodeApp(app string) bool {
+	for _, a := range QRCodeApps {
+		if a == app {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package _115
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/go-resty/resty/v2"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultRateLimit  = 5
+	defaultRetryCount = 3
+	retryWaitTime     = 500 * time.Millisecond
+	retryMaxWaitTime  = 10 * time.Second
+	// minAdaptiveRate is the rate an adaptive limiter never slows down
+	// below, one request every 5 seconds.
+	minAdaptiveRate = rate.Limit(0.2)
+)
+
+// adaptiveLimiter is a rate limiter that halves its rate when 115 throttles
+// a request, and speeds back up to its configured rate as requests succeed.
+type adaptiveLimiter struct {
+	*rate.Limiter
+	mu  sync.Mutex
+	max rate.Limit
+	// name is the api class of the limiter, for logs.
+	name string
+}
+
+// newAdaptiveLimiter returns a limiter of r requests per second, see
+// newLimiter.
+func newAdaptiveLimiter(name string, r float64, burst int) *adaptiveLimiter {
+	l := newLimiter(r, burst)
+	return &adaptiveLimiter{
+		Limiter: l,
+		max:     l.Limit(),
+		name:    name,
+	}
+}
+
+// newLimiter returns a limiter of r requests per second, the default rate
+// if r is 0, or unlimited if r is negative.
+func newLimiter(r float64, burst int) *rate.Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	if r == 0 {
+		r = defaultRateLimit
+	}
+	if r < 0 {
+		return rate.NewLimiter(rate.Inf, burst)
+	}
+	return rate.NewLimiter(rate.Limit(r), burst)
+}
+
+func (l *adaptiveLimiter) slowDown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cur := l.Limit()
+	if cur == rate.Inf || cur <= minAdaptiveRate {
+		return
+	}
+	next := cur / 2
+	if next < minAdaptiveRate {
+		next = minAdaptiveRate
+	}
+	l.SetLimit(next)
+	logrus.Warnf("115 %s requests throttled, slow down to %.2f requests/s", l.name, float64(next))
+}
+
+func (l *adaptiveLimiter) speedUp() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cur := l.Limit()
+	if cur >= l.max {
+		return
+	}
+	// Additive increase, the configured rate is back after 20 requests
+	// in a row succeed.
+	next := cur + l.max/20
+	if next > l.max {
+		next = l.max
+	}
+	l.SetLimit(next)
+	if next == l.max {
+		logrus.Infof("115 %s requests back to %.2f requests/s", l.name, float64(next))
+	}
+}
+
+// limiterFor returns the limiter of the api class of url. Download urls
+// are throttled by 115 apart from the other api calls.
+func (c *DriveClient) limiterFor(url string) *adaptiveLimiter {
+	if strings.HasPrefix(url, c.apiBaseURLs.ProAPI+APIPathGetDownloadURL) {
+		return c.urlLimiter
+	}
+	return c.apiLimiter
+}
+
+// setupRetry makes the api calls of the client wait for their limiter, and
+// retry with exponential backoff and jitter when 115 throttles them or,
+// for GET calls, on transient errors.
+func (c *DriveClient) setupRetry() {
+	c.HttpClient.
+		SetRetryCount(defaultRetryCount).
+		SetRetryWaitTime(retryWaitTime).
+		SetRetryMaxWaitTime(retryMaxWaitTime).
+		OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+			return c.limiterFor(r.URL).Wait(r.Context())
+		}).
+		OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+			if isThrottled(resp) {
+				// Fails the call with a typed error once the retries are
+				// used up, retry conditions still see the response.
+				return fmt.Errorf("115 api throttled, http status: %d, %w", resp.StatusCode(), common.ErrRateLimited)
+			}
+			c.limiterFor(resp.Request.URL).speedUp()
+			return nil
+		}).
+		AddRetryCondition(shouldRetry).
+		AddRetryHook(func(resp *resty.Response, err error) {
+			if resp != nil && resp.Request != nil {
+				if isThrottled(resp) {
+					c.limiterFor(resp.Request.URL).slowDown()
+				}
+				logrus.Warnf("retry 115 api, url: %s, attempt: %d, http status: %d, err: %v", resp.Request.URL, resp.Request.Attempt, resp.StatusCode(), err)
+			}
+		})
+}
+
+// shouldRetry reports whether a call is worth retrying. Throttled calls
+// were not carried out and are always retried. Other failures are only
+// retried for GET calls, as a POST, such as a copy, may have been carried
+// out before the failure and must not be repeated.
+func shouldRetry(resp *resty.Response, err error) bool {
+	if resp == nil || resp.Request == nil {
+		return false
+	}
+	if isThrottled(resp) {
+		return true
+	}
+	if resp.Request.Method != http.MethodGet {
+		return false
+	}
+	return err != nil || resp.StatusCode() >= http.StatusInternalServerError
+}
+
+// throttleBody holds the fields in which 115 reports throttling, which
+// vary between apis.
+type throttleBody struct {
+	Msg     string `json:"msg"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// isThrottled reports whether 115 rejected a call for being too frequent,
+// with a 429, a 405 from its firewall, or an api error saying so.
+func isThrottled(resp *resty.Response) bool {
+	if resp == nil || resp.RawResponse == nil {
+		return false
+	}
+	switch resp.StatusCode() {
+	case http.StatusTooManyRequests, http.StatusMethodNotAllowed:
+		return true
+	}
+	// Messages may come with their characters escaped, look for both
+	// forms before decoding the body.
+	body := resp.Body()
+	if !strings.Contains(string(body), "频繁") && !strings.Contains(strings.ToLower(string(body)), `\u9891\u7e41`) {
+		return false
+	}
+	b := throttleBody{}
+	if err := json.Unmarshal(body, &b); err != nil {
+		return false
+	}
+	return strings.Contains(b.Msg+b.Error+b.Message, "频繁")
+}
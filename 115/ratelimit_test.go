@@ -0,0 +1,164 @@
+package _115
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/time/rate"
+)
+
+func TestIsThrottled(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		status int
+		body   string
+		want   bool
+	}{
+		{"429", http.StatusTooManyRequests, "", true},
+		{"405 of the firewall", http.StatusMethodNotAllowed, "<html></html>", true},
+		{"error", http.StatusOK, `{"state":false,"errno":911,"error":"请求过于频繁"}`, true},
+		{"msg", http.StatusOK, `{"state":false,"msg":"操作过于频繁，请稍后再试"}`, true},
+		{"escaped message", http.StatusOK, `{"state":false,"message":"\u64cd\u4f5c\u8fc7\u4e8e\u9891\u7e41"}`, true},
+		{"escaped in upper case", http.StatusOK, `{"state":false,"error":"\u9891\u7E41"}`, true},
+		{"success", http.StatusOK, `{"state":true,"errno":0}`, false},
+		{"in a file name", http.StatusOK, `{"state":true,"data":[{"n":"频繁.txt"}]}`, false},
+		{"not json", http.StatusOK, `频繁`, false},
+		{"other error", http.StatusOK, `{"state":false,"errno":20004,"error":"该目录名称已存在"}`, false},
+		{"server error", http.StatusInternalServerError, "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer s.Close()
+			resp, err := resty.New().R().Get(s.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := isThrottled(resp); got != tc.want {
+				t.Errorf("isThrottled: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+	if isThrottled(nil) {
+		t.Errorf("isThrottled(nil): got true")
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := newAdaptiveLimiter("test", 4, 1)
+	for _, want := range []rate.Limit{2, 1, 0.5, 0.25, minAdaptiveRate, minAdaptiveRate} {
+		l.slowDown()
+		if got := l.Limit(); got != want {
+			t.Fatalf("slowDown: got %v, want %v", got, want)
+		}
+	}
+	// Back from the minimum in steps of a twentieth of the configured rate.
+	for i := 0; i < 18; i++ {
+		l.speedUp()
+		if l.Limit() >= 4 {
+			t.Fatalf("speedUp: back to %v after %d successes", l.Limit(), i+1)
+		}
+	}
+	l.speedUp()
+	l.speedUp()
+	if got := l.Limit(); got != 4 {
+		t.Errorf("speedUp: got %v, want the configured rate 4", got)
+	}
+
+	unlimited := newAdaptiveLimiter("test", -1, 1)
+	unlimited.slowDown()
+	if got := unlimited.Limit(); got != rate.Inf {
+		t.Errorf("slowDown of an unlimited limiter: got %v, want unlimited", got)
+	}
+}
+
+// throttlingServer answers 429 to the first throttled calls of the files
+// api, and a successful api response to the other requests.
+type throttlingServer struct {
+	mu        sync.Mutex
+	throttled int
+	calls     int
+}
+
+func (s *throttlingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path == APIPathGetFiles {
+		s.calls++
+		if s.throttled > 0 {
+			s.throttled--
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"state":true,"errno":0,"data":{"user_id":"1"}}`))
+}
+
+func newThrottledClient(t *testing.T, s *throttlingServer, opts ...Option) *DriveClient {
+	t.Helper()
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	urls := APIBaseURLs{server.URL, server.URL, server.URL, server.URL, server.URL}
+	opts = append([]Option{WithAPIBaseURLs(urls), WithRateLimits(100, 100, -1, 100)}, opts...)
+	c := MustNew115DriveClient("uid", "cid", "seid", "kid", opts...)
+	c.HttpClient.SetRetryWaitTime(time.Millisecond).SetRetryMaxWaitTime(time.Millisecond)
+	return c
+}
+
+func TestThrottledCallBacksOff(t *testing.T) {
+	s := &throttlingServer{throttled: 2}
+	c := newThrottledClient(t, s)
+
+	if _, err := c.HttpClient.R().Get(c.apiBaseURLs.WebAPI + APIPathGetFiles); err != nil {
+		t.Fatalf("call after 2 throttled attempts: %v", err)
+	}
+	if s.calls != 3 {
+		t.Errorf("call after 2 throttled attempts: got %d calls, want 3", s.calls)
+	}
+	// Halved twice, then sped up by the success.
+	if got := c.apiLimiter.Limit(); got != 30 {
+		t.Errorf("api rate after 2 throttled attempts: got %v, want 30", got)
+	}
+	if got := c.urlLimiter.Limit(); got != 100 {
+		t.Errorf("download url rate after throttled api calls: got %v, want 100", got)
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, err := c.HttpClient.R().Get(c.apiBaseURLs.WebAPI + APIPathGetFiles); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := c.apiLimiter.Limit(); got != 100 {
+		t.Errorf("api rate after 20 successes: got %v, want 100", got)
+	}
+}
+
+func TestThrottledCallRetryCount(t *testing.T) {
+	testCases := []struct {
+		retry int
+		calls int
+	}{
+		{0, defaultRetryCount + 1},
+		{1, 2},
+		{5, 6},
+		{-1, 1},
+	}
+	for _, tc := range testCases {
+		s := &throttlingServer{throttled: 10}
+		c := newThrottledClient(t, s, WithRetry(tc.retry))
+		if _, err := c.HttpClient.R().Get(c.apiBaseURLs.WebAPI + APIPathGetFiles); err == nil {
+			t.Errorf("WithRetry(%d): got no error", tc.retry)
+		}
+		if s.calls != tc.calls {
+			t.Errorf("WithRetry(%d): got %d calls, want %d", tc.retry, s.calls, tc.calls)
+		}
+	}
+}
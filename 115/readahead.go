@@ -0,0 +1,132 @@
+package _115
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common/drive"
+)
+
+const (
+	// readStreamIdleTimeout is how long the read ahead of a stream is kept
+	// after a request, waiting for the client to continue reading.
+	readStreamIdleTimeout = 30 * time.Second
+)
+
+// readStream is a client reading a file across requests. Its chunks outlive
+// a single request, so the data read ahead while serving one request serves
+// the next one.
+type readStream struct {
+	key    string
+	stream *chunkStream
+	cancel context.CancelFunc
+
+	// next is the offset following the last byte served, and busy reports
+	// whether a request is being served. Both are guarded by the registry.
+	next int64
+	busy bool
+	gen  int
+}
+
+// readStreamRegistry keeps the read streams of clients between requests.
+type readStreamRegistry struct {
+	mu      sync.Mutex
+	streams map[string][]*readStream
+}
+
+func newReadStreamRegistry() *readStreamRegistry {
+	return &readStreamRegistry{
+		streams: make(map[string][]*readStream),
+	}
+}
+
+// readStreamKey identifies the streams of a client reading fi.
+func readStreamKey(req *http.Request, fi drive.File) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	user, _, _ := req.BasicAuth()
+	return fi.(*FileInfo).PickCode + "|" + user + "|" + host + "|" + req.UserAgent()
+}
+
+// acquire returns the stream of key to serve a request starting at offset
+// start. An idle stream that stopped right before start is continued, and
+// sequential is true. Otherwise the client seeked: the read ahead of its
+// idle streams is cancelled and a new stream is started with newStream.
+func (reg *readStreamRegistry) acquire(key string, start int64, newStream func(ctx context.Context) *chunkStream) (rs *readStream, sequential bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	streams := reg.streams[key]
+	for _, rs := range streams {
+		if !rs.busy && rs.next == start {
+			rs.busy = true
+			rs.gen++
+			return rs, true
+		}
+	}
+
+	kept := streams[:0]
+	for _, rs := range streams {
+		if rs.busy {
+			kept = append(kept, rs)
+		} else {
+			rs.cancel()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rs = &readStream{
+		key:    key,
+		stream: newStream(ctx),
+		cancel: cancel,
+		busy:   true,
+	}
+	reg.streams[key] = append(kept, rs)
+	return rs, false
+}
+
+// release ends a request served from rs, which stopped before offset next.
+// When the request completed the stream is kept for a while for the client
+// to continue, otherwise the client went away and its read ahead is
+// cancelled.
+func (reg *readStreamRegistry) release(rs *readStream, next int64, completed bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	rs.busy = false
+	if !completed {
+		reg.remove(rs)
+		return
+	}
+	rs.next = next
+	gen := rs.gen
+	time.AfterFunc(readStreamIdleTimeout, func() {
+		reg.mu.Lock()
+		defer reg.mu.Unlock()
+		if !rs.busy && rs.gen == gen {
+			reg.remove(rs)
+		}
+	})
+}
+
+// remove cancels rs and drops it. The caller must hold reg.mu.
+func (reg *readStreamRegistry) remove(rs *readStream) {
+	rs.cancel()
+	streams := reg.streams[rs.key]
+	for i := range streams {
+		if streams[i] == rs {
+			streams = append(streams[:i], streams[i+1:]...)
+			break
+		}
+	}
+	if len(streams) == 0 {
+		delete(reg.streams, rs.key)
+	} else {
+		reg.streams[rs.key] = streams
+	}
+}
@@ -0,0 +1,88 @@
+package _115_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	_115 "github.com/gaoyb7/115drive-webdav/115"
+	"github.com/gaoyb7/115drive-webdav/115/fake115"
+)
+
+// waitCalls waits for the calls of apiPath to reach want, and returns them.
+func waitCalls(s *fake115.Server, apiPath string, want int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Calls(apiPath) < want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return s.Calls(apiPath)
+}
+
+func TestReadAhead(t *testing.T) {
+	s, c := newTestClient(t, _115.WithParallelRange(1, 4), _115.WithReadAhead(8))
+	s.WriteFile("/a/f.txt", []byte(alphabet))
+	fi, err := c.GetFile(context.Background(), "/a/f.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	// The first request of a client only fetches what it asks for.
+	if status, _, body := getRange(c, fi, "bytes=0-3"); status != http.StatusPartialContent || body != "abcd" {
+		t.Fatalf("ServeContent: got %d %q, want 206 %q", status, body, "abcd")
+	}
+	if calls := s.Calls(fake115.PathContent); calls != 1 {
+		t.Errorf("ServeContent of the first range: got %d content calls, want 1", calls)
+	}
+
+	// Reading on from where it stopped, it fetches 8 bytes ahead: the
+	// chunks 2 and 3, which serve its next request.
+	if status, _, body := getRange(c, fi, "bytes=4-7"); status != http.StatusPartialContent || body != "efgh" {
+		t.Fatalf("ServeContent: got %d %q, want 206 %q", status, body, "efgh")
+	}
+	if calls := waitCalls(s, fake115.PathContent, 4); calls != 4 {
+		t.Fatalf("ServeContent of the next range: got %d content calls, want 4", calls)
+	}
+	if status, _, body := getRange(c, fi, "bytes=8-15"); status != http.StatusPartialContent || body != "ijklmnop" {
+		t.Errorf("ServeContent of the read ahead: got %d %q, want 206 %q", status, body, "ijklmnop")
+	}
+	// Only the chunks 4 and 5, ahead of the request, were fetched for it.
+	if calls := waitCalls(s, fake115.PathContent, 6); calls != 6 {
+		t.Errorf("ServeContent of the read ahead: got %d content calls, want 6", calls)
+	}
+
+	// A client that seeks starts over, with what it asks for only.
+	if status, _, body := getRange(c, fi, "bytes=1-2"); status != http.StatusPartialContent || body != "bc" {
+		t.Errorf("ServeContent after a seek: got %d %q, want 206 %q", status, body, "bc")
+	}
+	if calls := s.Calls(fake115.PathContent); calls != 7 {
+		t.Errorf("ServeContent after a seek: got %d content calls, want 7", calls)
+	}
+}
+
+func TestReadAheadOfAnotherClient(t *testing.T) {
+	s, c := newTestClient(t, _115.WithParallelRange(1, 4), _115.WithReadAhead(8))
+	s.WriteFile("/a/f.txt", []byte(alphabet))
+	fi, err := c.GetFile(context.Background(), "/a/f.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	for i, user := range []string{"alice", "bob"} {
+		req := httptest.NewRequest(http.MethodGet, "/a/f.txt", nil)
+		req.SetBasicAuth(user, "secret")
+		req.Header.Set("Range", []string{"bytes=0-3", "bytes=4-7"}[i])
+		w := httptest.NewRecorder()
+		c.ServeContent(w, req, fi)
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("ServeContent as %s: got %d, want 206", user, w.Code)
+		}
+	}
+	// The range before the second request was read by another user, so
+	// nothing is read ahead.
+	time.Sleep(50 * time.Millisecond)
+	if calls := s.Calls(fake115.PathContent); calls != 2 {
+		t.Errorf("ServeContent of two clients: got %d content calls, want 2", calls)
+	}
+}
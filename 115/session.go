@@ -0,0 +1,227 @@
+package _115
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cookieReloadInterval is how often MonitorSession looks for new cookies.
+	cookieReloadInterval = 10 * time.Second
+)
+
+// SessionState is the result of the latest login check of a DriveClient.
+type SessionState struct {
+	LoggedIn  bool      `json:"logged_in"`
+	UserID    int64     `json:"user_id"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// sessionJar is the cookie jar of the 115 API client. It sends the session
+// cookies to every 115 host and to the api servers set in its place, and
+// the cookies can be replaced while requests are running. Cookies set by
+// responses are kept as in an ordinary jar.
+type sessionJar struct {
+	http.CookieJar
+
+	mu      sync.RWMutex
+	cookies Cookies
+	// hosts are the hosts, with their ports, of the api servers set with
+	// WithAPIBaseURLs.
+	hosts map[string]bool
+}
+
+func newSessionJar(cookies Cookies) *sessionJar {
+	jar, _ := cookiejar.New(nil)
+	return &sessionJar{
+		CookieJar: jar,
+		cookies:   cookies,
+		hosts:     make(map[string]bool),
+	}
+}
+
+// withCookies returns a new jar of cookies, sending them to the hosts of j.
+func (j *sessionJar) withCookies(cookies Cookies) *sessionJar {
+	jar := newSessionJar(cookies)
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	for host := range j.hosts {
+		jar.hosts[host] = true
+	}
+	return jar
+}
+
+func (j *sessionJar) addHost(host string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.hosts[host] = true
+}
+
+func (j *sessionJar) Cookies(u *url.URL) []*http.Cookie {
+	cookies := j.CookieJar.Cookies(u)
+	j.mu.RLock()
+	apiHost := j.hosts[u.Host]
+	j.mu.RUnlock()
+	if !is115Host(u.Hostname()) && !apiHost {
+		return cookies
+	}
+
+	session := j.get()
+	result := make([]*http.Cookie, 0, len(cookies)+4)
+	names := make(map[string]bool)
+	for _, c := range []*http.Cookie{
+		{Name: "UID", Value: session.UID},
+		{Name: "CID", Value: session.CID},
+		{Name: "SEID", Value: session.SEID},
+		{Name: "KID", Value: session.KID},
+	} {
+		if len(c.Value) > 0 {
+			result = append(result, c)
+			names[c.Name] = true
+		}
+	}
+	for _, c := range cookies {
+		if !names[c.Name] {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func (j *sessionJar) get() Cookies {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.cookies
+}
+
+func (j *sessionJar) set(cookies Cookies) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cookies = cookies
+}
+
+// Session returns the state of the 115 session as of the latest check.
+func (c *DriveClient) Session() SessionState {
+	c.sessionMu.RLock()
+	defer c.sessionMu.RUnlock()
+	return c.session
+}
+
+// CheckSession runs a login check and records its result as the session
+// state. A session that stops being logged in is logged as an error.
+func (c *DriveClient) CheckSession() SessionState {
+	state := SessionState{CheckedAt: time.Now()}
+	userID, err := APILoginCheck(context.Background(), c.HttpClient, c.apiBaseURLs.PassportAPI)
+	if err != nil {
+		state.Error = err.Error()
+	} else if userID <= 0 {
+		state.Error = "login expired"
+	} else {
+		state.LoggedIn = true
+		state.UserID = userID
+	}
+
+	c.sessionMu.Lock()
+	prev := c.session
+	c.session = state
+	c.sessionMu.Unlock()
+
+	if state.LoggedIn && c.metaStore != nil {
+		c.claimMetaStore(state.UserID)
+	}
+	if state.LoggedIn && !prev.LoggedIn {
+		logrus.Infof("115 drive login succ, user_id: %d", state.UserID)
+	} else if !state.LoggedIn && (prev.LoggedIn || prev.CheckedAt.IsZero()) {
+		logrus.Errorf("115 drive session is not logged in, update the cookies, err: %s", state.Error)
+	}
+	return state
+}
+
+// claimMetaStore drops the metadata store when it holds the files of another
+// account than userID, such as after the cookies of the config are replaced
+// with those of another account.
+func (c *DriveClient) claimMetaStore(userID int64) {
+	var owner int64
+	if _, ok := c.metaStore.Get("user_id", &owner); ok && owner == userID {
+		return
+	}
+	if owner != 0 {
+		logrus.Infof("metadata store belongs to user_id %d, drop it for user_id %d", owner, userID)
+	}
+	c.metaStore.DeletePrefix("")
+	if err := c.metaStore.Put("user_id", userID); err != nil {
+		logrus.WithError(err).Errorf("call c.metaStore.Put fail, key: user_id")
+	}
+}
+
+// SetCookies replaces the session cookies of the client without a restart.
+// The cookies are checked first, and left unused if they are not logged in.
+// Cached listings and download urls are dropped, as the cookies may belong
+// to another account, along with the index of the dirs and the position in
+// the file event log, which are then those of the metadata store, empty for
+// another account.
+func (c *DriveClient) SetCookies(cookies Cookies) error {
+	checkClient := resty.New().
+		SetTransport(c.HttpClient.GetClient().Transport).
+		SetCookieJar(c.jar.withCookies(cookies)).
+		SetHeader("User-Agent", UserAgent)
+	userID, err := APILoginCheck(context.Background(), checkClient, c.apiBaseURLs.PassportAPI)
+	if err != nil {
+		return err
+	}
+	if userID <= 0 {
+		return fmt.Errorf("set cookies fail, cookies are not logged in")
+	}
+
+	c.jar.set(cookies)
+	c.cache.Purge()
+	logrus.Infof("115 drive cookies updated, user_id: %d", userID)
+	c.CheckSession()
+	c.index.reset()
+	c.loadIndex()
+	c.changes.reset(c.metaStore)
+	return nil
+}
+
+// MonitorSession checks the session every interval. If reload is not nil,
+// it is polled every cookieReloadInterval, and the cookies it returns replace
+// the current ones when they differ, which lets new cookies be picked up from
+// a file.
+func (c *DriveClient) MonitorSession(interval time.Duration, reload func() (*Cookies, error)) {
+	checkTicker := time.NewTicker(interval)
+	defer checkTicker.Stop()
+	var reloadC <-chan time.Time
+	if reload != nil {
+		reloadTicker := time.NewTicker(cookieReloadInterval)
+		defer reloadTicker.Stop()
+		reloadC = reloadTicker.C
+	}
+
+	for {
+		select {
+		case <-checkTicker.C:
+			c.CheckSession()
+		case <-reloadC:
+			cookies, err := reload()
+			if err != nil {
+				logrus.WithError(err).Warnf("reload 115 cookies fail")
+				continue
+			}
+			if cookies == nil || *cookies == c.jar.get() {
+				continue
+			}
+			if err := c.SetCookies(*cookies); err != nil {
+				logrus.WithError(err).Errorf("call c.SetCookies fail")
+			}
+		}
+	}
+}
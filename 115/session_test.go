@@ -0,0 +1,31 @@
+package _115_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gaoyb7/115drive-webdav/115/fake115"
+)
+
+func TestSetCookiesOfAnotherAccount(t *testing.T) {
+	s, c := newTestClient(t)
+	s.WriteFile("/a/b/f.txt", []byte("f"))
+	ctx := context.Background()
+
+	if _, err := c.GetFiles(ctx, "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	s.UserID = 43
+	if err := c.SetCookies(s.Cookies()); err != nil {
+		t.Fatalf("SetCookies: %v", err)
+	}
+	// The ids of the dirs of the other account are forgotten, and looked
+	// up again.
+	calls := s.Calls(fake115.PathDirID)
+	if _, err := c.GetFiles(ctx, "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Calls(fake115.PathDirID) - calls; got != 1 {
+		t.Errorf("GetFiles after SetCookies: got %d dir id calls, want 1", got)
+	}
+}
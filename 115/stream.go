@@ -0,0 +1,343 @@
+package _115
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gaoyb7/115drive-webdav/common/drive"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errMultiRange         = errors.New("multiple ranges")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// byteRange is a range of file content, both ends inclusive.
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// parseRange parses a Range header against content of size bytes. partial
+// reports whether the header selects a range at all. Only single ranges are
+// supported, errMultiRange is returned for a list of ranges.
+func parseRange(s string, size int64) (r byteRange, partial bool, err error) {
+	if len(s) == 0 {
+		return byteRange{start: 0, end: size - 1}, false, nil
+	}
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return byteRange{}, false, errUnsatisfiableRange
+	}
+	spec := strings.TrimSpace(s[len(b):])
+	if strings.Contains(spec, ",") {
+		return byteRange{}, false, errMultiRange
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return byteRange{}, false, errUnsatisfiableRange
+	}
+	startStr, endStr := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if len(startStr) == 0 {
+		// "-n" selects the final n bytes.
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return byteRange{}, false, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return byteRange{start: size - n, end: size - 1}, true, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return byteRange{}, false, errUnsatisfiableRange
+	}
+	end := size - 1
+	if len(endStr) > 0 {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, errUnsatisfiableRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return byteRange{start: start, end: end}, true, nil
+}
+
+// chunkFuture is the result of a chunk fetch that may still be in flight.
+type chunkFuture struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// chunkStream fetches the content of a file as chunks of chunkSize bytes,
+// aligned to chunkSize, with at most cap(sem) fetches running at once. The
+// fetches are bound to ctx, and chunks are kept until released.
+type chunkStream struct {
+	ctx       context.Context
+	size      int64
+	chunkSize int64
+	fetch     func(ctx context.Context, start int64, end int64) ([]byte, error)
+	sem       chan struct{}
+
+	mu     sync.Mutex
+	chunks map[int64]*chunkFuture
+}
+
+func newChunkStream(ctx context.Context, size int64, chunkSize int64, connections int, fetch func(ctx context.Context, start int64, end int64) ([]byte, error)) *chunkStream {
+	return &chunkStream{
+		ctx:       ctx,
+		size:      size,
+		chunkSize: chunkSize,
+		fetch:     fetch,
+		sem:       make(chan struct{}, connections),
+		chunks:    make(map[int64]*chunkFuture),
+	}
+}
+
+// prefetch starts fetching chunk idx in the background, unless it is already
+// being fetched.
+func (s *chunkStream) prefetch(idx int64) *chunkFuture {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.chunks[idx]; ok {
+		return f
+	}
+
+	f := &chunkFuture{done: make(chan struct{})}
+	s.chunks[idx] = f
+	go func() {
+		defer close(f.done)
+		select {
+		case s.sem <- struct{}{}:
+		case <-s.ctx.Done():
+			f.err = s.ctx.Err()
+			return
+		}
+		defer func() { <-s.sem }()
+
+		start := idx * s.chunkSize
+		end := start + s.chunkSize - 1
+		if end >= s.size {
+			end = s.size - 1
+		}
+		f.data, f.err = s.fetch(s.ctx, start, end)
+	}()
+	return f
+}
+
+// get waits for chunk idx, or until ctx or the stream is done.
+func (s *chunkStream) get(ctx context.Context, idx int64) ([]byte, error) {
+	f := s.prefetch(idx)
+	select {
+	case <-f.done:
+		return f.data, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+// release drops the chunks before idx from the stream.
+func (s *chunkStream) release(idx int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.chunks {
+		if i < idx {
+			delete(s.chunks, i)
+		}
+	}
+}
+
+// serveChunks serves the requested range of fi by splitting it into chunks
+// that are read from the block cache, or fetched from the CDN over several
+// concurrent connections, and written to w in order. With read ahead, the
+// chunks are fetched by the read stream of the client, which may already
+// hold them from its previous request.
+func (c *DriveClient) serveChunks(w http.ResponseWriter, req *http.Request, fi drive.File) {
+	size := fi.GetSize()
+	r, partial, err := parseRange(req.Header.Get("Range"), size)
+	if err == errMultiRange {
+		c.proxyContent(w, req, fi)
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	chunkSize, connections := c.chunkSize, c.connections
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	if connections < 1 {
+		connections = 1
+	}
+	if c.blockCache != nil {
+		chunkSize = c.blockCache.BlockSize()
+	}
+	logrus.Infof("chunked open [name: %v] [range: %v]", fi.GetName(), req.Header.Get("Range"))
+
+	// Download urls are cached, and only requested once a chunk is missing
+	// from the block cache, so reads served from the cache need no API call.
+	fetch := func(ctx context.Context, start int64, end int64) ([]byte, error) {
+		fileURL, err := c.GetFileURL(ctx, fi)
+		if err != nil {
+			logrus.WithError(err).Errorf("call c.GetFileURL fail, name: %s", fi.GetName())
+			return nil, err
+		}
+		return c.fetchRange(ctx, fileURL, start, end)
+	}
+	if c.blockCache != nil {
+		fetch = c.cachedFetch(fi, chunkSize, fetch)
+	}
+	newStream := func(ctx context.Context) *chunkStream {
+		return newChunkStream(ctx, size, chunkSize, connections, fetch)
+	}
+
+	first, last := r.start/chunkSize, r.end/chunkSize
+	aheadChunks, aheadLast := int64(connections-1), last
+	var stream *chunkStream
+	completed := false
+	if c.readStreams != nil {
+		// Keep fetching past the requested range while the client reads
+		// sequentially, so that its next request finds its data ready.
+		rs, sequential := c.readStreams.acquire(readStreamKey(req, fi), r.start, newStream)
+		defer func() { c.readStreams.release(rs, r.end+1, completed) }()
+		stream = rs.stream
+		if sequential || r.end == size-1 {
+			if n := (c.readAhead + chunkSize - 1) / chunkSize; n > aheadChunks {
+				aheadChunks = n
+			}
+			aheadLast = (size - 1) / chunkSize
+		}
+	} else {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		stream = newStream(ctx)
+	}
+
+	for idx := first; idx <= last; idx++ {
+		for ahead := idx + 1; ahead <= idx+aheadChunks && ahead <= aheadLast; ahead++ {
+			stream.prefetch(ahead)
+		}
+		data, err := stream.get(req.Context(), idx)
+		if err != nil {
+			logrus.WithError(err).Warnf("chunked fetch fail, name: %s, chunk: %d", fi.GetName(), idx)
+			if idx == first {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+			return
+		}
+
+		if idx == first {
+			h := w.Header()
+			ctype := mime.TypeByExtension(path.Ext(fi.GetName()))
+			if len(ctype) == 0 {
+				ctype = "application/octet-stream"
+			}
+			h.Set("Content-Type", ctype)
+			h.Set("Accept-Ranges", "bytes")
+			h.Set("Last-Modified", fi.GetUpdateTime().Format(http.TimeFormat))
+			h.Set("Content-Length", strconv.FormatInt(r.end-r.start+1, 10))
+			if partial {
+				h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size))
+				w.WriteHeader(http.StatusPartialContent)
+			} else {
+				w.WriteHeader(http.StatusOK)
+			}
+		}
+
+		chunkStart := idx * chunkSize
+		from, to := int64(0), int64(len(data))
+		if r.start > chunkStart {
+			from = r.start - chunkStart
+		}
+		if r.end+1-chunkStart < to {
+			to = r.end + 1 - chunkStart
+		}
+		if _, err := w.Write(data[from:to]); err != nil {
+			logrus.WithError(err).Warnf("chunked write fail, name: %s", fi.GetName())
+			return
+		}
+		stream.release(idx)
+	}
+	completed = true
+}
+
+// cachedFetch wraps fetch so that chunks of fi, which must be aligned to
+// blocks of chunkSize bytes, are read from the block cache when present and
+// stored in it after being fetched.
+func (c *DriveClient) cachedFetch(fi drive.File, chunkSize int64, fetch func(ctx context.Context, start int64, end int64) ([]byte, error)) func(ctx context.Context, start int64, end int64) ([]byte, error) {
+	key := blockCacheKey(fi)
+	return func(ctx context.Context, start int64, end int64) ([]byte, error) {
+		idx := start / chunkSize
+		if data, ok := c.blockCache.Get(key, idx); ok && int64(len(data)) == end-start+1 {
+			return data, nil
+		}
+
+		data, err := fetch(ctx, start, end)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.blockCache.Put(key, idx, data); err != nil {
+			logrus.WithError(err).Warnf("call c.blockCache.Put fail, name: %s, block: %d", fi.GetName(), idx)
+		}
+		return data, nil
+	}
+}
+
+// blockCacheKey identifies the content of fi in the block cache. Files with
+// the same SHA1 share their blocks.
+func blockCacheKey(fi drive.File) string {
+	info := fi.(*FileInfo)
+	if len(info.Sha1) > 0 {
+		return "sha1:" + strings.ToUpper(info.Sha1)
+	}
+	return "pc:" + info.PickCode
+}
+
+// fetchRange fetches the bytes start to end, inclusive, of the content at
+// fileURL.
+func (c *DriveClient) fetchRange(ctx context.Context, fileURL string, start int64, end int64) ([]byte, error) {
+	if err := c.contentLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	req.Header.Set("Referer", "https://115.com/")
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := c.reserveProxy.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("fetch range fail, http status: %d", resp.StatusCode)
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
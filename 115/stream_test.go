@@ -0,0 +1,142 @@
+package _115_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	_115 "github.com/gaoyb7/115drive-webdav/115"
+	"github.com/gaoyb7/115drive-webdav/115/fake115"
+	"github.com/gaoyb7/115drive-webdav/common/blockcache"
+	"github.com/gaoyb7/115drive-webdav/common/drive"
+)
+
+const alphabet = "abcdefghijklmnopqrstuvwxyz"
+
+// getRange serves rangeHeader of fi with c, and returns the status, the
+// Content-Range and the body of the response.
+func getRange(c *_115.DriveClient, fi drive.File, rangeHeader string) (int, string, string) {
+	req := httptest.NewRequest(http.MethodGet, "/a/"+fi.GetName(), nil)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	w := httptest.NewRecorder()
+	c.ServeContent(w, req, fi)
+	body, _ := ioutil.ReadAll(w.Result().Body)
+	return w.Code, w.Header().Get("Content-Range"), string(body)
+}
+
+func TestServeChunks(t *testing.T) {
+	s, c := newTestClient(t, _115.WithParallelRange(3, 4))
+	s.WriteFile("/a/f.txt", []byte(alphabet))
+	fi, err := c.GetFile(context.Background(), "/a/f.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	testCases := []struct {
+		rangeHeader  string
+		status       int
+		contentRange string
+		body         string
+		chunks       int
+	}{
+		{"", http.StatusOK, "", alphabet, 7},
+		{"bytes=0-3", http.StatusPartialContent, "bytes 0-3/26", "abcd", 1},
+		{"bytes=5-13", http.StatusPartialContent, "bytes 5-13/26", "fghijklmn", 3},
+		{"bytes=-5", http.StatusPartialContent, "bytes 21-25/26", "vwxyz", 2},
+		{"bytes=24-100", http.StatusPartialContent, "bytes 24-25/26", "yz", 1},
+		{"bytes=26-", http.StatusRequestedRangeNotSatisfiable, "bytes */26", "", 0},
+		{"bytes=3-1", http.StatusRequestedRangeNotSatisfiable, "bytes */26", "", 0},
+	}
+	for _, tc := range testCases {
+		calls := s.Calls(fake115.PathContent)
+		status, contentRange, body := getRange(c, fi, tc.rangeHeader)
+		if status != tc.status || contentRange != tc.contentRange || body != tc.body {
+			t.Errorf("ServeContent of %q: got %d %q %q, want %d %q %q", tc.rangeHeader, status, contentRange, body, tc.status, tc.contentRange, tc.body)
+		}
+		if got := s.Calls(fake115.PathContent) - calls; got != tc.chunks {
+			t.Errorf("ServeContent of %q: got %d content calls, want %d", tc.rangeHeader, got, tc.chunks)
+		}
+	}
+
+	// A list of ranges is proxied as is.
+	status, _, body := getRange(c, fi, "bytes=0-1,4-5")
+	if status != http.StatusPartialContent || !bytes.Contains([]byte(body), []byte("ab")) || !bytes.Contains([]byte(body), []byte("ef")) {
+		t.Errorf("ServeContent of a list of ranges: got %d %q, want a multipart response", status, body)
+	}
+}
+
+func TestServeChunksFetchFailure(t *testing.T) {
+	s, c := newTestClient(t, _115.WithParallelRange(3, 4))
+	s.WriteFile("/a/f.txt", []byte(alphabet))
+	fi, err := c.GetFile(context.Background(), "/a/f.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	s.Fail(fake115.PathDownloadURL, fake115.Failure{ErrNo: 1, Error: "获取失败"})
+	if status, _, _ := getRange(c, fi, "bytes=0-3"); status != http.StatusBadGateway {
+		t.Errorf("ServeContent with a failing download url: got %d, want 502", status)
+	}
+	if status, _, body := getRange(c, fi, "bytes=0-9"); status != http.StatusPartialContent || body != alphabet[:10] {
+		t.Errorf("ServeContent after the failure: got %d %q, want 206 %q", status, body, alphabet[:10])
+	}
+}
+
+func TestServeChunksBlockCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "115-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	cache, err := blockcache.New(dir, 4, 1024)
+	if err != nil {
+		t.Fatalf("blockcache.New: %v", err)
+	}
+	s, c := newTestClient(t, _115.WithBlockCache(cache))
+	s.WriteFile("/a/f.txt", []byte(alphabet))
+	s.WriteFile("/a/same.txt", []byte(alphabet))
+	ctx := context.Background()
+	fi, err := c.GetFile(ctx, "/a/f.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	same, err := c.GetFile(ctx, "/a/same.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	if status, _, body := getRange(c, fi, "bytes=2-9"); status != http.StatusPartialContent || body != "cdefghij" {
+		t.Fatalf("ServeContent: got %d %q, want 206 %q", status, body, "cdefghij")
+	}
+	contentCalls, urlCalls := s.Calls(fake115.PathContent), s.Calls(fake115.PathDownloadURL)
+	if contentCalls != 3 {
+		t.Errorf("ServeContent: got %d content calls, want 3", contentCalls)
+	}
+
+	// The cached blocks serve the file and the files of the same content,
+	// without asking for a download url.
+	for _, f := range []drive.File{fi, same} {
+		if status, _, body := getRange(c, f, "bytes=1-7"); status != http.StatusPartialContent || body != "bcdefgh" {
+			t.Errorf("ServeContent of %s from the cache: got %d %q, want 206 %q", f.GetName(), status, body, "bcdefgh")
+		}
+	}
+	if got := s.Calls(fake115.PathContent) - contentCalls; got != 0 {
+		t.Errorf("ServeContent from the cache: got %d content calls, want 0", got)
+	}
+	if got := s.Calls(fake115.PathDownloadURL) - urlCalls; got != 0 {
+		t.Errorf("ServeContent from the cache: got %d download url calls, want 0", got)
+	}
+
+	if status, _, body := getRange(c, fi, ""); status != http.StatusOK || body != alphabet {
+		t.Errorf("ServeContent of the whole file: got %d %q, want 200 %q", status, body, alphabet)
+	}
+	if got := s.Calls(fake115.PathContent) - contentCalls; got != 4 {
+		t.Errorf("ServeContent of the whole file: got %d content calls, want the 4 missing blocks", got)
+	}
+}
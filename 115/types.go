@@ -51,6 +51,13 @@ type APIGetFileInfoResp struct {
 	Data    []FileInfo  `json:"data"`
 }
 
+// PathItem is a dir of the path field of a listing, which holds the dirs
+// from the root down to the listed dir.
+type PathItem struct {
+	CategoryID json.Number `json:"cid"`
+	Name       string      `json:"name"`
+}
+
 type APIGetFilesResp struct {
 	AreaID     string      `json:"aid"`
 	CategoryID json.Number `json:"cid"`
@@ -58,7 +65,7 @@ type APIGetFilesResp struct {
 	Cur        int64       `json:"cur"`
 	Data       []FileInfo  `json:"data"`
 	DataSource string      `json:"data_source"`
-	ErrNo      int64       `json:"errNo"`
+	ErrNo      json.Number `json:"errNo"`
 	Error      string      `json:"error"`
 	Limit      int64       `json:"limit"`
 	MaxSize    int64       `json:"max_size"`
@@ -66,7 +73,7 @@ type APIGetFilesResp struct {
 	Offset     int64       `json:"offset"`
 	Order      string      `json:"order"`
 	PageSize   int64       `json:"page_size"`
-	Path       []FileInfo  `json:"path"`
+	Path       []PathItem  `json:"path"`
 	State      bool        `json:"state"`
 	Suffix     string      `json:"suffix"`
 }
@@ -80,33 +87,34 @@ type APIGetDirIDResp struct {
 }
 
 type APIDeleteFileResp struct {
-	// ErrNo json.Number `json:"errno"`
-	Error string `json:"error"`
-	State bool   `json:"state"`
+	ErrNo json.Number `json:"errno"`
+	Error string      `json:"error"`
+	State bool        `json:"state"`
 }
 
 type APIAddDirResp struct {
-	// ErrNo json.Number `json:"errno"`
-	Error string `json:"error"`
-	State bool   `json:"state"`
+	ErrNo      json.Number `json:"errno"`
+	Error      string      `json:"error"`
+	State      bool        `json:"state"`
+	CategoryID json.Number `json:"cid"`
 }
 
 type APICopyFileResp struct {
-	// ErrNo json.Number `json:"errno"`
-	Error string `json:"error"`
-	State bool   `json:"state"`
+	ErrNo json.Number `json:"errno"`
+	Error string      `json:"error"`
+	State bool        `json:"state"`
 }
 
 type APIMoveFileResp struct {
-	// ErrNo json.Number `json:"errno"`
-	Error string `json:"error"`
-	State bool   `json:"state"`
+	ErrNo json.Number `json:"errno"`
+	Error string      `json:"error"`
+	State bool        `json:"state"`
 }
 
 type APIRenameFileResp struct {
-	// ErrNo json.Number `json:"errno"`
-	Error string `json:"error"`
-	State bool   `json:"state"`
+	ErrNo json.Number `json:"errno"`
+	Error string      `json:"error"`
+	State bool        `json:"state"`
 }
 
 type APILoginCheckResp struct {
@@ -119,6 +127,132 @@ type APILoginCheckResp struct {
 	} `json:"data"`
 }
 
+type APIGetUploadInfoResp struct {
+	State   bool        `json:"state"`
+	ErrNo   json.Number `json:"errno"`
+	Error   string      `json:"error"`
+	UserID  json.Number `json:"user_id"`
+	UserKey string      `json:"userkey"`
+}
+
+type APIInitUploadResp struct {
+	Request    string `json:"request"`
+	Status     int    `json:"status"`
+	StatusCode int    `json:"statuscode"`
+	StatusMsg  string `json:"statusmsg"`
+	PickCode   string `json:"pickcode"`
+	Target     string `json:"target"`
+	Bucket     string `json:"bucket"`
+	Object     string `json:"object"`
+	Callback   struct {
+		Callback    string `json:"callback"`
+		CallbackVar string `json:"callback_var"`
+	} `json:"callback"`
+	SignKey   string `json:"sign_key"`
+	SignCheck string `json:"sign_check"`
+}
+
+type APIGetUploadTokenResp struct {
+	StatusCode      string `json:"StatusCode"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+type OSSCallbackResp struct {
+	State   bool   `json:"state"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		FileID   string `json:"file_id"`
+		FileName string `json:"file_name"`
+		PickCode string `json:"pick_code"`
+	} `json:"data"`
+}
+
+type APIGetQRCodeTokenResp struct {
+	State   int    `json:"state"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		UID    string      `json:"uid"`
+		Time   json.Number `json:"time"`
+		Sign   string      `json:"sign"`
+		QRCode string      `json:"qrcode"`
+	} `json:"data"`
+}
+
+type APIGetQRCodeStatusResp struct {
+	State   int    `json:"state"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Msg     string `json:"msg"`
+		Status  int    `json:"status"`
+		Version string `json:"version"`
+	} `json:"data"`
+}
+
+type APIQRCodeLoginResp struct {
+	State   int    `json:"state"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+	ErrNo   int    `json:"errno"`
+	Data    struct {
+		UserID   json.Number `json:"user_id"`
+		UserName string      `json:"user_name"`
+		Cookie   Cookies     `json:"cookie"`
+	} `json:"data"`
+}
+
+// LifeEventItem is a file or dir touched by a LifeEvent. FileID is the id of
+// the file, or of the dir for dirs, and FileCategory is 0 for dirs.
+type LifeEventItem struct {
+	FileID       json.Number `json:"file_id"`
+	ParentID     json.Number `json:"parent_id"`
+	FileName     string      `json:"file_name"`
+	FileCategory json.Number `json:"file_category"`
+}
+
+// LifeEvent is an entry of the file event log of an account, a single
+// operation on one or more items. Items may hold only the first few of the
+// Total items of large operations.
+type LifeEvent struct {
+	BehaviorType string          `json:"behavior_type"`
+	UpdateTime   json.Number     `json:"update_time"`
+	Total        json.Number     `json:"total"`
+	Items        []LifeEventItem `json:"items"`
+}
+
+type APIGetLifeListResp struct {
+	State bool        `json:"state"`
+	ErrNo json.Number `json:"errno"`
+	Error string      `json:"error"`
+	Data  struct {
+		Count json.Number `json:"count"`
+		List  []LifeEvent `json:"list"`
+	} `json:"data"`
+}
+
+// Cookies are the session cookies of a 115 login.
+type Cookies struct {
+	UID  string `json:"UID"`
+	CID  string `json:"CID"`
+	SEID string `json:"SEID"`
+	KID  string `json:"KID"`
+}
+
+// GetID returns the id of the dir or file prefixed with "d" or "f", as the
+// ids of dirs and files are not guaranteed to be distinct.
+func (f *FileInfo) GetID() string {
+	if f.IsDir() {
+		return "d" + f.CategoryID.String()
+	}
+	return "f" + f.FileID.String()
+}
+
 func (f *FileInfo) GetName() string {
 	return f.Name
 }
@@ -142,3 +276,8 @@ func (f *FileInfo) IsDir() bool {
 	fid, _ := f.FileID.Int64()
 	return fid == 0
 }
+
+// IsDir reports whether the item is a dir, whose file category is 0.
+func (i *LifeEventItem) IsDir() bool {
+	return i.FileCategory.String() == "0"
+}
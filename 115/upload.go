@@ -0,0 +1,275 @@
+package _115
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// uploadMemSpoolSize is the largest body kept in memory while uploading,
+	// bigger or unsized bodies are spooled to a temp file.
+	uploadMemSpoolSize = 4 * 1024 * 1024
+
+	// uploadPreHashSize is the length of the file head hashed into the
+	// preid of the upload init call.
+	uploadPreHashSize = 128 * 1024
+	// uploadMaxSignChecks bounds the sign check rounds of a fast upload.
+	uploadMaxSignChecks = 3
+
+	uploadStatusNeedUpload = 1
+	uploadStatusDone       = 2
+	uploadStatusSignCheck  = 7
+)
+
+// spooledBody is a fully received upload body, which 115 needs before the
+// transfer starts because the upload init call is signed with its SHA1.
+type spooledBody struct {
+	io.ReaderAt
+	file *os.File
+	size int64
+	sha1 string
+}
+
+func spoolBody(r io.Reader, size int64) (*spooledBody, error) {
+	h := sha1.New()
+	if size >= 0 && size <= uploadMemSpoolSize {
+		data, err := ioutil.ReadAll(io.TeeReader(r, h))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) != size {
+			return nil, fmt.Errorf("spool body fail, expect %d bytes, got %d", size, len(data))
+		}
+		return &spooledBody{
+			ReaderAt: bytes.NewReader(data),
+			size:     size,
+			sha1:     strings.ToUpper(hex.EncodeToString(h.Sum(nil))),
+		}, nil
+	}
+
+	f, err := ioutil.TempFile("", "115drive-upload-")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("spool body fail, expect %d bytes, got %d", size, n)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &spooledBody{
+		ReaderAt: f,
+		file:     f,
+		size:     n,
+		sha1:     strings.ToUpper(hex.EncodeToString(h.Sum(nil))),
+	}, nil
+}
+
+// rangeSHA1 returns the upper case hex SHA1 of the inclusive byte range
+// "start-end", in the format of the sign_check field of an upload init reply.
+func (b *spooledBody) rangeSHA1(byteRange string) (string, error) {
+	parts := strings.SplitN(byteRange, "-", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid sign check range: %s", byteRange)
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid sign check range: %s", byteRange)
+	}
+	end, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || start < 0 || end < start || end >= b.size {
+		return "", fmt.Errorf("invalid sign check range: %s", byteRange)
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(b, start, end-start+1)); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+func (b *spooledBody) preID() (string, error) {
+	if b.size == 0 {
+		return "", nil
+	}
+	end := b.size
+	if end > uploadPreHashSize {
+		end = uploadPreHashSize
+	}
+	return b.rangeSHA1(fmt.Sprintf("0-%d", end-1))
+}
+
+func (b *spooledBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	os.Remove(b.file.Name())
+	return err
+}
+
+// UploadFile creates or replaces the file at filePath with the content of r.
+// size is the length of r, or -1 if unknown. A file that is replaced is kept
+// if the upload fails.
+func (c *DriveClient) UploadFile(ctx context.Context, filePath string, r io.Reader, size int64) error {
+	filePath = slashClean(filePath)
+	if filePath == "/" {
+		return fmt.Errorf("invalid upload path: %s", filePath)
+	}
+	dir, fileName := path.Split(filePath)
+
+	dirFi, err := c.GetFile(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if !dirFi.IsDir() {
+		return common.ErrNotFound
+	}
+
+	body, err := spoolBody(r, size)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// An existing file is replaced once the new content is uploaded next to
+	// it under a temporary name, so that a failed upload keeps it.
+	uploadName, replace := fileName, false
+	if fi, err := c.GetFile(ctx, filePath); err == nil {
+		if fi.IsDir() {
+			return fmt.Errorf("upload file fail, %s is a dir", filePath)
+		}
+		if fi.GetSize() == body.size && strings.EqualFold(fi.(*FileInfo).Sha1, body.sha1) {
+			logrus.Infof("upload file, same content exists, skip, path: %s, sha1: %s", filePath, body.sha1)
+			return nil
+		}
+		uploadName = fmt.Sprintf("%s.uploading-%s", fileName, strconv.FormatInt(time.Now().UnixNano(), 36))
+		replace = true
+	} else if !errors.Is(err, common.ErrNotFound) {
+		return err
+	}
+
+	logrus.Infof("upload file, path: %s, size: %d, sha1: %s", filePath, body.size, body.sha1)
+	cid := dirFi.(*FileInfo).CategoryID.String()
+	initResp, err := c.initUpload(ctx, cid, uploadName, body)
+	if err != nil {
+		return err
+	}
+
+	switch initResp.Status {
+	case uploadStatusDone:
+		logrus.Infof("upload file, fast upload succ, path: %s", filePath)
+	case uploadStatusNeedUpload:
+		token, err := APIGetUploadToken(ctx, c.HttpClient, c.apiBaseURLs.UploadAPI)
+		if err != nil {
+			return err
+		}
+		oss := newOSSClient(c.HttpClient.GetClient().Transport, token, c.ossEndpoint, initResp.Bucket, initResp.Object)
+		if _, err := oss.multipartUpload(ctx, body, body.size, initResp.Callback.Callback, initResp.Callback.CallbackVar); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("init upload fail, status: %d, msg: %s", initResp.Status, initResp.StatusMsg)
+	}
+
+	c.flushDir(dir)
+	if replace {
+		return c.replaceFile(ctx, path.Join(dir, uploadName), filePath)
+	}
+	return nil
+}
+
+// replaceFile replaces the file at filePath with the uploaded file at
+// uploadPath, in the same dir. The uploaded file is removed if the old one
+// can not be.
+func (c *DriveClient) replaceFile(ctx context.Context, uploadPath string, filePath string) error {
+	uploaded, err := c.GetFile(ctx, uploadPath)
+	if err != nil {
+		return err
+	}
+	if err := c.RemoveFile(ctx, filePath); err != nil && !errors.Is(err, common.ErrNotFound) {
+		if err := c.RemoveFile(ctx, uploadPath); err != nil {
+			logrus.WithError(err).Errorf("remove uploaded file fail, path: %s", uploadPath)
+		}
+		return err
+	}
+
+	err = c.renameFile(ctx, uploaded.(*FileInfo).FileID.String(), path.Base(filePath))
+	c.flushDir(path.Dir(filePath))
+	if err != nil {
+		return fmt.Errorf("upload file fail, the new content is kept at %s, err: %w", uploadPath, err)
+	}
+	return nil
+}
+
+// initUpload runs the upload init handshake, which doubles as 115's fast
+// upload: when the drive already has content with the same SHA1 and size it
+// may ask for the SHA1 of a byte range as proof of possession, and then
+// links the file without any data transfer.
+func (c *DriveClient) initUpload(ctx context.Context, cid string, fileName string, body *spooledBody) (*APIInitUploadResp, error) {
+	userID, userKey, err := c.getUploadInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	preID, err := body.preID()
+	if err != nil {
+		return nil, err
+	}
+
+	signKey, signVal := "", ""
+	for i := 0; ; i++ {
+		resp, err := APIInitUpload(ctx, c.HttpClient, c.apiBaseURLs.UploadAPI, userID, userKey, cid, fileName, body.size, body.sha1, preID, signKey, signVal)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Status != uploadStatusSignCheck {
+			return resp, nil
+		}
+		if i >= uploadMaxSignChecks {
+			return nil, fmt.Errorf("init upload fail, too many sign checks, file_name: %s", fileName)
+		}
+
+		signKey = resp.SignKey
+		signVal, err = body.rangeSHA1(resp.SignCheck)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *DriveClient) getUploadInfo(ctx context.Context) (string, string, error) {
+	if value, err := c.cache.Get("upload_info"); err == nil {
+		info := value.(*APIGetUploadInfoResp)
+		return info.UserID.String(), info.UserKey, nil
+	}
+
+	info, err := APIGetUploadInfo(ctx, c.HttpClient, c.apiBaseURLs.ProAPI)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.State {
+		return "", "", newAPIError("get upload info", info.ErrNo, info.Error)
+	}
+	if err := c.cache.Set("upload_info", info); err != nil {
+		logrus.WithError(err).Errorf("call c.cache.Set fail, key: upload_info")
+	}
+
+	return info.UserID.String(), info.UserKey, nil
+}
@@ -0,0 +1,80 @@
+package _115_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gaoyb7/115drive-webdav/115/fake115"
+)
+
+func TestUploadFileReplaces(t *testing.T) {
+	s, c := newTestClient(t)
+	s.WriteFile("/a/f.txt", []byte("old content"))
+	ctx := context.Background()
+
+	fi, err := c.GetFile(ctx, "/a/f.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if err := c.UploadFile(ctx, "/a/f.txt", strings.NewReader("new content"), -1); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got, _ := s.ReadFile("/a/f.txt"); string(got) != "new content" {
+		t.Errorf("UploadFile: got %q, want %q", got, "new content")
+	}
+	files, err := c.GetFiles(ctx, "/a")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("GetFiles after UploadFile: got %v, %v, want the replaced file only", files, err)
+	}
+	if files[0].GetID() == fi.GetID() {
+		t.Errorf("GetFiles after UploadFile: the old file is listed")
+	}
+
+	// Uploading the same content again changes nothing.
+	initCalls := s.Calls(fake115.PathInitUpload)
+	if err := c.UploadFile(ctx, "/a/f.txt", strings.NewReader("new content"), -1); err != nil {
+		t.Fatalf("UploadFile of the same content: %v", err)
+	}
+	if got := s.Calls(fake115.PathInitUpload) - initCalls; got != 0 {
+		t.Errorf("UploadFile of the same content: got %d init calls, want 0", got)
+	}
+}
+
+func TestUploadFileFailureKeepsFile(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		apiPath string
+		failure fake115.Failure
+	}{
+		{"init upload", fake115.PathInitUpload, fake115.Failure{ErrNo: 1, Error: "上传失败"}},
+		{"upload token", fake115.PathUploadToken, fake115.Failure{ErrNo: 1, Error: "获取失败"}},
+		{"oss", fake115.PathOSS, fake115.Failure{Status: http.StatusForbidden}},
+		{"oss part", fake115.PathOSSPart, fake115.Failure{Status: http.StatusForbidden}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s, c := newTestClient(t)
+			s.WriteFile("/a/f.txt", []byte("old content"))
+			ctx := context.Background()
+
+			s.Fail(tc.apiPath, tc.failure)
+			content := bytes.Repeat([]byte("x"), 100)
+			if err := c.UploadFile(ctx, "/a/f.txt", bytes.NewReader(content), int64(len(content))); err == nil {
+				t.Fatalf("UploadFile: got no error")
+			}
+			if got, _ := s.ReadFile("/a/f.txt"); string(got) != "old content" {
+				t.Errorf("UploadFile: got %q, want the old content", got)
+			}
+			files, err := c.GetFiles(ctx, "/a")
+			if err != nil || len(files) != 1 || files[0].GetName() != "f.txt" {
+				t.Errorf("GetFiles after UploadFile: got %v, %v, want f.txt only", files, err)
+			}
+			if n := s.Uploads(); n != 0 {
+				t.Errorf("UploadFile: got %d multipart uploads left, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	_115 "github.com/gaoyb7/115drive-webdav/115"
+	"github.com/gaoyb7/115drive-webdav/common/config"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// loginSessionTTL is how long a QR code login is kept, 115 expires the
+	// QR code well before.
+	loginSessionTTL = 10 * time.Minute
+)
+
+// Server serves the admin pages, which let users sign in to 115 with a QR
+// code from a browser, and check or replace the sessions of the drive
+// clients. Clients are keyed by account name, which is empty when a single
+// account is used. The account of a request is given by its account query
+// parameter.
+type Server struct {
+	clients    map[string]*_115.DriveClient
+	configFile string
+
+	mu       sync.Mutex
+	sessions map[string]*loginSession
+}
+
+type loginSession struct {
+	*_115.QRCodeSession
+	account string
+	created time.Time
+}
+
+// New returns a Server for clients. New cookies are used by the client of
+// their account right away, and saved to the config file configFile, if not
+// empty.
+func New(clients map[string]*_115.DriveClient, configFile string) *Server {
+	return &Server{
+		clients:    clients,
+		configFile: configFile,
+		sessions:   make(map[string]*loginSession),
+	}
+}
+
+// Register adds the admin routes to r.
+func (s *Server) Register(r gin.IRouter) {
+	r.GET("/", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, "/login")
+	})
+	r.GET("/login", s.handleLogin)
+	r.GET("/login/qrcode.png", s.handleQRCodeImage)
+	r.GET("/login/status", s.handleLoginStatus)
+	r.GET("/session", s.handleSession)
+	r.POST("/session/cookies", s.handleSetCookies)
+}
+
+func (s *Server) handleSession(c *gin.Context) {
+	client, ok := s.clients[c.Query("account")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "account not found"})
+		return
+	}
+	c.JSON(http.StatusOK, client.Session())
+}
+
+// handleSetCookies replaces the cookies of the drive client with the ones in
+// the JSON request body, such as {"UID": "", "CID": "", "SEID": "", "KID": ""}.
+func (s *Server) handleSetCookies(c *gin.Context) {
+	cookies := _115.Cookies{}
+	if err := c.ShouldBindJSON(&cookies); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	account := c.Query("account")
+	if _, ok := s.clients[account]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "account not found"})
+		return
+	}
+	if err := s.applyCookies(account, &cookies); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s.clients[account].Session())
+}
+
+// applyCookies makes the drive client of account use cookies, and saves
+// them to the config file.
+func (s *Server) applyCookies(account string, cookies *_115.Cookies) error {
+	if err := s.clients[account].SetCookies(*cookies); err != nil {
+		return err
+	}
+	if len(s.configFile) == 0 {
+		return nil
+	}
+	if err := config.SaveCookies(s.configFile, account, cookies.UID, cookies.CID, cookies.SEID, cookies.KID); err != nil {
+		logrus.WithError(err).Errorf("call config.SaveCookies fail, filename: %s", s.configFile)
+		return fmt.Errorf("cookies are in use, but saving them to the config file failed, err: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) handleLogin(c *gin.Context) {
+	app, account := c.Query("app"), c.Query("account")
+	if len(app) == 0 {
+		accounts := make([]string, 0, len(s.clients))
+		for name := range s.clients {
+			if len(name) > 0 {
+				accounts = append(accounts, name)
+			}
+		}
+		sort.Strings(accounts)
+		s.render(c, loginPage, map[string]interface{}{
+			"Apps":     _115.QRCodeApps,
+			"Accounts": accounts,
+		})
+		return
+	}
+	if _, ok := s.clients[account]; !ok {
+		c.String(http.StatusNotFound, "account not found: %s", account)
+		return
+	}
+
+	session, err := _115.NewQRCodeSession(app)
+	if err != nil {
+		logrus.WithError(err).Errorf("call _115.NewQRCodeSession fail, app: %s", app)
+		c.String(http.StatusBadGateway, "start qrcode login fail, err: %v", err)
+		return
+	}
+	s.mu.Lock()
+	for uid, ls := range s.sessions {
+		if time.Since(ls.created) > loginSessionTTL {
+			delete(s.sessions, uid)
+		}
+	}
+	s.sessions[session.UID] = &loginSession{QRCodeSession: session, account: account, created: time.Now()}
+	s.mu.Unlock()
+
+	s.render(c, qrcodePage, map[string]interface{}{
+		"App":     app,
+		"Account": account,
+		"UID":     session.UID,
+	})
+}
+
+func (s *Server) handleQRCodeImage(c *gin.Context) {
+	session := s.session(c.Query("uid"))
+	if session == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	data, err := session.Image()
+	if err != nil {
+		logrus.WithError(err).Errorf("call session.Image fail, uid: %s", session.UID)
+		c.Status(http.StatusBadGateway)
+		return
+	}
+	c.Data(http.StatusOK, "image/png", data)
+}
+
+// handleLoginStatus polls the status of a QR code login once, and finishes
+// the login when it has been confirmed in the app.
+func (s *Server) handleLoginStatus(c *gin.Context) {
+	session := s.session(c.Query("uid"))
+	if session == nil {
+		c.JSON(http.StatusOK, gin.H{"status": _115.QRCodeStatusExpired, "message": "login session not found, please retry"})
+		return
+	}
+
+	status, err := session.Status()
+	if err != nil {
+		logrus.WithError(err).Errorf("call session.Status fail, uid: %s", session.UID)
+		c.JSON(http.StatusBadGateway, gin.H{"message": err.Error()})
+		return
+	}
+	switch status {
+	case _115.QRCodeStatusWaiting:
+		c.JSON(http.StatusOK, gin.H{"status": status, "message": "waiting for scan"})
+	case _115.QRCodeStatusScanned:
+		c.JSON(http.StatusOK, gin.H{"status": status, "message": "scanned, please confirm the login in the 115 app"})
+	case _115.QRCodeStatusConfirmed:
+		s.finishLogin(c, session)
+	case _115.QRCodeStatusCanceled:
+		s.removeSession(session.UID)
+		c.JSON(http.StatusOK, gin.H{"status": status, "message": "login canceled"})
+	default:
+		s.removeSession(session.UID)
+		c.JSON(http.StatusOK, gin.H{"status": _115.QRCodeStatusExpired, "message": "qrcode expired, please retry"})
+	}
+}
+
+func (s *Server) finishLogin(c *gin.Context, session *loginSession) {
+	s.removeSession(session.UID)
+	cookies, err := session.Login()
+	if err != nil {
+		logrus.WithError(err).Errorf("call session.Login fail, uid: %s", session.UID)
+		c.JSON(http.StatusBadGateway, gin.H{"message": err.Error()})
+		return
+	}
+	logrus.Infof("qrcode login succ, app: %s, account: %s", session.App, session.account)
+
+	resp := gin.H{
+		"status":  _115.QRCodeStatusConfirmed,
+		"cookies": cookies,
+		"message": "login succeeded, the cookies are in use",
+	}
+	if err := s.applyCookies(session.account, cookies); err != nil {
+		resp["message"] = "login succeeded, but " + err.Error() + ", please set the cookies below by hand"
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) session(uid string) *loginSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[uid]
+}
+
+func (s *Server) removeSession(uid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, uid)
+}
+
+func (s *Server) render(c *gin.Context, tmpl *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logrus.WithError(err).Errorf("call tmpl.Execute fail, name: %s", tmpl.Name())
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
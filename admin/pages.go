@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"html/template"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>115drive-webdav login</title></head>
+<body>
+<h2>Sign in to 115 with a QR code</h2>
+<form method="get" action="/login">
+<p>115 keeps one session per app type, signing in as an app you use elsewhere signs that device out.</p>
+{{if .Accounts}}<label>Account
+<select name="account">
+{{range .Accounts}}<option value="{{.}}">{{.}}</option>
+{{end}}</select>
+</label>
+{{end}}<label>App type
+<select name="app">
+{{range .Apps}}<option value="{{.}}"{{if eq . "tv"}} selected{{end}}>{{.}}</option>
+{{end}}</select>
+</label>
+<button type="submit">Show QR code</button>
+</form>
+</body>
+</html>
+`))
+
+var qrcodePage = template.Must(template.New("qrcode").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>115drive-webdav login</title></head>
+<body>
+<h2>Scan the QR code with the 115 app</h2>
+<p>App type: {{.App}}{{if .Account}}, account: {{.Account}}{{end}}</p>
+<img src="/login/qrcode.png?uid={{.UID}}" alt="qrcode">
+<p id="message">waiting for scan</p>
+<pre id="cookies"></pre>
+<p><a href="/login">Restart</a></p>
+<script>
+function poll() {
+	fetch("/login/status?uid={{.UID}}").then(function (resp) {
+		return resp.json();
+	}).then(function (result) {
+		document.getElementById("message").textContent = result.message;
+		if (result.cookies) {
+			document.getElementById("cookies").textContent = JSON.stringify(result.cookies, null, 2);
+		}
+		if (result.status === 0 || result.status === 1) {
+			poll();
+		} else if (result.status === undefined) {
+			setTimeout(poll, 3000);
+		}
+	}).catch(function () {
+		setTimeout(poll, 3000);
+	});
+}
+poll();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,33 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicfile-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "f.json")
+
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFile(filename, []byte(content)); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if got, err := ioutil.ReadFile(filename); err != nil || string(got) != content {
+			t.Errorf("after WriteFile: got %q, %v, want %q", got, err, content)
+		}
+	}
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 1 {
+		t.Errorf("after WriteFile: got %d files, want the temp files removed", len(infos))
+	}
+
+	if err := WriteFile(filepath.Join(dir, "nope", "f.json"), []byte("x")); err == nil {
+		t.Errorf("WriteFile in a missing dir: got no error")
+	}
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Authenticator checks the credentials of WebDAV users.
+type Authenticator interface {
+	// Authenticate reports whether password is the password of user. An
+	// error means the check could not be done.
+	Authenticate(user string, password string) (bool, error)
+}
+
+// Normalizer is implemented by Authenticators accepting several spellings
+// of a user name, such as LDAP whose names ignore case.
+type Normalizer interface {
+	// NormalizeUser returns the spelling of user used by the server.
+	NormalizeUser(user string) string
+}
+
+// NormalizeUser returns the spelling of user used for a, the name Middleware
+// stores, so that names looked up in tables of users match whatever the
+// spelling the user logged in with.
+func NormalizeUser(a Authenticator, user string) string {
+	if n, ok := a.(Normalizer); ok {
+		return n.NormalizeUser(user)
+	}
+	return user
+}
+
+// staticAuth authenticates users against a fixed table of passwords.
+type staticAuth struct {
+	passwords map[string]string
+}
+
+// NewStatic returns an Authenticator for the users in passwords, whose
+// values are plain passwords or hashes made by HashPassword.
+func NewStatic(passwords map[string]string) Authenticator {
+	return &staticAuth{passwords: passwords}
+}
+
+func (a *staticAuth) Authenticate(user string, password string) (bool, error) {
+	hash, ok := a.passwords[user]
+	if !ok {
+		return false, nil
+	}
+	return CheckPassword(hash, password)
+}
+
+// cachedAuth remembers the successful checks of an Authenticator for a
+// while. WebDAV clients send their credentials with every request, which
+// would otherwise cost a password hash or an LDAP bind each.
+type cachedAuth struct {
+	Authenticator
+	ttl time.Duration
+
+	mu    sync.Mutex
+	valid map[[sha256.Size]byte]time.Time
+}
+
+// NewCached returns a, with successful checks remembered for ttl.
+func NewCached(a Authenticator, ttl time.Duration) Authenticator {
+	return &cachedAuth{
+		Authenticator: a,
+		ttl:           ttl,
+		valid:         make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+func (a *cachedAuth) NormalizeUser(user string) string {
+	return NormalizeUser(a.Authenticator, user)
+}
+
+func (a *cachedAuth) Authenticate(user string, password string) (bool, error) {
+	key := sha256.Sum256([]byte(strconv.Quote(user) + password))
+	now := time.Now()
+	a.mu.Lock()
+	expiry, ok := a.valid[key]
+	a.mu.Unlock()
+	if ok && now.Before(expiry) {
+		return true, nil
+	}
+
+	ok, err := a.Authenticator.Authenticate(user, password)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, expiry := range a.valid {
+		if !now.Before(expiry) {
+			delete(a.valid, k)
+		}
+	}
+	if ok {
+		a.valid[key] = now.Add(a.ttl)
+	}
+	return ok, err
+}
+
+// Middleware returns a gin middleware requiring HTTP Basic authentication
+// checked by a. The user name, normalized by NormalizeUser, is stored under
+// gin.AuthUserKey, as gin.BasicAuth does.
+func Middleware(a Authenticator, realm string) gin.HandlerFunc {
+	challenge := "Basic realm=" + strconv.Quote(realm)
+	return func(c *gin.Context) {
+		user, password, ok := c.Request.BasicAuth()
+		if ok {
+			var err error
+			ok, err = a.Authenticate(user, password)
+			if err != nil {
+				logrus.WithError(err).Errorf("call a.Authenticate fail, user: %s", user)
+				c.AbortWithStatus(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if !ok {
+			c.Header("WWW-Authenticate", challenge)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set(gin.AuthUserKey, NormalizeUser(a, user))
+	}
+}
+
+func equal(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
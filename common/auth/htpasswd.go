@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// htpasswdAuth authenticates users against an Apache htpasswd file, which is
+// read again when it changes.
+type htpasswdAuth struct {
+	filename string
+
+	mu      sync.Mutex
+	modTime time.Time
+	hashes  map[string]string
+}
+
+// NewHtpasswd returns an Authenticator for the users of the htpasswd file
+// filename. Passwords hashed with bcrypt, apr1 MD5 and SHA1 are supported,
+// as well as plain passwords.
+func NewHtpasswd(filename string) (Authenticator, error) {
+	a := &htpasswdAuth{filename: filename}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *htpasswdAuth) Authenticate(user string, password string) (bool, error) {
+	if err := a.reload(); err != nil {
+		return false, err
+	}
+	a.mu.Lock()
+	hash, ok := a.hashes[user]
+	a.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	switch {
+	case strings.HasPrefix(hash, "$apr1$"):
+		parts := strings.SplitN(hash, "$", 4)
+		if len(parts) != 4 {
+			return false, fmt.Errorf("invalid apr1 hash, user: %s", user)
+		}
+		return equal(hash, apr1(password, parts[2])), nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return equal(hash[len("{SHA}"):], base64.StdEncoding.EncodeToString(sum[:])), nil
+	}
+	return CheckPassword(hash, password)
+}
+
+// reload reads the file if it was modified since it was last read.
+func (a *htpasswdAuth) reload() error {
+	info, err := os.Stat(a.filename)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.hashes != nil && info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(a.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			continue
+		}
+		hashes[line[:i]] = line[i+1:]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	a.hashes = hashes
+	a.modTime = info.ModTime()
+	return nil
+}
+
+// apr1 returns the Apache variant of the MD5 crypt hash of password with
+// salt, as made by "htpasswd -m".
+func apr1(password string, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw, s := []byte(password), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(s)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	d := md5.New()
+	d.Write(pw)
+	d.Write([]byte(magic))
+	d.Write(s)
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			d.Write(altSum)
+		} else {
+			d.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	sum := d.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		r := md5.New()
+		if i&1 != 0 {
+			r.Write(pw)
+		} else {
+			r.Write(sum)
+		}
+		if i%3 != 0 {
+			r.Write(s)
+		}
+		if i%7 != 0 {
+			r.Write(pw)
+		}
+		if i&1 != 0 {
+			r.Write(sum)
+		} else {
+			r.Write(pw)
+		}
+		sum = r.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var sb strings.Builder
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			sb.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(uint32(sum[0])<<16|uint32(sum[6])<<8|uint32(sum[12]), 4)
+	encode(uint32(sum[1])<<16|uint32(sum[7])<<8|uint32(sum[13]), 4)
+	encode(uint32(sum[2])<<16|uint32(sum[8])<<8|uint32(sum[14]), 4)
+	encode(uint32(sum[3])<<16|uint32(sum[9])<<8|uint32(sum[15]), 4)
+	encode(uint32(sum[4])<<16|uint32(sum[10])<<8|uint32(sum[5]), 4)
+	encode(uint32(sum[11]), 2)
+	return magic + salt + "$" + sb.String()
+}
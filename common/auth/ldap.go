@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ldapTimeout = 10 * time.Second
+
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+
+	ldapBindRequest   = 0x60
+	ldapBindResponse  = 0x61
+	ldapUnbindRequest = 0x42
+	ldapSimpleAuth    = 0x80
+
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+)
+
+// ldapAuth authenticates users with an LDAP simple bind as the DN made from
+// a template, such as "uid=%s,ou=people,dc=example,dc=com".
+type ldapAuth struct {
+	addr    string
+	useTLS  bool
+	host    string
+	bindDN  string
+	timeout time.Duration
+}
+
+// NewLDAP returns an Authenticator binding to the LDAP server at rawURL,
+// "ldap://host:port" or "ldaps://host:port", as bindDN with the user name in
+// place of its %s.
+func NewLDAP(rawURL string, bindDN string) (Authenticator, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url: %s", rawURL)
+	}
+	a := &ldapAuth{host: u.Hostname(), bindDN: bindDN, timeout: ldapTimeout}
+	switch u.Scheme {
+	case "ldap":
+		a.addr = net.JoinHostPort(a.host, portOr(u.Port(), "389"))
+	case "ldaps":
+		a.addr = net.JoinHostPort(a.host, portOr(u.Port(), "636"))
+		a.useTLS = true
+	default:
+		return nil, fmt.Errorf("invalid ldap url: %s", rawURL)
+	}
+	if strings.Count(bindDN, "%s") != 1 {
+		return nil, fmt.Errorf("ldap bind dn must contain one %%s: %s", bindDN)
+	}
+	return a, nil
+}
+
+func portOr(port string, def string) string {
+	if len(port) == 0 {
+		return def
+	}
+	return port
+}
+
+// NormalizeUser returns user in lower case, as LDAP servers match user names
+// ignoring case.
+func (a *ldapAuth) NormalizeUser(user string) string {
+	return strings.ToLower(user)
+}
+
+func (a *ldapAuth) Authenticate(user string, password string) (bool, error) {
+	// An empty password would be an unauthenticated bind, which servers
+	// accept for any DN.
+	if len(user) == 0 || len(password) == 0 {
+		return false, nil
+	}
+
+	dialer := &net.Dialer{Timeout: a.timeout}
+	var conn net.Conn
+	var err error
+	if a.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", a.addr, &tls.Config{ServerName: a.host})
+	} else {
+		conn, err = dialer.Dial("tcp", a.addr)
+	}
+	if err != nil {
+		return false, fmt.Errorf("ldap dial fail, addr: %s, err: %v", a.addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(a.timeout))
+
+	dn := strings.Replace(a.bindDN, "%s", escapeDN(user), 1)
+	bind := berTLV(berSequence,
+		berTLV(berInteger, []byte{1}),
+		berTLV(ldapBindRequest,
+			berTLV(berInteger, []byte{3}),
+			berTLV(berOctetString, []byte(dn)),
+			berTLV(ldapSimpleAuth, []byte(password)),
+		),
+	)
+	if _, err := conn.Write(bind); err != nil {
+		return false, fmt.Errorf("ldap write bind request fail, err: %v", err)
+	}
+	code, msg, err := readBindResponse(conn)
+	if err != nil {
+		return false, err
+	}
+	conn.Write(berTLV(berSequence, berTLV(berInteger, []byte{2}), berTLV(ldapUnbindRequest)))
+
+	switch code {
+	case ldapResultSuccess:
+		return true, nil
+	case ldapResultInvalidCredentials:
+		return false, nil
+	}
+	return false, fmt.Errorf("ldap bind fail, result_code: %d, message: %s", code, msg)
+}
+
+// readBindResponse reads a BindResponse message and returns its result code
+// and diagnostic message.
+func readBindResponse(r io.Reader) (int, string, error) {
+	tag, msg, err := berRead(r)
+	if err != nil {
+		return 0, "", fmt.Errorf("ldap read bind response fail, err: %v", err)
+	}
+	if tag != berSequence {
+		return 0, "", errors.New("ldap invalid bind response")
+	}
+	body := bytes.NewReader(msg)
+	if tag, _, err = berRead(body); err != nil || tag != berInteger {
+		return 0, "", errors.New("ldap invalid bind response message id")
+	}
+	tag, op, err := berRead(body)
+	if err != nil || tag != ldapBindResponse {
+		return 0, "", errors.New("ldap invalid bind response operation")
+	}
+
+	body = bytes.NewReader(op)
+	tag, code, err := berRead(body)
+	if err != nil || tag != berEnumerated || len(code) == 0 {
+		return 0, "", errors.New("ldap invalid bind response result code")
+	}
+	result := 0
+	for _, b := range code {
+		result = result<<8 | int(b)
+	}
+	var diag []byte
+	if _, _, err := berRead(body); err == nil {
+		_, diag, _ = berRead(body)
+	}
+	return result, string(diag), nil
+}
+
+// berTLV encodes a BER element with tag, and the concatenation of values
+// as content.
+func berTLV(tag byte, values ...[]byte) []byte {
+	content := bytes.Join(values, nil)
+	buf := []byte{tag}
+	n := len(content)
+	switch {
+	case n < 0x80:
+		buf = append(buf, byte(n))
+	case n <= 0xff:
+		buf = append(buf, 0x81, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 0x82, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(buf, content...)
+}
+
+// berRead reads a BER element, and returns its tag and content.
+func berRead(r io.Reader) (byte, []byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	n := int(head[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return 0, nil, errors.New("unsupported ber length")
+		}
+		lenBytes := make([]byte, size)
+		if _, err := io.ReadFull(r, lenBytes); err != nil {
+			return 0, nil, err
+		}
+		n = 0
+		for _, b := range lenBytes {
+			n = n<<8 | int(b)
+		}
+		if n > 1<<20 {
+			return 0, nil, errors.New("ber element too large")
+		}
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return head[0], content, nil
+}
+
+// escapeDN escapes s for use as an attribute value in a DN, see RFC 4514.
+func escapeDN(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;=", c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(s)-1):
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20:
+			fmt.Fprintf(&sb, "\\%02x", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bindRequest is a bind request received by a fake LDAP server.
+type bindRequest struct {
+	dn       string
+	password string
+}
+
+// fakeLDAP serves LDAP binds on a local listener, answering each with the
+// bytes returned by respond. It returns an Authenticator binding to it, and
+// the channel of the received binds.
+func fakeLDAP(t *testing.T, respond func(bindRequest) []byte) (Authenticator, <-chan bindRequest) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	binds := make(chan bindRequest, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, ok := readBindRequest(conn)
+				if !ok {
+					return
+				}
+				binds <- req
+				conn.Write(respond(req))
+			}()
+		}
+	}()
+
+	a, err := NewLDAP("ldap://"+l.Addr().String(), "uid=%s,ou=people,dc=example,dc=com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.(*ldapAuth).timeout = time.Second
+	return a, binds
+}
+
+func readBindRequest(conn net.Conn) (bindRequest, bool) {
+	tag, msg, err := berRead(conn)
+	if err != nil || tag != berSequence {
+		return bindRequest{}, false
+	}
+	body := bytes.NewReader(msg)
+	if tag, _, err := berRead(body); err != nil || tag != berInteger {
+		return bindRequest{}, false
+	}
+	tag, op, err := berRead(body)
+	if err != nil || tag != ldapBindRequest {
+		return bindRequest{}, false
+	}
+	body = bytes.NewReader(op)
+	if tag, _, err := berRead(body); err != nil || tag != berInteger {
+		return bindRequest{}, false
+	}
+	_, dn, err := berRead(body)
+	if err != nil {
+		return bindRequest{}, false
+	}
+	tag, password, err := berRead(body)
+	if err != nil || tag != ldapSimpleAuth {
+		return bindRequest{}, false
+	}
+	return bindRequest{dn: string(dn), password: string(password)}, true
+}
+
+// bindResponse encodes a BindResponse message with result code.
+func bindResponse(code byte, message string) []byte {
+	return berTLV(berSequence,
+		berTLV(berInteger, []byte{1}),
+		berTLV(ldapBindResponse,
+			berTLV(berEnumerated, []byte{code}),
+			berTLV(berOctetString, nil),
+			berTLV(berOctetString, []byte(message)),
+		),
+	)
+}
+
+func TestLDAPBind(t *testing.T) {
+	a, binds := fakeLDAP(t, func(req bindRequest) []byte {
+		if req.password == "secret" {
+			return bindResponse(ldapResultSuccess, "")
+		}
+		return bindResponse(ldapResultInvalidCredentials, "invalid credentials")
+	})
+
+	ok, err := a.Authenticate("alice", "secret")
+	if !ok || err != nil {
+		t.Errorf("Authenticate with the right password: got %v, %v, want true", ok, err)
+	}
+	want := bindRequest{dn: "uid=alice,ou=people,dc=example,dc=com", password: "secret"}
+	if got := <-binds; got != want {
+		t.Errorf("Authenticate: got bind %+v, want %+v", got, want)
+	}
+
+	ok, err = a.Authenticate("alice", "wrong")
+	if ok || err != nil {
+		t.Errorf("Authenticate with a wrong password: got %v, %v, want false", ok, err)
+	}
+	<-binds
+
+	// The user name can not change the DN.
+	a.Authenticate("alice,ou=admins", "secret")
+	if got := (<-binds).dn; got != `uid=alice\,ou\=admins,ou=people,dc=example,dc=com` {
+		t.Errorf("Authenticate with a comma in the user name: got dn %q", got)
+	}
+
+	// An empty password would be an unauthenticated bind.
+	ok, err = a.Authenticate("alice", "")
+	if ok || err != nil {
+		t.Errorf("Authenticate with an empty password: got %v, %v, want false", ok, err)
+	}
+	select {
+	case req := <-binds:
+		t.Errorf("Authenticate with an empty password: got bind %+v", req)
+	default:
+	}
+}
+
+func TestLDAPBindErrors(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		response []byte
+	}{
+		{"other result code", bindResponse(53, "unwilling to perform")},
+		{"empty response", nil},
+		{"not a sequence", berTLV(berOctetString, []byte("hello"))},
+		{"truncated", bindResponse(ldapResultSuccess, "")[:6]},
+		{"not a bind response", berTLV(berSequence,
+			berTLV(berInteger, []byte{1}),
+			berTLV(0x65, berTLV(berEnumerated, []byte{0})),
+		)},
+		{"missing result code", berTLV(berSequence,
+			berTLV(berInteger, []byte{1}),
+			berTLV(ldapBindResponse),
+		)},
+		{"result code of another type", berTLV(berSequence,
+			berTLV(berInteger, []byte{1}),
+			berTLV(ldapBindResponse, berTLV(berOctetString, []byte{0})),
+		)},
+		{"unsupported length", []byte{berSequence, 0x80}},
+		{"too large", []byte{berSequence, 0x84, 0x7f, 0xff, 0xff, 0xff}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			a, _ := fakeLDAP(t, func(bindRequest) []byte { return tc.response })
+			ok, err := a.Authenticate("alice", "secret")
+			if ok || err == nil {
+				t.Errorf("Authenticate: got %v, %v, want an error", ok, err)
+			}
+		})
+	}
+}
+
+func TestLDAPDialError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	a, err := NewLDAP("ldap://"+addr, "uid=%s,dc=example,dc=com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.Authenticate("alice", "secret"); ok || err == nil {
+		t.Errorf("Authenticate with no server: got %v, %v, want an error", ok, err)
+	}
+}
+
+func TestNewLDAP(t *testing.T) {
+	testCases := []struct {
+		url, bindDN string
+		addr        string
+		ok          bool
+	}{
+		{"ldap://example.com", "uid=%s,dc=example,dc=com", "example.com:389", true},
+		{"ldaps://example.com", "uid=%s,dc=example,dc=com", "example.com:636", true},
+		{"ldap://example.com:10389", "uid=%s,dc=example,dc=com", "example.com:10389", true},
+		{"http://example.com", "uid=%s,dc=example,dc=com", "", false},
+		{"ldap://example.com", "uid=admin,dc=example,dc=com", "", false},
+	}
+	for _, tc := range testCases {
+		a, err := NewLDAP(tc.url, tc.bindDN)
+		if (err == nil) != tc.ok {
+			t.Errorf("NewLDAP(%q, %q): got %v, want ok %v", tc.url, tc.bindDN, err, tc.ok)
+			continue
+		}
+		if err == nil && a.(*ldapAuth).addr != tc.addr {
+			t.Errorf("NewLDAP(%q, %q): got addr %s, want %s", tc.url, tc.bindDN, a.(*ldapAuth).addr, tc.addr)
+		}
+	}
+}
+
+func TestLDAPNormalizeUser(t *testing.T) {
+	a, binds := fakeLDAP(t, func(req bindRequest) []byte {
+		return bindResponse(ldapResultSuccess, "")
+	})
+	a = NewCached(a, time.Minute)
+	if got := NormalizeUser(a, "FaMiLy"); got != "family" {
+		t.Errorf("NormalizeUser: got %q, want %q", got, "family")
+	}
+	if got := NormalizeUser(NewStatic(nil), "FaMiLy"); got != "FaMiLy" {
+		t.Errorf("NormalizeUser of the static authenticator: got %q, want the name unchanged", got)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(a, "test"))
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(gin.AuthUserKey)) })
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("FAMILY", "secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "family" {
+		t.Errorf("Middleware: got %d, user %q, want the user %q", w.Code, w.Body.String(), "family")
+	}
+	if got := (<-binds).dn; got != "uid=FAMILY,ou=people,dc=example,dc=com" {
+		t.Errorf("Middleware: got bind dn %q, want the name as given", got)
+	}
+}
@@ -0,0 +1,186 @@
+package blockcache
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const blockFileExt = ".blk"
+
+// Cache stores fixed size blocks of file content as files in a directory.
+// Once the blocks take more than maxSize bytes, the least recently used
+// blocks are evicted.
+type Cache struct {
+	dir       string
+	blockSize int64
+	maxSize   int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	name string
+	size int64
+}
+
+// New returns a Cache of blocks of blockSize bytes stored in dir, which is
+// created if needed. Blocks left in dir by a previous Cache of the same
+// block size are kept, in the order of their modification time. Blocks of
+// another size and the temporary files of unfinished writes are removed.
+func New(dir string, blockSize int64, maxSize int64) (*Cache, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size: %d", blockSize)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:       dir,
+		blockSize: blockSize,
+		maxSize:   maxSize,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		if info.IsDir() || !strings.Contains(info.Name(), blockFileExt) {
+			continue
+		}
+		if !c.isBlock(info.Name()) {
+			if err := os.Remove(filepath.Join(dir, info.Name())); err != nil {
+				logrus.WithError(err).Warnf("remove stale block fail, name: %s", info.Name())
+			}
+			continue
+		}
+		c.entries[info.Name()] = c.lru.PushFront(&entry{name: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	logrus.Infof("block cache loaded, dir: %s, blocks: %d, size: %d", dir, len(c.entries), c.size)
+	return c, nil
+}
+
+// BlockSize returns the size of the blocks, every block but the last one of
+// a file has exactly this size.
+func (c *Cache) BlockSize() int64 {
+	return c.blockSize
+}
+
+// isBlock reports whether name is the name of a block of c, whose block
+// size is the one of c.
+func (c *Cache) isBlock(name string) bool {
+	parts := strings.Split(strings.TrimSuffix(name, blockFileExt), "_")
+	return strings.HasSuffix(name, blockFileExt) && len(parts) == 3 &&
+		parts[1] == strconv.FormatInt(c.blockSize, 10)
+}
+
+// Get returns block idx of the content identified by key.
+func (c *Cache) Get(key string, idx int64) ([]byte, bool) {
+	name := c.blockName(key, idx)
+	c.mu.Lock()
+	elem, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		logrus.WithError(err).Warnf("read cached block fail, name: %s", name)
+		c.mu.Lock()
+		c.remove(name)
+		c.mu.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores data as block idx of the content identified by key.
+func (c *Cache) Put(key string, idx int64, data []byte) error {
+	name := c.blockName(key, idx)
+	tmp, err := ioutil.TempFile(c.dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*entry).size
+		c.lru.Remove(elem)
+	}
+	c.entries[name] = c.lru.PushFront(&entry{name: name, size: int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used blocks until the cache fits in
+// maxSize. The caller must hold c.mu.
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		name := c.lru.Back().Value.(*entry).name
+		c.remove(name)
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).Warnf("remove cached block fail, name: %s", name)
+		}
+	}
+}
+
+// remove drops a block from the index. The caller must hold c.mu.
+func (c *Cache) remove(name string) {
+	elem, ok := c.entries[name]
+	if !ok {
+		return
+	}
+	c.size -= elem.Value.(*entry).size
+	c.lru.Remove(elem)
+	delete(c.entries, name)
+}
+
+// blockName returns the name of the file of block idx of key. It holds the
+// block size, so that the blocks cached with another size are told apart.
+func (c *Cache) blockName(key string, idx int64) string {
+	h := sha1.Sum([]byte(key))
+	return fmt.Sprintf("%s_%d_%d%s", hex.EncodeToString(h[:]), c.blockSize, idx, blockFileExt)
+}
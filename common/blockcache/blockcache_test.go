@@ -0,0 +1,160 @@
+package blockcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	os.Exit(m.Run())
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "blockcache-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func newTestCache(t *testing.T, dir string, blockSize int64, maxSize int64) *Cache {
+	c, err := New(dir, blockSize, maxSize)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func block(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 4)
+}
+
+func checkBlock(t *testing.T, c *Cache, key string, idx int64, want []byte) {
+	t.Helper()
+	got, ok := c.Get(key, idx)
+	if want == nil {
+		if ok {
+			t.Errorf("Get(%q, %d): got %q, want none", key, idx, got)
+		}
+		return
+	}
+	if !ok || !bytes.Equal(got, want) {
+		t.Errorf("Get(%q, %d): got %q, %v, want %q", key, idx, got, ok, want)
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	c := newTestCache(t, tempDir(t), 4, 100)
+	checkBlock(t, c, "a", 0, nil)
+	for i, data := range [][]byte{block('a'), block('b'), []byte("c")} {
+		if err := c.Put("a", int64(i), data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if err := c.Put("b", 0, block('d')); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	checkBlock(t, c, "a", 0, block('a'))
+	checkBlock(t, c, "a", 1, block('b'))
+	checkBlock(t, c, "a", 2, []byte("c"))
+	checkBlock(t, c, "b", 0, block('d'))
+	checkBlock(t, c, "b", 1, nil)
+
+	if err := c.Put("a", 0, block('e')); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	checkBlock(t, c, "a", 0, block('e'))
+	if c.size != 13 {
+		t.Errorf("size after a block is replaced: got %d, want 13", c.size)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	c := newTestCache(t, tempDir(t), 4, 12)
+	for i := int64(0); i < 3; i++ {
+		if err := c.Put("a", i, block('a')); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	// Block 0 is used, block 1 is the least recently used one.
+	checkBlock(t, c, "a", 0, block('a'))
+	if err := c.Put("a", 3, block('a')); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	checkBlock(t, c, "a", 1, nil)
+	for _, idx := range []int64{0, 2, 3} {
+		checkBlock(t, c, "a", idx, block('a'))
+	}
+
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("files after eviction: got %d, want 3", len(files))
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := tempDir(t)
+	c := newTestCache(t, dir, 4, 100)
+	for i := int64(0); i < 3; i++ {
+		if err := c.Put("a", i, block('a'+byte(i))); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		// The blocks are loaded in the order of their modification time.
+		mtime := time.Now().Add(time.Duration(i-3) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, c.blockName("a", i)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmp := filepath.Join(dir, c.blockName("a", 3)+".tmp123")
+	if err := ioutil.WriteFile(tmp, block('x'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other.txt")
+	if err := ioutil.WriteFile(other, block('x'), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c = newTestCache(t, dir, 4, 8)
+	checkBlock(t, c, "a", 0, nil)
+	checkBlock(t, c, "a", 1, block('b'))
+	checkBlock(t, c, "a", 2, block('c'))
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("temporary file after New: got %v, want it removed", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("other file after New: got %v, want it kept", err)
+	}
+}
+
+func TestReopenWithAnotherBlockSize(t *testing.T) {
+	dir := tempDir(t)
+	c := newTestCache(t, dir, 4, 100)
+	if err := c.Put("a", 1, block('a')); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	c = newTestCache(t, dir, 2, 100)
+	checkBlock(t, c, "a", 1, nil)
+	if c.size != 0 {
+		t.Errorf("size: got %d, want 0", c.size)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("files after New: got %d, want the blocks of size 4 removed", len(files))
+	}
+}
@@ -0,0 +1,95 @@
+// Package certreload provides a TLS certificate that is reloaded from its
+// files when they change on disk, so that renewed certificates, such as
+// those written by certbot, are served without a restart.
+package certreload
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Reloader holds the certificate of a cert and key file pair.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+// New loads the certificate of certFile and keyFile, both PEM encoded.
+func New(certFile string, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, it is meant for
+// tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch checks the files every interval and reloads the certificate when
+// either of them is modified. A certificate that fails to load, such as one
+// caught halfway through a renewal, is retried at the next check while the
+// previous one keeps being served. Watch never returns.
+func (r *Reloader) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloaded, err := r.reload()
+		if err != nil {
+			logrus.WithError(err).Warnf("reload tls certificate fail, cert: %s, key: %s", r.certFile, r.keyFile)
+			continue
+		}
+		if reloaded {
+			logrus.Infof("tls certificate reloaded, cert: %s, key: %s", r.certFile, r.keyFile)
+		}
+	}
+}
+
+// reload loads the certificate if the files were modified since the last
+// successful load, and reports whether it did.
+func (r *Reloader) reload() (bool, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && certInfo.ModTime().Equal(r.certTime) && keyInfo.ModTime().Equal(r.keyTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("call tls.LoadX509KeyPair fail, err: %v", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.certTime = certInfo.ModTime()
+	r.keyTime = keyInfo.ModTime()
+	r.mu.Unlock()
+	return true, nil
+}
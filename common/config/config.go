@@ -3,20 +3,101 @@ package config
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 
+	"github.com/gaoyb7/115drive-webdav/common/atomicfile"
+	"github.com/gaoyb7/115drive-webdav/common/jsonedit"
 	"github.com/sirupsen/logrus"
 )
 
+// Account is a 115 account mounted as a top level directory named Name.
+type Account struct {
+	Name string `json:"name"`
+	Uid  string `json:"uid"`
+	Cid  string `json:"cid"`
+	Seid string `json:"seid"`
+	Kid  string `json:"kid"`
+}
+
+// User is a WebDAV user, who sees the drive directory Root as the root of
+// the WebDAV tree, with a Permission of "full", "no-delete" or "read-only".
+type User struct {
+	Name       string `json:"name"`
+	Password   string `json:"pwd"`
+	Root       string `json:"root"`
+	Permission string `json:"permission"`
+}
+
 type config struct {
 	Uid      string `json:"uid"`
 	Cid      string `json:"cid"`
 	Seid     string `json:"seid"`
-	Kid     string `json:"kid"`
+	Kid      string `json:"kid"`
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
 	User     string `json:"user"`
 	Password string `json:"pwd"`
+	LockFile string `json:"lock_file"`
+	PropFile string `json:"prop_file"`
+
+	RedirectUsers      []string `json:"redirect_users"`
+	RedirectUserAgents []string `json:"redirect_user_agents"`
+
+	ParallelConnections int `json:"parallel_connections"`
+	ParallelChunkSizeMB int `json:"parallel_chunk_size_mb"`
+
+	CacheDir         string `json:"cache_dir"`
+	CacheSizeMB      int    `json:"cache_size_mb"`
+	CacheBlockSizeMB int    `json:"cache_block_size_mb"`
+
+	ReadAheadMB int `json:"read_ahead_mb"`
+
+	MetadataFile string `json:"metadata_file"`
+
+	DirCacheMinutes   int `json:"dir_cache_minutes"`
+	ChangePollSeconds int `json:"change_poll_seconds"`
+
+	APIRateLimit         float64 `json:"api_rate_limit"`
+	DownloadURLRateLimit float64 `json:"download_url_rate_limit"`
+	ContentRateLimit     float64 `json:"content_rate_limit"`
+	RateLimitBurst       int     `json:"rate_limit_burst"`
+	APIRetryCount        int     `json:"api_retry_count"`
+
+	AdminAddr string `json:"admin_addr"`
+
+	SessionCheckMinutes int `json:"session_check_minutes"`
+
+	// Accounts, when not empty, replace the cookies above with several
+	// accounts. They can only be set in the config file.
+	Accounts []Account `json:"accounts"`
+	// Users, when not empty, replace User and Password with several WebDAV
+	// users. They can only be set in the config file.
+	Users []User `json:"users"`
+
+	Auth                  string `json:"auth"`
+	HtpasswdFile          string `json:"htpasswd_file"`
+	LDAPURL               string `json:"ldap_url"`
+	LDAPBindDN            string `json:"ldap_bind_dn"`
+	AuthDefaultPermission string `json:"auth_default_permission"`
+
+	// LocalDir, when not empty, serves a local directory instead of 115,
+	// for development and testing, or is the "local" layer of Overlay.
+	LocalDir string `json:"local_dir"`
+
+	// Overlay, when not empty, merges the layers it names into one tree,
+	// the first layer having precedence, instead of mounting the accounts
+	// as directories. Layers are account names, "115" for the cookies above
+	// and "local" for LocalDir. Changes go to OverlayUpper, the first layer
+	// if empty.
+	Overlay      []string `json:"overlay"`
+	OverlayUpper string   `json:"overlay_upper"`
+
+	TLSCert          string `json:"tls_cert"`
+	TLSKey           string `json:"tls_key"`
+	HTTPRedirectPort int    `json:"http_redirect_port"`
 }
 
 var (
@@ -28,11 +109,55 @@ var (
 	cliUid      = flag.String("uid", "", "115 cookie uid")
 	cliCid      = flag.String("cid", "", "115 cookie cid")
 	cliSeid     = flag.String("seid", "", "115 cookie seid")
-	cliKid     = flag.String("kid", "", "115 cookie kid")
+	cliKid      = flag.String("kid", "", "115 cookie kid")
 	cliHost     = flag.String("host", "0.0.0.0", "webdav server host")
 	cliPort     = flag.Int("port", 8080, "webdav server port")
 	cliUser     = flag.String("user", "user", "webdav auth username")
-	cliPassword = flag.String("pwd", "123456", "webdav auth password")
+	cliPassword = flag.String("pwd", "123456", "webdav auth password, or a hash made by the hash-password subcommand")
+	cliLockFile = flag.String("lock-file", "", "file to persist webdav locks, keep locks in memory if empty")
+	cliPropFile = flag.String("prop-file", "", "file to persist webdav dead properties, keep properties in memory if empty")
+
+	cliRedirectUsers      = flag.String("redirect-users", "", "comma separated webdav users whose downloads are redirected to 115 instead of proxied")
+	cliRedirectUserAgents = flag.String("redirect-user-agents", "", "comma separated User-Agent substrings of clients whose downloads are redirected to 115 instead of proxied")
+
+	cliParallelConnections = flag.Int("parallel-connections", 0, "max concurrent 115 connections per proxied download, 0 or 1 to proxy over a single connection")
+	cliParallelChunkSizeMB = flag.Int("parallel-chunk-size-mb", 4, "chunk size in MB of parallel proxied downloads")
+
+	cliCacheDir         = flag.String("cache-dir", "", "directory to cache downloaded file content in, disable the cache if empty")
+	cliCacheSizeMB      = flag.Int("cache-size-mb", 10240, "max size in MB of the file content cache")
+	cliCacheBlockSizeMB = flag.Int("cache-block-size-mb", 4, "block size in MB of the file content cache")
+
+	cliReadAheadMB = flag.Int("read-ahead-mb", 0, "size in MB to prefetch ahead of clients reading a file sequentially, 0 to disable")
+
+	cliMetadataFile = flag.String("metadata-file", "", "file to persist directory listings in across restarts, keep them in memory if empty")
+
+	cliDirCacheMinutes   = flag.Int("dir-cache-minutes", 2, "minutes directory listings are cached for")
+	cliChangePollSeconds = flag.Int("change-poll-seconds", 0, "interval in seconds of the 115 file event poll, which drops the cached listings of changed directories, 0 to disable")
+
+	cliAPIRateLimit         = flag.Float64("api-rate-limit", 5, "max 115 api calls per second, 0 for the default of 5, negative for unlimited")
+	cliDownloadURLRateLimit = flag.Float64("download-url-rate-limit", 5, "max 115 download url calls per second, 0 for the default of 5, negative for unlimited")
+	cliContentRateLimit     = flag.Float64("content-rate-limit", 5, "max proxied content requests to the 115 CDN per second, 0 for the default of 5, negative for unlimited")
+	cliRateLimitBurst       = flag.Int("rate-limit-burst", 1, "burst of the 115 rate limits")
+	cliAPIRetryCount        = flag.Int("api-retry-count", 3, "retries of throttled 115 api calls and of failed GET calls, 0 for the default of 3, negative to disable")
+
+	cliAdminAddr = flag.String("admin-addr", "", "address of the admin http server, such as 127.0.0.1:8090, disable the admin server if empty")
+
+	cliSessionCheckMinutes = flag.Int("session-check-minutes", 10, "interval in minutes of the 115 session check, 0 to only check at startup")
+
+	cliAuth                  = flag.String("auth", "config", "webdav authentication backend, one of: config, htpasswd, ldap")
+	cliHtpasswdFile          = flag.String("htpasswd-file", "", "htpasswd file of the htpasswd authentication backend")
+	cliLDAPURL               = flag.String("ldap-url", "", "server of the ldap authentication backend, such as ldap://127.0.0.1:389 or ldaps://127.0.0.1:636")
+	cliLDAPBindDN            = flag.String("ldap-bind-dn", "", "dn to bind as with the ldap authentication backend, %s is replaced by the user name, such as uid=%s,ou=people,dc=example,dc=com")
+	cliAuthDefaultPermission = flag.String("auth-default-permission", "read-only", "permission of users authenticated by htpasswd or ldap who are not in the config users, one of: full, no-delete, read-only, they are refused if a config user has a root")
+
+	cliLocalDir = flag.String("local-dir", "", "serve this local directory instead of 115, for development and testing, the 115 cookies are not used, or the local layer of --overlay")
+
+	cliOverlay      = flag.String("overlay", "", "comma separated layers to merge into one tree, first layer first, such as 115,local: account names, 115 for the 115 cookies, local for the local dir")
+	cliOverlayUpper = flag.String("overlay-upper", "", "layer of the overlay that changes go to, the first layer if empty")
+
+	cliTLSCert          = flag.String("tls-cert", "", "PEM certificate file to serve https with, reloaded when modified, serve plain http if empty")
+	cliTLSKey           = flag.String("tls-key", "", "PEM private key file of the tls certificate")
+	cliHTTPRedirectPort = flag.Int("http-redirect-port", 0, "port of a plain http server redirecting to https, 0 to disable")
 )
 
 func init() {
@@ -50,6 +175,107 @@ func init() {
 	Config.Port = *cliPort
 	Config.User = *cliUser
 	Config.Password = *cliPassword
+	Config.LockFile = *cliLockFile
+	Config.PropFile = *cliPropFile
+	Config.RedirectUsers = splitList(*cliRedirectUsers)
+	Config.RedirectUserAgents = splitList(*cliRedirectUserAgents)
+	Config.ParallelConnections = *cliParallelConnections
+	Config.ParallelChunkSizeMB = *cliParallelChunkSizeMB
+	Config.CacheDir = *cliCacheDir
+	Config.CacheSizeMB = *cliCacheSizeMB
+	Config.CacheBlockSizeMB = *cliCacheBlockSizeMB
+	Config.ReadAheadMB = *cliReadAheadMB
+	Config.MetadataFile = *cliMetadataFile
+	Config.DirCacheMinutes = *cliDirCacheMinutes
+	Config.ChangePollSeconds = *cliChangePollSeconds
+	Config.APIRateLimit = *cliAPIRateLimit
+	Config.DownloadURLRateLimit = *cliDownloadURLRateLimit
+	Config.ContentRateLimit = *cliContentRateLimit
+	Config.RateLimitBurst = *cliRateLimitBurst
+	Config.APIRetryCount = *cliAPIRetryCount
+	Config.AdminAddr = *cliAdminAddr
+	Config.SessionCheckMinutes = *cliSessionCheckMinutes
+	Config.Auth = *cliAuth
+	Config.HtpasswdFile = *cliHtpasswdFile
+	Config.LDAPURL = *cliLDAPURL
+	Config.LDAPBindDN = *cliLDAPBindDN
+	Config.AuthDefaultPermission = *cliAuthDefaultPermission
+	Config.LocalDir = *cliLocalDir
+	Config.Overlay = splitList(*cliOverlay)
+	Config.OverlayUpper = *cliOverlayUpper
+	Config.TLSCert = *cliTLSCert
+	Config.TLSKey = *cliTLSKey
+	Config.HTTPRedirectPort = *cliHTTPRedirectPort
+}
+
+// Filename returns the config file given with --config, or an empty string
+// when the config comes from the command line.
+func Filename() string {
+	return *cliConfig
+}
+
+// LoadCookies reads the 115 cookies of the config file filename, those of
+// the accounts entry named account, or the top level ones if account is
+// empty.
+func LoadCookies(filename string, account string) (uid string, cid string, seid string, kid string, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	c := config{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", "", "", "", fmt.Errorf("call json.Unmarshal fail, filename: %s, err: %v", filename, err)
+	}
+	if len(account) == 0 {
+		return c.Uid, c.Cid, c.Seid, c.Kid, nil
+	}
+	for _, a := range c.Accounts {
+		if a.Name == account {
+			return a.Uid, a.Cid, a.Seid, a.Kid, nil
+		}
+	}
+	return "", "", "", "", fmt.Errorf("account not found in config, filename: %s, account: %s", filename, account)
+}
+
+// SaveCookies writes 115 cookies into the config file filename and keeps its
+// other settings, in place, as they were written. The cookies go to the
+// accounts entry named account, or to the top level if account is empty. The
+// file and the entry are created if they do not exist. The file is replaced
+// whole, so that a server reloading the cookies never reads half of it.
+func SaveCookies(filename string, account string, uid string, cid string, seid string, kid string) error {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		data, err = []byte("{}\n"), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cookies := []jsonedit.Member{
+		{Name: "uid", Value: uid},
+		{Name: "cid", Value: cid},
+		{Name: "seid", Value: seid},
+		{Name: "kid", Value: kid},
+	}
+	if len(account) > 0 {
+		data, err = jsonedit.SetElementMembers(data, "accounts", "name", account, cookies)
+	} else {
+		data, err = jsonedit.SetMembers(data, cookies)
+	}
+	if err != nil {
+		return fmt.Errorf("edit config file fail, filename: %s, err: %v", filename, err)
+	}
+	return atomicfile.WriteFile(filename, data)
+}
+
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
 }
 
 func load(filename string) {
@@ -1,11 +1,16 @@
 package drive
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"time"
 )
 
 type File interface {
+	// GetID returns an identifier of the file that stays the same when it
+	// is renamed or moved.
+	GetID() string
 	GetName() string
 	GetSize() int64
 	GetUpdateTime() time.Time
@@ -13,11 +18,29 @@ type File interface {
 	IsDir() bool
 }
 
+// DriveClient is a drive served over WebDAV. The calls that may reach the
+// drive take the context of the request they serve, so that they are
+// abandoned, rate limiter waits included, once the request is canceled or
+// its deadline passes. ServeContent uses the context of req.
 type DriveClient interface {
-	GetFiles(dir string) ([]File, error)
-	GetFile(filePath string) (File, error)
-	RemoveFile(filePath string) error
-	MoveFile(srcPath string, dstPath string) error
-	MakeDir(dir string) error
+	GetFiles(ctx context.Context, dir string) ([]File, error)
+	GetFile(ctx context.Context, filePath string) (File, error)
+	RemoveFile(ctx context.Context, filePath string) error
+	MoveFile(ctx context.Context, srcPath string, dstPath string) error
+	CopyFile(ctx context.Context, srcPath string, dstPath string) error
+	MakeDir(ctx context.Context, dir string) error
+	UploadFile(ctx context.Context, filePath string, r io.Reader, size int64) error
 	ServeContent(w http.ResponseWriter, req *http.Request, fi File)
 }
+
+// driveFile is a file of one of the drives combined by a MountClient or a
+// UnionClient, the drive named drive there. Its ID is prefixed with that
+// name, as IDs of different drives may collide.
+type driveFile struct {
+	File
+	drive string
+}
+
+func (f *driveFile) GetID() string {
+	return f.drive + ":" + f.File.GetID()
+}
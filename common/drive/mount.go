@@ -0,0 +1,216 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+)
+
+var errMountRoot = fmt.Errorf("%w: the root and mount points can not be modified", common.ErrForbidden)
+
+// MountClient is a DriveClient serving several drives, each one mounted as a
+// top level directory, so that "/alice/Movies" is "/Movies" of the drive
+// mounted as "alice". The root only lists the mount points, and changing it
+// or the mount points fails with common.ErrForbidden. Moving or copying between drives fails with
+// common.ErrCrossAccount, as the drives only copy server side.
+type MountClient struct {
+	names  []string
+	mounts map[string]DriveClient
+}
+
+func NewMountClient() *MountClient {
+	return &MountClient{
+		mounts: make(map[string]DriveClient),
+	}
+}
+
+// Mount adds client as the top level directory name.
+func (m *MountClient) Mount(name string, client DriveClient) error {
+	if len(name) == 0 || strings.Contains(name, "/") || name == "." || name == ".." {
+		return fmt.Errorf("invalid mount name: %s", name)
+	}
+	if _, ok := m.mounts[name]; ok {
+		return fmt.Errorf("duplicate mount name: %s", name)
+	}
+	m.names = append(m.names, name)
+	m.mounts[name] = client
+	return nil
+}
+
+// mountPoint is the root directory of a mounted drive.
+type mountPoint struct {
+	driveFile
+}
+
+func (f *mountPoint) GetName() string {
+	return f.drive
+}
+
+// rootDir is the root of a MountClient.
+type rootDir struct{}
+
+func (rootDir) GetID() string            { return "" }
+func (rootDir) GetName() string          { return "" }
+func (rootDir) GetSize() int64           { return 0 }
+func (rootDir) GetUpdateTime() time.Time { return time.Time{} }
+func (rootDir) GetCreateTime() time.Time { return time.Time{} }
+func (rootDir) IsDir() bool              { return true }
+
+// resolve splits name into the mount name and the path in the mounted
+// drive. The mount name is empty for the root.
+func (m *MountClient) resolve(name string) (mount string, client DriveClient, p string, err error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if len(name) == 0 {
+		return "", nil, "/", nil
+	}
+	mount, p = name, "/"
+	if i := strings.Index(name, "/"); i >= 0 {
+		mount, p = name[:i], name[i:]
+	}
+	client, ok := m.mounts[mount]
+	if !ok {
+		return "", nil, "", common.ErrNotFound
+	}
+	return mount, client, p, nil
+}
+
+// resolveWritable is resolve for changes, which are refused on the root and
+// on mount points, existing or not.
+func (m *MountClient) resolveWritable(name string) (mount string, client DriveClient, p string, err error) {
+	mount, client, p, err = m.resolve(name)
+	if errors.Is(err, common.ErrNotFound) && path.Dir(path.Clean("/"+name)) == "/" {
+		return "", nil, "", errMountRoot
+	}
+	if err != nil {
+		return "", nil, "", err
+	}
+	if client == nil || p == "/" {
+		return "", nil, "", errMountRoot
+	}
+	return mount, client, p, nil
+}
+
+func (m *MountClient) GetFiles(ctx context.Context, dir string) ([]File, error) {
+	mount, client, p, err := m.resolve(dir)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		files := make([]File, 0, len(m.names))
+		for _, name := range m.names {
+			fi, err := m.mountPoint(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, fi)
+		}
+		return files, nil
+	}
+
+	files, err := client.GetFiles(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]File, 0, len(files))
+	for _, fi := range files {
+		result = append(result, &driveFile{File: fi, drive: mount})
+	}
+	return result, nil
+}
+
+func (m *MountClient) GetFile(ctx context.Context, filePath string) (File, error) {
+	mount, client, p, err := m.resolve(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return rootDir{}, nil
+	}
+	if p == "/" {
+		return m.mountPoint(ctx, mount)
+	}
+
+	fi, err := client.GetFile(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &driveFile{File: fi, drive: mount}, nil
+}
+
+func (m *MountClient) mountPoint(ctx context.Context, mount string) (File, error) {
+	fi, err := m.mounts[mount].GetFile(ctx, "/")
+	if err != nil {
+		return nil, err
+	}
+	return &mountPoint{driveFile{File: fi, drive: mount}}, nil
+}
+
+func (m *MountClient) RemoveFile(ctx context.Context, filePath string) error {
+	_, client, p, err := m.resolveWritable(filePath)
+	if err != nil {
+		return err
+	}
+	return client.RemoveFile(ctx, p)
+}
+
+func (m *MountClient) MoveFile(ctx context.Context, srcPath string, dstPath string) error {
+	srcMount, client, src, err := m.resolveWritable(srcPath)
+	if err != nil {
+		return err
+	}
+	dstMount, _, dst, err := m.resolveWritable(dstPath)
+	if err != nil {
+		return err
+	}
+	if srcMount != dstMount {
+		return common.ErrCrossAccount
+	}
+	return client.MoveFile(ctx, src, dst)
+}
+
+func (m *MountClient) CopyFile(ctx context.Context, srcPath string, dstPath string) error {
+	srcMount, client, src, err := m.resolveWritable(srcPath)
+	if err != nil {
+		return err
+	}
+	dstMount, _, dst, err := m.resolveWritable(dstPath)
+	if err != nil {
+		return err
+	}
+	if srcMount != dstMount {
+		return common.ErrCrossAccount
+	}
+	return client.CopyFile(ctx, src, dst)
+}
+
+func (m *MountClient) MakeDir(ctx context.Context, dir string) error {
+	_, client, p, err := m.resolveWritable(dir)
+	if err != nil {
+		return err
+	}
+	return client.MakeDir(ctx, p)
+}
+
+func (m *MountClient) UploadFile(ctx context.Context, filePath string, r io.Reader, size int64) error {
+	_, client, p, err := m.resolveWritable(filePath)
+	if err != nil {
+		return err
+	}
+	return client.UploadFile(ctx, p, r, size)
+}
+
+func (m *MountClient) ServeContent(w http.ResponseWriter, req *http.Request, fi File) {
+	mf, ok := fi.(*driveFile)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	m.mounts[mf.drive].ServeContent(w, req, mf.File)
+}
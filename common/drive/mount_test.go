@@ -0,0 +1,228 @@
+package drive_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/gaoyb7/115drive-webdav/common/drive"
+	"github.com/gaoyb7/115drive-webdav/local"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	os.Exit(m.Run())
+}
+
+// newLocalDrive returns a local drive serving a new temp dir holding files,
+// a map of paths to contents, and the temp dir.
+func newLocalDrive(t *testing.T, files map[string]string) (*local.DriveClient, string) {
+	t.Helper()
+	root, err := ioutil.TempDir("", "drive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := local.New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, root
+}
+
+// readLocal returns the content of the file name of the local dir root, or
+// "" if it is missing.
+func readLocal(root string, name string) string {
+	data, _ := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	return string(data)
+}
+
+// names returns the sorted names of files.
+func names(files []drive.File) string {
+	result := make([]string, 0, len(files))
+	for _, fi := range files {
+		result = append(result, fi.GetName())
+	}
+	sort.Strings(result)
+	return strings.Join(result, " ")
+}
+
+// serve returns the content of fi served by c.
+func serve(c drive.DriveClient, fi drive.File) string {
+	w := httptest.NewRecorder()
+	c.ServeContent(w, httptest.NewRequest(http.MethodGet, "/", nil), fi)
+	if w.Code != http.StatusOK {
+		return ""
+	}
+	return w.Body.String()
+}
+
+func newTestMount(t *testing.T) (*drive.MountClient, string, string) {
+	t.Helper()
+	alice, aliceRoot := newLocalDrive(t, map[string]string{"f.txt": "alice", "d/g.txt": "g"})
+	bob, bobRoot := newLocalDrive(t, map[string]string{"f.txt": "bob"})
+	m := drive.NewMountClient()
+	if err := m.Mount("alice", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mount("bob", bob); err != nil {
+		t.Fatal(err)
+	}
+	return m, aliceRoot, bobRoot
+}
+
+func TestMount(t *testing.T) {
+	m, _, _ := newTestMount(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"", "a/b", ".", "..", "alice"} {
+		if err := m.Mount(name, drive.NewMountClient()); err == nil {
+			t.Errorf("Mount(%q): got no error", name)
+		}
+	}
+
+	files, err := m.GetFiles(ctx, "/")
+	if err != nil || names(files) != "alice bob" {
+		t.Fatalf("GetFiles of the root: got %q, %v, want alice bob", names(files), err)
+	}
+	for _, fi := range files {
+		if !fi.IsDir() {
+			t.Errorf("mount point %s is not a dir", fi.GetName())
+		}
+	}
+	files, err = m.GetFiles(ctx, "/alice")
+	if err != nil || names(files) != "d f.txt" {
+		t.Errorf("GetFiles of a mount point: got %q, %v, want d f.txt", names(files), err)
+	}
+
+	if fi, err := m.GetFile(ctx, "/"); err != nil || !fi.IsDir() {
+		t.Errorf("GetFile of the root: got %v, %v, want a dir", fi, err)
+	}
+	if fi, err := m.GetFile(ctx, "/bob"); err != nil || fi.GetName() != "bob" || !fi.IsDir() {
+		t.Errorf("GetFile of a mount point: got %v, %v, want the dir bob", fi, err)
+	}
+	for p, want := range map[string]string{"/alice/f.txt": "alice", "/bob/f.txt": "bob", "/alice/d/g.txt": "g"} {
+		fi, err := m.GetFile(ctx, p)
+		if err != nil {
+			t.Errorf("GetFile(%q): %v", p, err)
+			continue
+		}
+		if got := serve(m, fi); got != want {
+			t.Errorf("ServeContent of %s: got %q, want %q", p, got, want)
+		}
+	}
+	for _, p := range []string{"/carol", "/carol/f.txt", "/alice/nope"} {
+		if _, err := m.GetFiles(ctx, p); !errors.Is(err, common.ErrNotFound) {
+			t.Errorf("GetFiles(%q): got %v, want ErrNotFound", p, err)
+		}
+	}
+}
+
+func TestMountReadOnlyRoot(t *testing.T) {
+	m, aliceRoot, _ := newTestMount(t)
+	ctx := context.Background()
+
+	testCases := []struct {
+		desc string
+		call func() error
+	}{
+		{"remove a mount point", func() error { return m.RemoveFile(ctx, "/alice") }},
+		{"make a dir in the root", func() error { return m.MakeDir(ctx, "/carol") }},
+		{"upload to the root", func() error { return m.UploadFile(ctx, "/f.txt", strings.NewReader("x"), 1) }},
+		{"move a mount point", func() error { return m.MoveFile(ctx, "/alice", "/carol") }},
+		{"move to a mount point", func() error { return m.MoveFile(ctx, "/alice/f.txt", "/bob") }},
+		{"copy a mount point", func() error { return m.CopyFile(ctx, "/alice", "/bob/alice") }},
+	}
+	for _, tc := range testCases {
+		if err := tc.call(); !errors.Is(err, common.ErrForbidden) {
+			t.Errorf("%s: got %v, want ErrForbidden", tc.desc, err)
+		}
+	}
+	if got := readLocal(aliceRoot, "f.txt"); got != "alice" {
+		t.Errorf("f.txt of alice: got %q, want it kept", got)
+	}
+}
+
+func TestMountCrossAccount(t *testing.T) {
+	m, aliceRoot, bobRoot := newTestMount(t)
+	ctx := context.Background()
+
+	if err := m.MoveFile(ctx, "/alice/f.txt", "/bob/g.txt"); !errors.Is(err, common.ErrCrossAccount) {
+		t.Errorf("MoveFile between mounts: got %v, want ErrCrossAccount", err)
+	}
+	if err := m.CopyFile(ctx, "/alice/d", "/bob/d"); !errors.Is(err, common.ErrCrossAccount) {
+		t.Errorf("CopyFile between mounts: got %v, want ErrCrossAccount", err)
+	}
+	if readLocal(aliceRoot, "f.txt") != "alice" || readLocal(bobRoot, "g.txt") != "" || readLocal(bobRoot, "d/g.txt") != "" {
+		t.Errorf("the drives changed after the failed calls")
+	}
+
+	if err := m.MoveFile(ctx, "/alice/f.txt", "/alice/d/f.txt"); err != nil {
+		t.Errorf("MoveFile in a mount: %v", err)
+	}
+	if err := m.CopyFile(ctx, "/alice/d", "/alice/e"); err != nil {
+		t.Errorf("CopyFile in a mount: %v", err)
+	}
+	if got := readLocal(aliceRoot, "e/f.txt"); got != "alice" {
+		t.Errorf("e/f.txt of alice: got %q, want %q", got, "alice")
+	}
+}
+
+func TestMountIDs(t *testing.T) {
+	alice, _ := newLocalDrive(t, map[string]string{"f.txt": "alice"})
+	m := drive.NewMountClient()
+	// The same drive mounted twice has the same ids in both mounts.
+	for _, name := range []string{"a", "b"} {
+		if err := m.Mount(name, alice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+
+	fi, err := alice.GetFile(ctx, "/f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := alice.GetFile(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]string)
+	for _, name := range []string{"a", "b"} {
+		for p, id := range map[string]string{"/" + name + "/f.txt": fi.GetID(), "/" + name: root.GetID()} {
+			got, err := m.GetFile(ctx, p)
+			if err != nil {
+				t.Fatalf("GetFile(%q): %v", p, err)
+			}
+			if want := name + ":" + id; got.GetID() != want {
+				t.Errorf("GetFile(%q).GetID(): got %q, want %q", p, got.GetID(), want)
+			}
+			if other, ok := seen[got.GetID()]; ok {
+				t.Errorf("%s and %s have the same id", p, other)
+			}
+			seen[got.GetID()] = p
+		}
+	}
+	files, err := m.GetFiles(ctx, "/a")
+	if err != nil || len(files) != 1 || files[0].GetID() != "a:"+fi.GetID() {
+		t.Errorf("GetFiles(/a): got %v, %v, want f.txt with the id %q", files, err, "a:"+fi.GetID())
+	}
+}
@@ -0,0 +1,263 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+)
+
+var errReadOnlyLayer = fmt.Errorf("%w: the file is in a read only layer", common.ErrForbidden)
+
+// UnionClient is a DriveClient overlaying several drives, the layers, into
+// one tree. A path is the file of the first layer that has it, in the order
+// the layers were added, and a directory lists the files of that directory
+// in every layer, the first layer having a name winning. Changes go to one
+// layer, the upper layer, which is the first one unless set with SetUpper.
+//
+// Directories only in lower layers are created in the upper layer before
+// files are written below them. Files of the other layers are read only:
+// there are no whiteouts, so removing or moving them fails with
+// common.ErrForbidden, and writing to a path that a layer before the upper
+// layer has fails the same way, as the result would stay hidden. Copying
+// from them fails with common.ErrCrossAccount, as between the drives of a
+// MountClient.
+type UnionClient struct {
+	names  []string
+	layers map[string]DriveClient
+	upper  string
+}
+
+func NewUnionClient() *UnionClient {
+	return &UnionClient{
+		layers: make(map[string]DriveClient),
+	}
+}
+
+// AddLayer adds client as the layer name, below the layers added before.
+func (u *UnionClient) AddLayer(name string, client DriveClient) error {
+	if len(name) == 0 || strings.Contains(name, ":") {
+		return fmt.Errorf("invalid layer name: %s", name)
+	}
+	if _, ok := u.layers[name]; ok {
+		return fmt.Errorf("duplicate layer name: %s", name)
+	}
+	u.names = append(u.names, name)
+	u.layers[name] = client
+	if len(u.upper) == 0 {
+		u.upper = name
+	}
+	return nil
+}
+
+// SetUpper makes the layer name the one changes go to.
+func (u *UnionClient) SetUpper(name string) error {
+	if _, ok := u.layers[name]; !ok {
+		return fmt.Errorf("layer not found: %s", name)
+	}
+	u.upper = name
+	return nil
+}
+
+// find returns the file at p of the first layer that has it, and the index
+// of that layer.
+func (u *UnionClient) find(ctx context.Context, p string) (int, File, error) {
+	for i, name := range u.names {
+		fi, err := u.layers[name].GetFile(ctx, p)
+		if err == nil {
+			return i, &driveFile{File: fi, drive: name}, nil
+		}
+		if !errors.Is(err, common.ErrNotFound) {
+			return 0, nil, err
+		}
+	}
+	return 0, nil, common.ErrNotFound
+}
+
+func (u *UnionClient) upperIndex() int {
+	for i, name := range u.names {
+		if name == u.upper {
+			return i
+		}
+	}
+	return len(u.names)
+}
+
+func (u *UnionClient) GetFiles(ctx context.Context, dir string) ([]File, error) {
+	found := false
+	seen := make(map[string]bool)
+	result := make([]File, 0)
+	for _, name := range u.names {
+		files, err := u.layers[name].GetFiles(ctx, dir)
+		if errors.Is(err, common.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, fi := range files {
+			if seen[fi.GetName()] {
+				continue
+			}
+			seen[fi.GetName()] = true
+			result = append(result, &driveFile{File: fi, drive: name})
+		}
+	}
+	if !found {
+		return nil, common.ErrNotFound
+	}
+	return result, nil
+}
+
+func (u *UnionClient) GetFile(ctx context.Context, filePath string) (File, error) {
+	_, fi, err := u.find(ctx, filePath)
+	return fi, err
+}
+
+// checkUpperOnly checks that filePath is in the upper layer and in no other
+// layer, so that changing it in the upper layer changes the union.
+func (u *UnionClient) checkUpperOnly(ctx context.Context, filePath string) error {
+	for _, name := range u.names {
+		if name == u.upper {
+			continue
+		}
+		_, err := u.layers[name].GetFile(ctx, filePath)
+		if err == nil {
+			return errReadOnlyLayer
+		}
+		if !errors.Is(err, common.ErrNotFound) {
+			return err
+		}
+	}
+	_, err := u.layers[u.upper].GetFile(ctx, filePath)
+	return err
+}
+
+// checkNotShadowed checks that no layer before the upper layer has
+// filePath, which would hide what is written there in the upper layer.
+func (u *UnionClient) checkNotShadowed(ctx context.Context, filePath string) error {
+	for _, name := range u.names[:u.upperIndex()] {
+		_, err := u.layers[name].GetFile(ctx, filePath)
+		if err == nil {
+			return errReadOnlyLayer
+		}
+		if !errors.Is(err, common.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareWrite checks that filePath can be written in the upper layer, and
+// creates its parent directory there if only other layers have it.
+func (u *UnionClient) prepareWrite(ctx context.Context, filePath string) error {
+	if err := u.checkNotShadowed(ctx, filePath); err != nil {
+		return err
+	}
+	return u.copyUpDir(ctx, path.Dir(path.Clean("/"+filePath)))
+}
+
+// copyUpDir creates dir in the upper layer, with its missing parents, when
+// it is a directory of the union.
+func (u *UnionClient) copyUpDir(ctx context.Context, dir string) error {
+	upper := u.layers[u.upper]
+	fi, err := upper.GetFile(ctx, dir)
+	if err == nil {
+		if !fi.IsDir() {
+			return common.ErrNotFound
+		}
+		return nil
+	}
+	if !errors.Is(err, common.ErrNotFound) {
+		return err
+	}
+
+	if fi, err = u.GetFile(ctx, dir); err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return common.ErrNotFound
+	}
+	if err := u.copyUpDir(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+	if err := upper.MakeDir(ctx, dir); err != nil && !errors.Is(err, common.ErrExists) {
+		return err
+	}
+	return nil
+}
+
+func (u *UnionClient) RemoveFile(ctx context.Context, filePath string) error {
+	if err := u.checkUpperOnly(ctx, filePath); err != nil {
+		return err
+	}
+	return u.layers[u.upper].RemoveFile(ctx, filePath)
+}
+
+func (u *UnionClient) MoveFile(ctx context.Context, srcPath string, dstPath string) error {
+	if err := u.checkUpperOnly(ctx, srcPath); err != nil {
+		return err
+	}
+	if err := u.prepareWrite(ctx, dstPath); err != nil {
+		return err
+	}
+	return u.layers[u.upper].MoveFile(ctx, srcPath, dstPath)
+}
+
+// CopyFile copies srcPath within the upper layer. A file must be the one of
+// the upper layer, and a directory must be in no other layer, as its files
+// there would not be copied.
+func (u *UnionClient) CopyFile(ctx context.Context, srcPath string, dstPath string) error {
+	_, fi, err := u.find(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	if fi.(*driveFile).drive != u.upper {
+		return common.ErrCrossAccount
+	}
+	if fi.IsDir() {
+		if err := u.checkUpperOnly(ctx, srcPath); errors.Is(err, errReadOnlyLayer) {
+			return common.ErrCrossAccount
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := u.prepareWrite(ctx, dstPath); err != nil {
+		return err
+	}
+	return u.layers[u.upper].CopyFile(ctx, srcPath, dstPath)
+}
+
+func (u *UnionClient) MakeDir(ctx context.Context, dir string) error {
+	if _, _, err := u.find(ctx, dir); err == nil {
+		return common.ErrExists
+	} else if !errors.Is(err, common.ErrNotFound) {
+		return err
+	}
+	if err := u.prepareWrite(ctx, dir); err != nil {
+		return err
+	}
+	return u.layers[u.upper].MakeDir(ctx, dir)
+}
+
+func (u *UnionClient) UploadFile(ctx context.Context, filePath string, r io.Reader, size int64) error {
+	if err := u.prepareWrite(ctx, filePath); err != nil {
+		return err
+	}
+	return u.layers[u.upper].UploadFile(ctx, filePath, r, size)
+}
+
+func (u *UnionClient) ServeContent(w http.ResponseWriter, req *http.Request, fi File) {
+	uf, ok := fi.(*driveFile)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	u.layers[uf.drive].ServeContent(w, req, uf.File)
+}
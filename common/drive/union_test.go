@@ -0,0 +1,206 @@
+package drive_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/gaoyb7/115drive-webdav/common/drive"
+)
+
+// newTestUnion returns a union of the layers upper and lower, in this
+// order, and their local dirs.
+func newTestUnion(t *testing.T) (*drive.UnionClient, string, string) {
+	t.Helper()
+	upper, upperRoot := newLocalDrive(t, map[string]string{
+		"f.txt":   "upper",
+		"d/x.txt": "x",
+		"u/u.txt": "u",
+	})
+	lower, lowerRoot := newLocalDrive(t, map[string]string{
+		"f.txt":     "lower",
+		"g.txt":     "g",
+		"d/y.txt":   "y",
+		"e/z.txt":   "z",
+		"e/s/w.txt": "w",
+	})
+	u := drive.NewUnionClient()
+	if err := u.AddLayer("upper", upper); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.AddLayer("lower", lower); err != nil {
+		t.Fatal(err)
+	}
+	return u, upperRoot, lowerRoot
+}
+
+func TestUnion(t *testing.T) {
+	u, _, _ := newTestUnion(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"", "a:b", "upper"} {
+		if err := u.AddLayer(name, drive.NewUnionClient()); err == nil {
+			t.Errorf("AddLayer(%q): got no error", name)
+		}
+	}
+	if err := u.SetUpper("nope"); err == nil {
+		t.Errorf("SetUpper of a missing layer: got no error")
+	}
+
+	for dir, want := range map[string]string{"/": "d e f.txt g.txt u", "/d": "x.txt y.txt", "/e": "s z.txt"} {
+		files, err := u.GetFiles(ctx, dir)
+		if err != nil || names(files) != want {
+			t.Errorf("GetFiles(%q): got %q, %v, want %q", dir, names(files), err, want)
+		}
+	}
+	if _, err := u.GetFiles(ctx, "/nope"); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("GetFiles of a missing dir: got %v, want ErrNotFound", err)
+	}
+
+	// The file of the first layer having it wins, in listings too.
+	fi, err := u.GetFile(ctx, "/f.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if got := serve(u, fi); got != "upper" {
+		t.Errorf("ServeContent of f.txt: got %q, want the one of upper", got)
+	}
+	if !strings.HasPrefix(fi.GetID(), "upper:") {
+		t.Errorf("GetFile(f.txt).GetID(): got %q, want the id of upper", fi.GetID())
+	}
+	files, err := u.GetFiles(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f.GetName() == "f.txt" && f.GetID() != fi.GetID() {
+			t.Errorf("GetFiles: got f.txt with the id %q, want %q", f.GetID(), fi.GetID())
+		}
+	}
+	fi, err = u.GetFile(ctx, "/g.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if got := serve(u, fi); got != "g" || !strings.HasPrefix(fi.GetID(), "lower:") {
+		t.Errorf("ServeContent of g.txt: got %q with the id %q, want the one of lower", got, fi.GetID())
+	}
+}
+
+func TestUnionReadOnlyLayers(t *testing.T) {
+	u, upperRoot, lowerRoot := newTestUnion(t)
+	ctx := context.Background()
+
+	testCases := []struct {
+		desc string
+		call func() error
+		want error
+	}{
+		{"remove a file of lower", func() error { return u.RemoveFile(ctx, "/g.txt") }, common.ErrForbidden},
+		{"remove a file of both layers", func() error { return u.RemoveFile(ctx, "/f.txt") }, common.ErrForbidden},
+		{"remove a dir of both layers", func() error { return u.RemoveFile(ctx, "/d") }, common.ErrForbidden},
+		{"move a file of lower", func() error { return u.MoveFile(ctx, "/g.txt", "/h.txt") }, common.ErrForbidden},
+		{"copy a file of lower", func() error { return u.CopyFile(ctx, "/g.txt", "/h.txt") }, common.ErrCrossAccount},
+		{"copy a dir of both layers", func() error { return u.CopyFile(ctx, "/d", "/d2") }, common.ErrCrossAccount},
+		{"make an existing dir", func() error { return u.MakeDir(ctx, "/e") }, common.ErrExists},
+	}
+	for _, tc := range testCases {
+		if err := tc.call(); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.desc, err, tc.want)
+		}
+	}
+	if readLocal(lowerRoot, "g.txt") != "g" || readLocal(lowerRoot, "f.txt") != "lower" || readLocal(upperRoot, "h.txt") != "" {
+		t.Errorf("the layers changed after the failed calls")
+	}
+
+	// The files of upper alone can be changed.
+	if err := u.CopyFile(ctx, "/u", "/v"); err != nil {
+		t.Errorf("CopyFile of a dir of upper: %v", err)
+	}
+	if err := u.MoveFile(ctx, "/u/u.txt", "/u/t.txt"); err != nil {
+		t.Errorf("MoveFile of a file of upper: %v", err)
+	}
+	if err := u.RemoveFile(ctx, "/d/x.txt"); err != nil {
+		t.Errorf("RemoveFile of a file of upper: %v", err)
+	}
+	if readLocal(upperRoot, "v/u.txt") != "u" || readLocal(upperRoot, "u/t.txt") != "u" || readLocal(upperRoot, "d/x.txt") != "" {
+		t.Errorf("upper did not change as expected")
+	}
+}
+
+func TestUnionCopyUpDir(t *testing.T) {
+	u, upperRoot, lowerRoot := newTestUnion(t)
+	ctx := context.Background()
+
+	// e/s is only a dir of lower, so it is created in upper first.
+	if err := u.UploadFile(ctx, "/e/s/new.txt", strings.NewReader("new"), 3); err != nil {
+		t.Fatalf("UploadFile below a dir of lower: %v", err)
+	}
+	if got := readLocal(upperRoot, "e/s/new.txt"); got != "new" {
+		t.Errorf("e/s/new.txt of upper: got %q, want %q", got, "new")
+	}
+	if got := readLocal(lowerRoot, "e/s/new.txt"); got != "" {
+		t.Errorf("e/s/new.txt of lower: got %q, want none", got)
+	}
+	files, err := u.GetFiles(ctx, "/e/s")
+	if err != nil || names(files) != "new.txt w.txt" {
+		t.Errorf("GetFiles(/e/s): got %q, %v, want new.txt w.txt", names(files), err)
+	}
+
+	if err := u.MakeDir(ctx, "/e/n"); err != nil {
+		t.Errorf("MakeDir below a dir of lower: %v", err)
+	}
+	if err := u.MoveFile(ctx, "/u", "/e/n/u"); err != nil {
+		t.Errorf("MoveFile to a dir of lower: %v", err)
+	}
+	if got := readLocal(upperRoot, "e/n/u/u.txt"); got != "u" {
+		t.Errorf("e/n/u/u.txt of upper: got %q, want %q", got, "u")
+	}
+
+	for _, p := range []string{"/nope/f.txt", "/g.txt/f.txt"} {
+		if err := u.UploadFile(ctx, p, strings.NewReader("x"), 1); !errors.Is(err, common.ErrNotFound) {
+			t.Errorf("UploadFile(%q): got %v, want ErrNotFound", p, err)
+		}
+	}
+}
+
+func TestUnionShadowing(t *testing.T) {
+	u, upperRoot, lowerRoot := newTestUnion(t)
+	ctx := context.Background()
+	if err := u.SetUpper("lower"); err != nil {
+		t.Fatal(err)
+	}
+
+	// What upper has hides the same paths of lower, so they are not written.
+	testCases := []struct {
+		desc string
+		call func() error
+	}{
+		{"upload", func() error { return u.UploadFile(ctx, "/f.txt", strings.NewReader("x"), 1) }},
+		{"move", func() error { return u.MoveFile(ctx, "/g.txt", "/d/x.txt") }},
+		{"copy", func() error { return u.CopyFile(ctx, "/g.txt", "/u") }},
+	}
+	for _, tc := range testCases {
+		if err := tc.call(); !errors.Is(err, common.ErrForbidden) {
+			t.Errorf("%s to a path of upper: got %v, want ErrForbidden", tc.desc, err)
+		}
+	}
+	if readLocal(lowerRoot, "f.txt") != "lower" || readLocal(lowerRoot, "g.txt") != "g" {
+		t.Errorf("lower changed after the failed calls")
+	}
+
+	if err := u.UploadFile(ctx, "/new.txt", strings.NewReader("new"), 3); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if readLocal(lowerRoot, "new.txt") != "new" || readLocal(upperRoot, "new.txt") != "" {
+		t.Errorf("UploadFile did not write to lower only")
+	}
+	// Of the files of lower, only the ones upper does not have can be removed.
+	if err := u.RemoveFile(ctx, "/g.txt"); err != nil {
+		t.Errorf("RemoveFile of a file of lower: %v", err)
+	}
+	if err := u.RemoveFile(ctx, "/f.txt"); !errors.Is(err, common.ErrForbidden) {
+		t.Errorf("RemoveFile of a file of both layers: got %v, want ErrForbidden", err)
+	}
+}
@@ -3,5 +3,25 @@ package common
 import "errors"
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound     = errors.New("not found")
+	ErrCrossAccount = errors.New("source and destination are in different accounts")
+
+	// ErrExists is returned when the target of an operation already exists.
+	ErrExists = errors.New("already exists")
+	// ErrNameConflict is returned when a name is taken by another file in
+	// the way of an operation.
+	ErrNameConflict = errors.New("name conflict")
+	// ErrInvalidName is returned for a name the drive does not accept.
+	ErrInvalidName = errors.New("invalid name")
+	// ErrQuotaExceeded is returned when the drive is out of space.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrLoginExpired is returned when the drive session is not logged in.
+	ErrLoginExpired = errors.New("login expired")
+	// ErrRateLimited is returned when the drive throttles requests.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrForbidden is returned for an operation the drive refuses.
+	ErrForbidden = errors.New("forbidden")
+	// ErrLocked is returned for a file the drive holds locked, e.g. while
+	// another operation on it is running.
+	ErrLocked = errors.New("locked")
 )
@@ -0,0 +1,285 @@
+// Package jsonedit sets members of the objects of a JSON document in place,
+// keeping the order of the other members, the indentation and the spacing,
+// so that a file written by hand stays as it was written.
+package jsonedit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Member is a member of a JSON object, Value is encoded with json.Marshal.
+type Member struct {
+	Name  string
+	Value interface{}
+}
+
+// Object is a JSON object with its members in order.
+type Object []Member
+
+func (o Object) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(m.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// span is the position of a value in a document, from start to end.
+type span struct {
+	start, end int
+}
+
+// edit replaces the bytes of a span of a document with text.
+type edit struct {
+	span
+	text []byte
+}
+
+// SetMembers returns data, a JSON document whose value is an object, with
+// members set in that object. Members it has are replaced in place, the
+// others are added after its last member.
+func SetMembers(data []byte, members []Member) ([]byte, error) {
+	doc, err := document(data)
+	if err != nil {
+		return nil, err
+	}
+	edits, err := setMembers(data, doc, members)
+	if err != nil {
+		return nil, err
+	}
+	return apply(data, edits), nil
+}
+
+// SetElementMembers returns data, a JSON document whose value is an object,
+// with members set in the element of its array member named array that has
+// the member key of value, as SetMembers does. The element is added to the
+// array if there is none, with key first, and the array to the object.
+func SetElementMembers(data []byte, array string, key string, value string, members []Member) ([]byte, error) {
+	doc, err := document(data)
+	if err != nil {
+		return nil, err
+	}
+	elem := append(Object{{Name: key, Value: value}}, members...)
+	values, _, err := objectMembers(data, doc)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := values[array]
+	if !ok {
+		edits, err := setMembers(data, doc, []Member{{Name: array, Value: []interface{}{elem}}})
+		if err != nil {
+			return nil, err
+		}
+		return apply(data, edits), nil
+	}
+	if data[arr.start] != '[' {
+		return nil, fmt.Errorf("%s is not an array", array)
+	}
+
+	elems, err := arrayElements(data, arr)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range elems {
+		if data[e.start] != '{' {
+			continue
+		}
+		values, _, err := objectMembers(data, e)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := values[key]
+		if !ok {
+			continue
+		}
+		s := ""
+		if json.Unmarshal(data[v.start:v.end], &s) != nil || s != value {
+			continue
+		}
+		edits, err := setMembers(data, e, members)
+		if err != nil {
+			return nil, err
+		}
+		return apply(data, edits), nil
+	}
+
+	if len(elems) == 0 {
+		text, err := json.MarshalIndent([]interface{}{elem}, lineIndent(data, arr.start), "\t")
+		if err != nil {
+			return nil, err
+		}
+		return apply(data, []edit{{arr, text}}), nil
+	}
+	last := elems[len(elems)-1]
+	text, err := separated(data, arr, last, elem)
+	if err != nil {
+		return nil, err
+	}
+	return apply(data, []edit{{span{last.end, last.end}, text}}), nil
+}
+
+// document returns the span of the object that is the value of data.
+func document(data []byte) (span, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	raw := json.RawMessage{}
+	if err := dec.Decode(&raw); err != nil {
+		return span{}, err
+	}
+	end := int(dec.InputOffset())
+	s := span{end - len(raw), end}
+	if data[s.start] != '{' {
+		return span{}, fmt.Errorf("the document is not an object")
+	}
+	return s, nil
+}
+
+// objectMembers returns the spans of the values of the members of the
+// object at obj, and the span of its last value.
+func objectMembers(data []byte, obj span) (map[string]span, span, error) {
+	dec := json.NewDecoder(bytes.NewReader(data[obj.start:obj.end]))
+	if _, err := dec.Token(); err != nil {
+		return nil, span{}, err
+	}
+	values := make(map[string]span)
+	last := span{-1, -1}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, span{}, err
+		}
+		name, _ := tok.(string)
+		raw := json.RawMessage{}
+		if err := dec.Decode(&raw); err != nil {
+			return nil, span{}, err
+		}
+		end := obj.start + int(dec.InputOffset())
+		last = span{end - len(raw), end}
+		values[name] = last
+	}
+	return values, last, nil
+}
+
+// arrayElements returns the spans of the elements of the array at arr.
+func arrayElements(data []byte, arr span) ([]span, error) {
+	dec := json.NewDecoder(bytes.NewReader(data[arr.start:arr.end]))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var elems []span
+	for dec.More() {
+		raw := json.RawMessage{}
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		end := arr.start + int(dec.InputOffset())
+		elems = append(elems, span{end - len(raw), end})
+	}
+	return elems, nil
+}
+
+// setMembers returns the edits setting members in the object at obj.
+func setMembers(data []byte, obj span, members []Member) ([]edit, error) {
+	values, last, err := objectMembers(data, obj)
+	if err != nil {
+		return nil, err
+	}
+	var edits []edit
+	var missing Object
+	for _, m := range members {
+		v, ok := values[m.Name]
+		if !ok {
+			missing = append(missing, m)
+			continue
+		}
+		text, err := json.MarshalIndent(m.Value, lineIndent(data, v.start), "\t")
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit{v, text})
+	}
+	if len(missing) == 0 {
+		return edits, nil
+	}
+
+	if last.start < 0 {
+		text, err := json.MarshalIndent(missing, lineIndent(data, obj.start), "\t")
+		if err != nil {
+			return nil, err
+		}
+		return append(edits, edit{obj, text}), nil
+	}
+	var text []byte
+	for _, m := range missing {
+		t, err := separated(data, obj, last, m)
+		if err != nil {
+			return nil, err
+		}
+		text = append(text, t...)
+	}
+	return append(edits, edit{span{last.end, last.end}, text}), nil
+}
+
+// separated returns v, a member or an element to add after last, the last
+// one of the object or array at parent, with the separator before it,
+// indented as last is.
+func separated(data []byte, parent span, last span, v interface{}) ([]byte, error) {
+	indent := lineIndent(data, last.start)
+	sep := ", "
+	if bytes.IndexByte(data[parent.start:parent.end], '\n') >= 0 {
+		sep = ",\n" + indent
+	}
+	buf := bytes.NewBufferString(sep)
+	if m, ok := v.(Member); ok {
+		name, err := json.Marshal(m.Name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteString(": ")
+		v = m.Value
+	}
+	text, err := json.MarshalIndent(v, indent, "\t")
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(text)
+	return buf.Bytes(), nil
+}
+
+// lineIndent returns the spaces and tabs starting the line of data at pos.
+func lineIndent(data []byte, pos int) string {
+	start := bytes.LastIndexByte(data[:pos], '\n') + 1
+	end := start
+	for end < pos && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+// apply returns data with edits made, which must not overlap.
+func apply(data []byte, edits []edit) []byte {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := append([]byte(nil), data...)
+	for _, e := range edits {
+		out = append(out[:e.start:e.start], append(e.text, out[e.end:]...)...)
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package jsonedit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var cookies = []Member{{"uid", "u"}, {"cid", "c"}}
+
+func TestSetMembers(t *testing.T) {
+	testCases := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{
+			"replaced in place",
+			"{\n  \"port\": 80,\n  \"cid\":   \"old\" ,\n  \"uid\": \"old\"\n}\n",
+			"{\n  \"port\": 80,\n  \"cid\":   \"c\" ,\n  \"uid\": \"u\"\n}\n",
+		},
+		{
+			"added after the last member",
+			"{\n\t\"port\": 80,\n\t\"users\": [\n\t\t{\"name\": \"a\"}\n\t]\n}",
+			"{\n\t\"port\": 80,\n\t\"users\": [\n\t\t{\"name\": \"a\"}\n\t],\n\t\"uid\": \"u\",\n\t\"cid\": \"c\"\n}",
+		},
+		{
+			"single line",
+			`{"port": 80, "uid": "old"}`,
+			`{"port": 80, "uid": "u", "cid": "c"}`,
+		},
+		{
+			"empty object",
+			"{}\n",
+			"{\n\t\"uid\": \"u\",\n\t\"cid\": \"c\"\n}\n",
+		},
+	}
+	for _, tc := range testCases {
+		got, err := SetMembers([]byte(tc.data), cookies)
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.desc, got, tc.want)
+		}
+		if !json.Valid(got) {
+			t.Errorf("%s: got invalid JSON %s", tc.desc, got)
+		}
+	}
+}
+
+func TestSetElementMembers(t *testing.T) {
+	testCases := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{
+			"element found",
+			"{\n\t\"accounts\": [\n\t\t{\"name\": \"a\", \"uid\": \"old\"},\n\t\t{\n\t\t\t\"name\": \"b\",\n\t\t\t\"uid\": \"old\"\n\t\t}\n\t]\n}",
+			"{\n\t\"accounts\": [\n\t\t{\"name\": \"a\", \"uid\": \"old\"},\n\t\t{\n\t\t\t\"name\": \"b\",\n\t\t\t\"uid\": \"u\",\n\t\t\t\"cid\": \"c\"\n\t\t}\n\t]\n}",
+		},
+		{
+			"element added",
+			"{\n\t\"accounts\": [\n\t\t{\"name\": \"a\"}\n\t]\n}",
+			"{\n\t\"accounts\": [\n\t\t{\"name\": \"a\"},\n\t\t{\n\t\t\t\"name\": \"b\",\n\t\t\t\"uid\": \"u\",\n\t\t\t\"cid\": \"c\"\n\t\t}\n\t]\n}",
+		},
+		{
+			"empty array",
+			"{\n\t\"accounts\": []\n}",
+			"{\n\t\"accounts\": [\n\t\t{\n\t\t\t\"name\": \"b\",\n\t\t\t\"uid\": \"u\",\n\t\t\t\"cid\": \"c\"\n\t\t}\n\t]\n}",
+		},
+		{
+			"array added",
+			"{\n\t\"port\": 80\n}",
+			"{\n\t\"port\": 80,\n\t\"accounts\": [\n\t\t{\n\t\t\t\"name\": \"b\",\n\t\t\t\"uid\": \"u\",\n\t\t\t\"cid\": \"c\"\n\t\t}\n\t]\n}",
+		},
+	}
+	for _, tc := range testCases {
+		got, err := SetElementMembers([]byte(tc.data), "accounts", "name", "b", cookies)
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.desc, got, tc.want)
+		}
+	}
+
+	for _, data := range []string{"", "[]", `{"accounts": {}}`, `{"port": 80`} {
+		if _, err := SetElementMembers([]byte(data), "accounts", "name", "b", cookies); err == nil {
+			t.Errorf("SetElementMembers(%q): got no error", data)
+		}
+	}
+}
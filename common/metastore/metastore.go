@@ -0,0 +1,328 @@
+// Package metastore implements a persistent key value store for drive
+// metadata, such as directory listings, so that it survives restarts.
+//
+// Values are kept in memory encoded as JSON. Every change is appended to a
+// journal file as it is made, so that a crash of the process loses none of
+// them, and Save syncs the journal to disk. Once the journal has grown to
+// several times the size of the store, Save compacts it into one record per
+// key.
+package metastore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common/atomicfile"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fileVersion is the version of the journal. Version 1 was a single
+	// snapshot of the store, which is still loaded.
+	fileVersion = 2
+	// compactMinRecords is the number of records below which the journal
+	// is never compacted.
+	compactMinRecords = 1000
+)
+
+// Store is a view of the store with keys under a prefix, see Sub.
+type Store struct {
+	db     *db
+	prefix string
+}
+
+type db struct {
+	filename string
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// journal is the journal file opened for appending, records the number
+	// of records in it.
+	journal *os.File
+	records int
+}
+
+type entry struct {
+	Value     json.RawMessage `json:"value"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// header is the first line of the journal.
+type header struct {
+	Version int `json:"version"`
+	// Entries are the entries of a file of version 1.
+	Entries map[string]*entry `json:"entries,omitempty"`
+}
+
+// record is a line of the journal after its header, a put of Key when it
+// has a Value, a delete of the keys starting with Prefix when it has one,
+// or else a delete of Key.
+type record struct {
+	Key       string          `json:"key,omitempty"`
+	Prefix    string          `json:"prefix,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Open returns the store saved in filename, which is created if it does not
+// exist. A file written by an incompatible version is ignored, the store
+// then starts empty.
+func Open(filename string) (*Store, error) {
+	d := &db{
+		filename: filename,
+		entries:  make(map[string]*entry),
+	}
+	compact, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	if compact {
+		err = d.compact()
+	} else {
+		err = d.openJournal()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: d}, nil
+}
+
+// load replays the journal, and reports whether it must be rewritten before
+// records are appended to it.
+func (d *db) load() (compact bool, err error) {
+	f, err := os.Open(d.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	h := header{}
+	if err := json.Unmarshal(line, &h); err != nil {
+		return false, fmt.Errorf("call json.Unmarshal fail, filename: %s, err: %v", d.filename, err)
+	}
+	switch h.Version {
+	case 1:
+		for key, e := range h.Entries {
+			if e != nil {
+				d.entries[key] = e
+			}
+		}
+		return true, nil
+	case fileVersion:
+	default:
+		logrus.Warnf("ignore metadata file of version %d, filename: %s", h.Version, d.filename)
+		return true, nil
+	}
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(bytes.TrimSpace(line)) == 0 {
+			return false, nil
+		}
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		// A record cut short by a crash, or by a failed write that later
+		// records were appended to. The metadata is a cache, the records
+		// from there on are dropped.
+		rec := record{}
+		if err == io.EOF || json.Unmarshal(line, &rec) != nil {
+			logrus.Warnf("drop the metadata file from its incomplete record %d, filename: %s", d.records+1, d.filename)
+			return true, nil
+		}
+		d.apply(rec)
+		d.records++
+	}
+}
+
+// apply applies rec to the entries. The caller must hold d.mu, or be the
+// only user of d.
+func (d *db) apply(rec record) {
+	switch {
+	case rec.Value != nil:
+		d.entries[rec.Key] = &entry{Value: rec.Value, UpdatedAt: rec.UpdatedAt}
+	case len(rec.Prefix) > 0:
+		for key := range d.entries {
+			if strings.HasPrefix(key, rec.Prefix) {
+				delete(d.entries, key)
+			}
+		}
+	default:
+		delete(d.entries, rec.Key)
+	}
+}
+
+func (d *db) openJournal() error {
+	f, err := os.OpenFile(d.filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	d.journal = f
+	return nil
+}
+
+// write applies rec and appends it to the journal. The caller must hold
+// d.mu.
+func (d *db) write(rec record) error {
+	d.apply(rec)
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := d.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write metadata file fail, filename: %s, err: %v", d.filename, err)
+	}
+	d.records++
+	return nil
+}
+
+// compact replaces the journal with a header and a record per entry. The
+// caller must hold d.mu, or be the only user of d.
+func (d *db) compact() error {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(header{Version: fileVersion}); err != nil {
+		return err
+	}
+	for key, e := range d.entries {
+		if err := enc.Encode(record{Key: key, Value: e.Value, UpdatedAt: e.UpdatedAt}); err != nil {
+			return err
+		}
+	}
+	if err := atomicfile.WriteFile(d.filename, buf.Bytes()); err != nil {
+		return err
+	}
+	if d.journal != nil {
+		d.journal.Close()
+		d.journal = nil
+	}
+	d.records = len(d.entries)
+	return d.openJournal()
+}
+
+// Sub returns a view of s whose keys are prefixed with prefix, so that
+// several users of the store do not clash.
+func (s *Store) Sub(prefix string) *Store {
+	return &Store{db: s.db, prefix: s.prefix + prefix}
+}
+
+// Get decodes the value of key into v, and returns the time it was put. ok
+// is false if key is missing or its value does not decode into v.
+func (s *Store) Get(key string, v interface{}) (updatedAt time.Time, ok bool) {
+	s.db.mu.Lock()
+	e, found := s.db.entries[s.prefix+key]
+	s.db.mu.Unlock()
+	if !found {
+		return time.Time{}, false
+	}
+	if err := json.Unmarshal(e.Value, v); err != nil {
+		logrus.WithError(err).Warnf("call json.Unmarshal fail, key: %s", s.prefix+key)
+		return time.Time{}, false
+	}
+	return e.UpdatedAt, true
+}
+
+// Put sets the value of key to v, encoded as JSON.
+func (s *Store) Put(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return s.db.write(record{Key: s.prefix + key, Value: data, UpdatedAt: time.Now()})
+}
+
+// Keys returns the keys of s starting with prefix, without the prefix of s.
+func (s *Store) Keys(prefix string) []string {
+	prefix = s.prefix + prefix
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	keys := make([]string, 0)
+	for key := range s.db.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, strings.TrimPrefix(key, s.prefix))
+		}
+	}
+	return keys
+}
+
+// Delete removes key.
+func (s *Store) Delete(key string) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.entries[s.prefix+key]; !ok {
+		return
+	}
+	if err := s.db.write(record{Key: s.prefix + key}); err != nil {
+		logrus.WithError(err).Errorf("call s.db.write fail, key: %s", s.prefix+key)
+	}
+}
+
+// DeletePrefix removes the keys starting with prefix, an empty prefix
+// removes every key of s.
+func (s *Store) DeletePrefix(prefix string) {
+	prefix = s.prefix + prefix
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if len(prefix) == 0 {
+		// Every key of the store, which the journal has no record for.
+		s.db.entries = make(map[string]*entry)
+		if err := s.db.compact(); err != nil {
+			logrus.WithError(err).Errorf("call s.db.compact fail, filename: %s", s.db.filename)
+		}
+		return
+	}
+	found := false
+	for key := range s.db.entries {
+		if strings.HasPrefix(key, prefix) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	if err := s.db.write(record{Prefix: prefix}); err != nil {
+		logrus.WithError(err).Errorf("call s.db.write fail, prefix: %s", prefix)
+	}
+}
+
+// Save syncs the journal of the whole store, not only of the keys of s, to
+// disk, compacting it first if it holds more than twice as many records as
+// there are keys.
+func (s *Store) Save() error {
+	d := s.db
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.records > compactMinRecords && d.records > 2*len(d.entries) {
+		return d.compact()
+	}
+	return d.journal.Sync()
+}
+
+// SaveEvery saves the store every interval. It never returns.
+func (s *Store) SaveEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.Save(); err != nil {
+			logrus.WithError(err).Errorf("call s.Save fail, filename: %s", s.db.filename)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package metastore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func tempFile(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "metastore-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "metadata.json")
+}
+
+func mustOpen(t *testing.T, filename string) *Store {
+	t.Helper()
+	s, err := Open(filename)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.db.journal.Close() })
+	return s
+}
+
+// keys returns the sorted keys of s with their values.
+func keys(s *Store) string {
+	var kv []string
+	for _, key := range s.Keys("") {
+		v := ""
+		s.Get(key, &v)
+		kv = append(kv, key+"="+v)
+	}
+	sort.Strings(kv)
+	return strings.Join(kv, " ")
+}
+
+func TestReopen(t *testing.T) {
+	filename := tempFile(t)
+	s := mustOpen(t, filename)
+	a, b := s.Sub("a:"), s.Sub("b:")
+	for _, key := range []string{"x", "y", "dir/1", "dir/2", "other"} {
+		if err := a.Put(key, "a"+key); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put(key, "b"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Put("x", "new ax")
+	a.Delete("y")
+	a.Delete("missing")
+	a.DeletePrefix("dir/")
+	b.DeletePrefix("")
+
+	want := "a:other=aother a:x=new ax"
+	if got := keys(s); got != want {
+		t.Fatalf("keys: got %q, want %q", got, want)
+	}
+	// Without a Save, as after a crash of the process.
+	if got := keys(mustOpen(t, filename)); got != want {
+		t.Errorf("keys after a reopen: got %q, want %q", got, want)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(mustOpen(t, filename)); got != want {
+		t.Errorf("keys after a save and a reopen: got %q, want %q", got, want)
+	}
+
+	s.DeletePrefix("")
+	s.Put("z", "z")
+	if got := keys(mustOpen(t, filename)); got != "z=z" {
+		t.Errorf("keys after deleting everything: got %q, want %q", got, "z=z")
+	}
+}
+
+func TestGetUpdatedAt(t *testing.T) {
+	filename := tempFile(t)
+	s := mustOpen(t, filename)
+	s.Put("k", []int{1, 2})
+	updatedAt, ok := s.Get("k", new([]int))
+	if !ok || updatedAt.IsZero() {
+		t.Fatalf("Get: got %v, %v", updatedAt, ok)
+	}
+	v := []int{}
+	reopenedAt, ok := mustOpen(t, filename).Get("k", &v)
+	if !ok || !reopenedAt.Equal(updatedAt) || len(v) != 2 {
+		t.Errorf("Get after a reopen: got %v, %v, %v, want %v", v, reopenedAt, ok, updatedAt)
+	}
+	if _, ok := s.Get("k", new(string)); ok {
+		t.Errorf("Get into a value of another type: got ok")
+	}
+	if _, ok := s.Get("missing", &v); ok {
+		t.Errorf("Get of a missing key: got ok")
+	}
+}
+
+func TestIncompleteRecord(t *testing.T) {
+	filename := tempFile(t)
+	s := mustOpen(t, filename)
+	s.Put("a", "a")
+	s.Put("b", "b")
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tail := range []string{`{"key":"c","val`, `{"key":"c","value":"c","updated_at":"2020-01-01T00:00:00Z"}`} {
+		if err := ioutil.WriteFile(filename, append(data[:len(data):len(data)], tail...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s := mustOpen(t, filename)
+		if got := keys(s); got != "a=a b=b" {
+			t.Errorf("keys with the incomplete record %s: got %q, want %q", tail, got, "a=a b=b")
+		}
+		// The journal is rewritten, records are appended after the
+		// complete ones.
+		s.Put("d", "d")
+		if got := keys(mustOpen(t, filename)); got != "a=a b=b d=d" {
+			t.Errorf("keys after a put: got %q, want %q", got, "a=a b=b d=d")
+		}
+	}
+
+	// A record written after a failed write.
+	if err := ioutil.WriteFile(filename, append(data[:len(data):len(data)], `{"key":"c"{"key":"d","value":"d","updated_at":"2020-01-01T00:00:00Z"}`+"\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(mustOpen(t, filename)); got != "a=a b=b" {
+		t.Errorf("keys with a broken record: got %q, want %q", got, "a=a b=b")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	filename := tempFile(t)
+	s := mustOpen(t, filename)
+	for i := 0; i < 3*compactMinRecords; i++ {
+		s.Put(fmt.Sprintf("k%d", i%10), fmt.Sprintf("v%d", i))
+	}
+	before, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size()*100 > before.Size() {
+		t.Errorf("Save: got a journal of %d bytes from %d, want it compacted", after.Size(), before.Size())
+	}
+	if s.db.records != 10 {
+		t.Errorf("Save: got %d records, want 10", s.db.records)
+	}
+
+	s.Put("k0", "last")
+	r := mustOpen(t, filename)
+	if got, want := len(r.Keys("")), 10; got != want {
+		t.Errorf("keys after a compaction: got %d, want %d", got, want)
+	}
+	v := ""
+	if r.Get("k0", &v); v != "last" {
+		t.Errorf("k0 after a compaction: got %q, want %q", v, "last")
+	}
+	if r.Get("k9", &v); v != fmt.Sprintf("v%d", 3*compactMinRecords-1) {
+		t.Errorf("k9 after a compaction: got %q", v)
+	}
+}
+
+func TestOpenVersions(t *testing.T) {
+	filename := tempFile(t)
+	v1 := `{"version":1,"entries":{"a":{"value":"a","updated_at":"2020-01-01T00:00:00Z"},"b":{"value":"b","updated_at":"2020-01-01T00:00:00Z"}}}`
+	if err := ioutil.WriteFile(filename, []byte(v1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := mustOpen(t, filename)
+	if got := keys(s); got != `a=a b=b` {
+		t.Errorf("keys of a file of version 1: got %q", got)
+	}
+	s.Put("c", "c")
+	if got := keys(mustOpen(t, filename)); got != `a=a b=b c=c` {
+		t.Errorf("keys after a put to a file of version 1: got %q", got)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(`{"version":99}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(mustOpen(t, filename)); got != "" {
+		t.Errorf("keys of a file of an unknown version: got %q, want none", got)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filename); err == nil {
+		t.Errorf("Open of an invalid file: got no error")
+	}
+}
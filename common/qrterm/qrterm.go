@@ -0,0 +1,104 @@
+package qrterm
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/png"
+	"strings"
+)
+
+const (
+	// finderSize is the width in modules of the finder pattern in the top
+	// left corner of a QR code.
+	finderSize = 7
+	quietZone  = 2
+
+	black = "\033[40m  \033[0m"
+	white = "\033[47m  \033[0m"
+)
+
+// Render decodes an image of a QR code, such as the PNG returned by 115, and
+// draws it with ANSI background colors so that it can be scanned from a
+// terminal.
+func Render(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	modules, err := sample(img)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	n := len(modules)
+	for y := -quietZone; y < n+quietZone; y++ {
+		for x := -quietZone; x < n+quietZone; x++ {
+			if y >= 0 && y < n && x >= 0 && x < n && modules[y][x] {
+				sb.WriteString(black)
+			} else {
+				sb.WriteString(white)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// sample reads the modules of the QR code in img. The module size is taken
+// from the finder pattern in the top left corner, which is the first dark
+// run of the top row of the code.
+func sample(img image.Image) ([][]bool, error) {
+	b := img.Bounds()
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X-1, b.Min.Y-1
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if isDark(img.At(x, y)) {
+				if x < minX {
+					minX = x
+				}
+				if x > maxX {
+					maxX = x
+				}
+				if y < minY {
+					minY = y
+				}
+				if y > maxY {
+					maxY = y
+				}
+			}
+		}
+	}
+	if maxX < minX {
+		return nil, errors.New("no qrcode in image")
+	}
+
+	run := 0
+	for x := minX; x <= maxX && isDark(img.At(x, minY)); x++ {
+		run++
+	}
+	moduleSize := float64(run) / finderSize
+	n := int(float64(maxX-minX+1)/moduleSize + 0.5)
+	if moduleSize < 1 || n < 21 || n > 177 || (n-17)%4 != 0 {
+		return nil, errors.New("unrecognized qrcode image")
+	}
+
+	modules := make([][]bool, n)
+	for i := range modules {
+		modules[i] = make([]bool, n)
+		for j := range modules[i] {
+			x := minX + int((float64(j)+0.5)*moduleSize)
+			y := minY + int((float64(i)+0.5)*moduleSize)
+			modules[i][j] = isDark(img.At(x, y))
+		}
+	}
+	return modules, nil
+}
+
+func isDark(c color.Color) bool {
+	gray := color.GrayModel.Convert(c).(color.Gray)
+	_, _, _, a := c.RGBA()
+	return a > 0x8000 && gray.Y < 0x80
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gaoyb7/115drive-webdav/common/auth"
+)
+
+// runHashPassword runs the hash-password subcommand, which reads a password
+// from stdin and prints its hash, to be used as a password in the config.
+func runHashPassword(args []string) {
+	fs := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	algo := fs.String("algo", auth.AlgoBcrypt, "hash algorithm, one of: "+auth.AlgoBcrypt+", "+auth.AlgoArgon2id)
+	fs.Parse(args)
+
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		fmt.Fprintf(os.Stderr, "read password fail, err: %v\n", err)
+		os.Exit(1)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if len(password) == 0 {
+		fmt.Fprintln(os.Stderr, "empty password")
+		os.Exit(1)
+	}
+
+	hash, err := auth.HashPassword(password, *algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hash password fail, err: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
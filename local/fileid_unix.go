@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package local
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID returns the device and inode of fi, which stay the same when the
+// file is renamed or moved within the device.
+func fileID(fi os.FileInfo, realPath string) string {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+	}
+	return realPath
+}
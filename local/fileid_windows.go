@@ -0,0 +1,9 @@
+package local
+
+import "os"
+
+// fileID returns the local path of fi, as os.FileInfo on windows has no
+// file index. Dead properties do not follow renames there.
+func fileID(fi os.FileInfo, realPath string) string {
+	return realPath
+}
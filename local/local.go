@@ -0,0 +1,405 @@
+// Package local implements a DriveClient serving a directory of the local
+// file system, so that the WebDAV server can be run and tested without a
+// 115 account.
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/gaoyb7/115drive-webdav/common/drive"
+	"github.com/sirupsen/logrus"
+)
+
+// DriveClient serves the directory root. Paths of the drive are cleaned and
+// resolved under root. Symlinks are followed as long as they stay inside
+// root, the ones pointing outside of it are served as missing.
+type DriveClient struct {
+	root string
+}
+
+// New returns a DriveClient serving the directory root.
+func New(root string) (*DriveClient, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("local drive root is not a dir: %s", root)
+	}
+	return &DriveClient{root: root}, nil
+}
+
+// FileInfo is a file of a local drive, realPath is its local path.
+type FileInfo struct {
+	os.FileInfo
+	id       string
+	realPath string
+}
+
+func newFileInfo(fi os.FileInfo, realPath string) *FileInfo {
+	return &FileInfo{FileInfo: fi, id: fileID(fi, realPath), realPath: realPath}
+}
+
+func (f *FileInfo) GetID() string            { return f.id }
+func (f *FileInfo) GetName() string          { return f.Name() }
+func (f *FileInfo) GetUpdateTime() time.Time { return f.ModTime() }
+func (f *FileInfo) GetCreateTime() time.Time { return f.ModTime() }
+
+func (f *FileInfo) GetSize() int64 {
+	if f.IsDir() {
+		return 0
+	}
+	return f.Size()
+}
+
+// rootInfo is the root of the drive, whose name is empty whatever the name
+// of the root dir is.
+type rootInfo struct {
+	*FileInfo
+}
+
+func (rootInfo) GetName() string { return "" }
+
+// realPath returns the local path of name, or common.ErrNotFound if a
+// symlink on the way leads out of root. A missing name is checked through
+// its dir, as it is about to be created.
+func (c *DriveClient) realPath(name string) (string, error) {
+	p := filepath.Join(c.root, filepath.FromSlash(slashClean(name)))
+	resolved, err := filepath.EvalSymlinks(p)
+	if os.IsNotExist(err) {
+		var dir string
+		if dir, err = filepath.EvalSymlinks(filepath.Dir(p)); err == nil {
+			resolved = filepath.Join(dir, filepath.Base(p))
+		}
+	}
+	if err != nil {
+		return "", driveError(err)
+	}
+	if !c.inRoot(resolved) {
+		return "", common.ErrNotFound
+	}
+	return p, nil
+}
+
+// inRoot reports whether the resolved local path p is root or below it.
+func (c *DriveClient) inRoot(p string) bool {
+	return p == c.root || strings.HasPrefix(p, strings.TrimSuffix(c.root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func (c *DriveClient) GetFiles(ctx context.Context, dir string) ([]drive.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dirPath, err := c.realPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, driveError(err)
+	}
+	files := make([]drive.File, 0, len(infos))
+	for _, info := range infos {
+		p := filepath.Join(dirPath, info.Name())
+		// Symlinks are served as what they point to, broken ones and
+		// the ones leading out of root are left out.
+		if info.Mode()&os.ModeSymlink != 0 {
+			resolved, err := filepath.EvalSymlinks(p)
+			if err != nil || !c.inRoot(resolved) {
+				continue
+			}
+			if info, err = os.Stat(p); err != nil {
+				continue
+			}
+		}
+		files = append(files, newFileInfo(info, p))
+	}
+	return files, nil
+}
+
+func (c *DriveClient) GetFile(ctx context.Context, filePath string) (drive.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := c.realPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, driveError(err)
+	}
+	if slashClean(filePath) == "/" {
+		return rootInfo{newFileInfo(info, p)}, nil
+	}
+	return newFileInfo(info, p), nil
+}
+
+func (c *DriveClient) RemoveFile(ctx context.Context, filePath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if slashClean(filePath) == "/" {
+		return fmt.Errorf("remove file fail, can not remove the root")
+	}
+	p, err := c.realPath(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(p); err != nil {
+		return driveError(err)
+	}
+	return driveError(os.RemoveAll(p))
+}
+
+// MoveFile moves srcPath to dstPath, which must not exist.
+func (c *DriveClient) MoveFile(ctx context.Context, srcPath string, dstPath string) error {
+	logrus.Infof("move file, src: %s, dst: %s", srcPath, dstPath)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if inTree(slashClean(dstPath), slashClean(srcPath)) {
+		return fmt.Errorf("%w: can not move %s into itself", common.ErrForbidden, srcPath)
+	}
+	src, dst, err := c.srcDst(srcPath, dstPath)
+	if err != nil {
+		return err
+	}
+	return driveError(os.Rename(src, dst))
+}
+
+// CopyFile copies srcPath to dstPath, which must not exist, with everything
+// below it if it is a dir.
+func (c *DriveClient) CopyFile(ctx context.Context, srcPath string, dstPath string) error {
+	logrus.Infof("copy file, src: %s, dst: %s", srcPath, dstPath)
+	if inTree(slashClean(dstPath), slashClean(srcPath)) {
+		return fmt.Errorf("%w: can not copy %s into itself", common.ErrForbidden, srcPath)
+	}
+	src, dst, err := c.srcDst(srcPath, dstPath)
+	if err != nil {
+		return err
+	}
+	return driveError(c.copyTree(ctx, src, dst))
+}
+
+// srcDst returns the local paths of a move or a copy, after checking that
+// the source exists, that the destination dir does and that the destination
+// does not.
+func (c *DriveClient) srcDst(srcPath string, dstPath string) (string, string, error) {
+	if slashClean(srcPath) == "/" || slashClean(dstPath) == "/" {
+		return "", "", fmt.Errorf("invalid src_path: %s, dst_path: %s", srcPath, dstPath)
+	}
+	src, err := c.realPath(srcPath)
+	if err != nil {
+		return "", "", err
+	}
+	dst, err := c.realPath(dstPath)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := os.Lstat(src); err != nil {
+		return "", "", driveError(err)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return "", "", common.ErrExists
+	}
+	dstDir, err := os.Stat(filepath.Dir(dst))
+	if err != nil {
+		return "", "", driveError(err)
+	}
+	if !dstDir.IsDir() {
+		return "", "", common.ErrNotFound
+	}
+	return src, dst, nil
+}
+
+// copyTree copies the local path src to dst. Symlinks are copied as links to
+// what they point to, and left out like in GetFiles if they are broken or
+// lead out of root, so that a copy never brings content from out of root.
+func (c *DriveClient) copyTree(ctx context.Context, src string, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		resolved, err := filepath.EvalSymlinks(src)
+		if err != nil || !c.inRoot(resolved) {
+			return nil
+		}
+		dstDir, err := filepath.EvalSymlinks(filepath.Dir(dst))
+		if err != nil {
+			return err
+		}
+		target, err := filepath.Rel(dstDir, resolved)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+	if !info.IsDir() {
+		return copyFile(src, dst, info.Mode())
+	}
+
+	if err := os.Mkdir(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := c.copyTree(ctx, filepath.Join(src, info.Name()), filepath.Join(dst, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func (c *DriveClient) MakeDir(ctx context.Context, dir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p, err := c.realPath(dir)
+	if err != nil {
+		return err
+	}
+	return driveError(os.Mkdir(p, 0755))
+}
+
+// UploadFile creates or replaces the file at filePath with the content of r.
+// The content is written to a temp file next to it, which replaces it once
+// complete, so that a failed upload leaves the previous content.
+func (c *DriveClient) UploadFile(ctx context.Context, filePath string, r io.Reader, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if slashClean(filePath) == "/" {
+		return fmt.Errorf("invalid upload path: %s", filePath)
+	}
+	p, err := c.realPath(filePath)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+		return fmt.Errorf("upload file fail, %s is a dir", filePath)
+	}
+	dir, err := os.Stat(filepath.Dir(p))
+	if err != nil {
+		return driveError(err)
+	}
+	if !dir.IsDir() {
+		return common.ErrNotFound
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".upload-")
+	if err != nil {
+		return driveError(err)
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("upload file fail, expect %d bytes, got %d", size, n)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return driveError(err)
+	}
+	return nil
+}
+
+func (c *DriveClient) ServeContent(w http.ResponseWriter, req *http.Request, fi drive.File) {
+	info, ok := fi.(*FileInfo)
+	if !ok || info.IsDir() {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := os.Open(info.realPath)
+	if err != nil {
+		logrus.WithError(err).Errorf("call os.Open fail, path: %s", info.realPath)
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, req, fi.GetName(), fi.GetUpdateTime(), f)
+}
+
+// driveError returns the common error of err, an error of package os, if
+// there is one.
+func driveError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case os.IsNotExist(err):
+		return common.ErrNotFound
+	case os.IsExist(err):
+		return common.ErrExists
+	case os.IsPermission(err):
+		return fmt.Errorf("%w: %v", common.ErrForbidden, err)
+	case errors.Is(err, syscall.ENOTDIR):
+		// A file is in the way of the path.
+		return common.ErrNotFound
+	case errors.Is(err, syscall.EBUSY):
+		return fmt.Errorf("%w: %v", common.ErrLocked, err)
+	}
+	return err
+}
+
+// inTree reports whether p is dir or below it.
+func inTree(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func slashClean(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
@@ -0,0 +1,197 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	os.Exit(m.Run())
+}
+
+// newTestClient returns a DriveClient serving a new temp dir, with the dir
+// "outside" next to it, which holds the file secret.
+func newTestClient(t *testing.T) (*DriveClient, string, string) {
+	t.Helper()
+	tmp, err := ioutil.TempDir("", "local-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	root, outside := filepath.Join(tmp, "root"), filepath.Join(tmp, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, outside, "secret", "secret")
+	c, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, root, outside
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+}
+
+// names returns the sorted names of the files of dir.
+func names(t *testing.T, c *DriveClient, dir string) string {
+	t.Helper()
+	files, err := c.GetFiles(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("GetFiles(%q): %v", dir, err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.GetName())
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestRootEscape(t *testing.T) {
+	c, root, outside := newTestClient(t)
+	ctx := context.Background()
+	writeFile(t, root, "a/f.txt", "f")
+	symlink(t, outside, filepath.Join(root, "out"))
+	symlink(t, filepath.Join(outside, "secret"), filepath.Join(root, "a", "secret"))
+	symlink(t, filepath.Join(root, "a"), filepath.Join(root, "in"))
+
+	if got := names(t, c, "/"); got != "a in" {
+		t.Errorf("GetFiles(/): got %q, want the links out of root left out", got)
+	}
+	if got := names(t, c, "/in"); got != "f.txt" {
+		t.Errorf("GetFiles(/in): got %q", got)
+	}
+	for _, p := range []string{"/out", "/out/secret", "/a/secret", "/in/secret"} {
+		if _, err := c.GetFile(ctx, p); !errors.Is(err, common.ErrNotFound) {
+			t.Errorf("GetFile(%q): got %v, want ErrNotFound", p, err)
+		}
+	}
+	// Dot dot is cleaned away at the root.
+	if fi, err := c.GetFile(ctx, "/../../a/f.txt"); err != nil || fi.GetSize() != 1 {
+		t.Errorf("GetFile of a path with dot dots: got %v, %v", fi, err)
+	}
+	if err := c.UploadFile(ctx, "/out/x", strings.NewReader("x"), 1); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("UploadFile through a link out of root: got %v, want ErrNotFound", err)
+	}
+	if err := c.MakeDir(ctx, "/out/d"); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("MakeDir through a link out of root: got %v, want ErrNotFound", err)
+	}
+	if err := c.RemoveFile(ctx, "/out/secret"); !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("RemoveFile through a link out of root: got %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret")); err != nil {
+		t.Errorf("the file out of root: %v", err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	c, root, outside := newTestClient(t)
+	ctx := context.Background()
+	writeFile(t, root, "a/f.txt", "f")
+	writeFile(t, root, "a/b/g.txt", "g")
+	writeFile(t, root, "other/h.txt", "h")
+	symlink(t, filepath.Join(outside, "secret"), filepath.Join(root, "a", "secret"))
+	symlink(t, outside, filepath.Join(root, "a", "out"))
+	symlink(t, filepath.Join(root, "other"), filepath.Join(root, "a", "in"))
+
+	if err := c.CopyFile(ctx, "/a", "/c"); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	if got := names(t, c, "/c"); got != "b f.txt in" {
+		t.Errorf("GetFiles of the copy: got %q, want the links out of root left out", got)
+	}
+	for _, name := range []string{"secret", "out"} {
+		if _, err := os.Lstat(filepath.Join(root, "c", name)); !os.IsNotExist(err) {
+			t.Errorf("the copy of the link %s out of root: got %v, want none", name, err)
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(root, "c", "b", "g.txt")); err != nil || string(data) != "g" {
+		t.Errorf("the copy of b/g.txt: got %q, %v", data, err)
+	}
+	// A link in root stays a link to the same file.
+	if target, err := filepath.EvalSymlinks(filepath.Join(root, "c", "in")); err != nil || target != filepath.Join(c.root, "other") {
+		t.Errorf("the copy of the link in root: got %q, %v", target, err)
+	}
+
+	testCases := []struct {
+		src, dst string
+		want     error
+	}{
+		{"/a", "/c", common.ErrExists},
+		{"/a", "/a/d", common.ErrForbidden},
+		{"/a", "/nope/d", common.ErrNotFound},
+		{"/nope", "/d", common.ErrNotFound},
+		{"/a/out", "/d", common.ErrNotFound},
+	}
+	for _, tc := range testCases {
+		if err := c.CopyFile(ctx, tc.src, tc.dst); !errors.Is(err, tc.want) {
+			t.Errorf("CopyFile(%q, %q): got %v, want %v", tc.src, tc.dst, err, tc.want)
+		}
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	c, root, outside := newTestClient(t)
+	ctx := context.Background()
+	writeFile(t, root, "a/f.txt", "f")
+	writeFile(t, root, "b/g.txt", "g")
+	symlink(t, outside, filepath.Join(root, "out"))
+
+	if err := c.MoveFile(ctx, "/a/f.txt", "/b/moved.txt"); err != nil {
+		t.Fatalf("MoveFile: %v", err)
+	}
+	if got := names(t, c, "/b"); got != "g.txt moved.txt" {
+		t.Errorf("GetFiles after a move: got %q", got)
+	}
+
+	testCases := []struct {
+		src, dst string
+		want     error
+	}{
+		{"/b/moved.txt", "/b/g.txt", common.ErrExists},
+		{"/b", "/b/c", common.ErrForbidden},
+		{"/a/f.txt", "/b/f.txt", common.ErrNotFound},
+		{"/b/g.txt", "/nope/g.txt", common.ErrNotFound},
+		{"/b/g.txt", "/out/g.txt", common.ErrNotFound},
+		{"/out/secret", "/b/secret", common.ErrNotFound},
+	}
+	for _, tc := range testCases {
+		if err := c.MoveFile(ctx, tc.src, tc.dst); !errors.Is(err, tc.want) {
+			t.Errorf("MoveFile(%q, %q): got %v, want %v", tc.src, tc.dst, err, tc.want)
+		}
+	}
+	if got := names(t, c, "/b"); got != "g.txt moved.txt" {
+		t.Errorf("GetFiles after failed moves: got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret")); err != nil {
+		t.Errorf("the file out of root: %v", err)
+	}
+}
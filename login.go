@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	_115 "github.com/gaoyb7/115drive-webdav/115"
+	"github.com/gaoyb7/115drive-webdav/common/config"
+	"github.com/gaoyb7/115drive-webdav/common/qrterm"
+)
+
+// runLogin runs the login subcommand, which signs in to 115 with a QR code
+// scanned in the 115 app and writes the cookies to a config file.
+func runLogin(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	app := fs.String("app", "tv", "app type to sign in as, one of: "+strings.Join(_115.QRCodeApps, ", "))
+	configFile := fs.String("config", "config.json", "config file to write the cookies to, created if not exists")
+	account := fs.String("account", "", "name of the entry of accounts in the config file to write the cookies to, the top level cookies if empty")
+	pngFile := fs.String("qrcode-png", "", "also save the qrcode image to this file")
+	fs.Parse(args)
+
+	if err := login(*app, *configFile, *account, *pngFile); err != nil {
+		fmt.Fprintf(os.Stderr, "login fail, err: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func login(app string, configFile string, account string, pngFile string) error {
+	session, err := _115.NewQRCodeSession(app)
+	if err != nil {
+		return err
+	}
+	data, err := session.Image()
+	if err != nil {
+		return err
+	}
+	if len(pngFile) > 0 {
+		if err := ioutil.WriteFile(pngFile, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("qrcode image saved to %s\n", pngFile)
+	}
+	if text, err := qrterm.Render(data); err == nil {
+		fmt.Print(text)
+	} else if len(pngFile) == 0 {
+		return fmt.Errorf("render qrcode fail, use -qrcode-png to save the image instead, err: %v", err)
+	}
+	fmt.Printf("scan the qrcode with the 115 app to sign in as %s\n", app)
+
+	// The status call waits for a change of the status, the ticker keeps
+	// the polls apart when it answers right away.
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastStatus := _115.QRCodeStatusWaiting
+	for ; ; <-ticker.C {
+		status, err := session.Status()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "get qrcode status fail, retry, err: %v\n", err)
+			time.Sleep(3 * time.Second)
+			continue
+		}
+		if status == lastStatus && status != _115.QRCodeStatusConfirmed {
+			continue
+		}
+		lastStatus = status
+
+		switch status {
+		case _115.QRCodeStatusScanned:
+			fmt.Println("scanned, please confirm the login in the 115 app")
+		case _115.QRCodeStatusConfirmed:
+			cookies, err := session.Login()
+			if err != nil {
+				return err
+			}
+			if err := config.SaveCookies(configFile, account, cookies.UID, cookies.CID, cookies.SEID, cookies.KID); err != nil {
+				return err
+			}
+			fmt.Printf("login succeeded, cookies saved to %s\n", configFile)
+			return nil
+		case _115.QRCodeStatusCanceled:
+			return fmt.Errorf("login canceled")
+		case _115.QRCodeStatusExpired:
+			return fmt.Errorf("qrcode expired")
+		}
+	}
+}
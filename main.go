@@ -1,11 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	_115 "github.com/gaoyb7/115drive-webdav/115"
+	"github.com/gaoyb7/115drive-webdav/admin"
+	"github.com/gaoyb7/115drive-webdav/common/auth"
+	"github.com/gaoyb7/115drive-webdav/common/blockcache"
+	"github.com/gaoyb7/115drive-webdav/common/certreload"
 	"github.com/gaoyb7/115drive-webdav/common/config"
+	"github.com/gaoyb7/115drive-webdav/common/drive"
+	"github.com/gaoyb7/115drive-webdav/common/metastore"
+	"github.com/gaoyb7/115drive-webdav/local"
 	"github.com/gaoyb7/115drive-webdav/webdav"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -17,24 +34,174 @@ var (
 
 func main() {
 	logrus.SetReportCaller(true)
-	webdavHandler := webdav.Handler{
-		DriveClient: _115.MustNew115DriveClient(cfg.Uid, cfg.Cid, cfg.Seid, cfg.Kid),
-		LockSystem:  webdav.NewMemLS(),
-		Logger: func(req *http.Request, err error) {
-			if err != nil {
-				logrus.WithField("method", req.Method).WithField("path", req.URL.Path).Errorf("err: %v", err)
+	switch flag.Arg(0) {
+	case "login":
+		runLogin(flag.Args()[1:])
+		return
+	case "hash-password":
+		runHashPassword(flag.Args()[1:])
+		return
+	}
+
+	lockSystem := webdav.NewMemLS()
+	if len(cfg.LockFile) > 0 {
+		var err error
+		if lockSystem, err = webdav.NewFileLS(cfg.LockFile); err != nil {
+			logrus.WithError(err).Panicf("call webdav.NewFileLS fail, lock_file: %s", cfg.LockFile)
+		}
+	}
+	propSystem := webdav.NewMemPS()
+	if len(cfg.PropFile) > 0 {
+		var err error
+		if propSystem, err = webdav.NewFilePS(cfg.PropFile); err != nil {
+			logrus.WithError(err).Panicf("call webdav.NewFilePS fail, prop_file: %s", cfg.PropFile)
+		}
+	}
+	driveOpts := []_115.Option{
+		_115.WithRedirect(cfg.RedirectUsers, cfg.RedirectUserAgents),
+		_115.WithParallelRange(cfg.ParallelConnections, int64(cfg.ParallelChunkSizeMB)*1024*1024),
+		_115.WithReadAhead(int64(cfg.ReadAheadMB) * 1024 * 1024),
+		_115.WithDirCacheExpire(time.Duration(cfg.DirCacheMinutes) * time.Minute),
+		_115.WithRateLimits(cfg.APIRateLimit, cfg.DownloadURLRateLimit, cfg.ContentRateLimit, cfg.RateLimitBurst),
+		_115.WithRetry(cfg.APIRetryCount),
+	}
+	if cfg.ChangePollSeconds > 0 {
+		driveOpts = append(driveOpts, _115.WithChangeWatch(time.Duration(cfg.ChangePollSeconds)*time.Second))
+	}
+	if len(cfg.CacheDir) > 0 {
+		blockSize := int64(cfg.CacheBlockSizeMB) * 1024 * 1024
+		if blockSize <= 0 {
+			blockSize = 4 * 1024 * 1024
+		}
+		maxSize := int64(cfg.CacheSizeMB) * 1024 * 1024
+		if maxSize <= 0 {
+			maxSize = 10 * 1024 * 1024 * 1024
+		}
+		cache, err := blockcache.New(cfg.CacheDir, blockSize, maxSize)
+		if err != nil {
+			logrus.WithError(err).Panicf("call blockcache.New fail, cache_dir: %s", cfg.CacheDir)
+		}
+		driveOpts = append(driveOpts, _115.WithBlockCache(cache))
+	}
+	var metaStore *metastore.Store
+	if len(cfg.MetadataFile) > 0 {
+		var err error
+		if metaStore, err = metastore.Open(cfg.MetadataFile); err != nil {
+			logrus.WithError(err).Panicf("call metastore.Open fail, metadata_file: %s", cfg.MetadataFile)
+		}
+		go metaStore.SaveEvery(time.Minute)
+	}
+	// Clients of the 115 accounts, keyed by mount name, which is empty when
+	// a single account is served at the root.
+	clients := make(map[string]*_115.DriveClient)
+	var driveClient drive.DriveClient
+	if len(cfg.Overlay) > 0 {
+		driveClient = newUnionClient(clients, driveOpts, metaStore)
+	} else if len(cfg.LocalDir) > 0 {
+		localClient, err := local.New(cfg.LocalDir)
+		if err != nil {
+			logrus.WithError(err).Panicf("call local.New fail, local_dir: %s", cfg.LocalDir)
+		}
+		logrus.Warnf("serve local dir %s instead of 115", cfg.LocalDir)
+		driveClient = localClient
+	} else if len(cfg.Accounts) == 0 {
+		opts := driveOpts
+		if metaStore != nil {
+			opts = append(opts[:len(opts):len(opts)], _115.WithMetadataStore(metaStore))
+		}
+		clients[""] = newDriveClient(cfg.Uid, cfg.Cid, cfg.Seid, cfg.Kid, "", opts)
+		driveClient = clients[""]
+	} else {
+		mountClient := drive.NewMountClient()
+		for _, account := range cfg.Accounts {
+			opts := driveOpts
+			if metaStore != nil {
+				opts = append(opts[:len(opts):len(opts)], _115.WithMetadataStore(metaStore.Sub("account:"+account.Name+":")))
 			}
-		},
+			client := newDriveClient(account.Uid, account.Cid, account.Seid, account.Kid, account.Name, opts)
+			if err := mountClient.Mount(account.Name, client); err != nil {
+				logrus.WithError(err).Panicf("call mountClient.Mount fail, account: %s", account.Name)
+			}
+			clients[account.Name] = client
+		}
+		driveClient = mountClient
+	}
+	users := cfg.Users
+	if len(users) == 0 {
+		users = []config.User{{Name: cfg.User, Password: cfg.Password}}
+	}
+	// Each user gets its own handler, with its root and permission, sharing
+	// the drive, locks and properties.
+	newHandler := func(root string, perm webdav.Permission) *webdav.Handler {
+		return &webdav.Handler{
+			DriveClient: driveClient,
+			LockSystem:  lockSystem,
+			PropSystem:  propSystem,
+			Root:        root,
+			Permission:  perm,
+			Logger: func(req *http.Request, err error) {
+				if err != nil {
+					logrus.WithField("method", req.Method).WithField("path", req.URL.Path).Errorf("err: %v", err)
+				}
+			},
+		}
+	}
+	passwords := make(map[string]string)
+	for _, user := range users {
+		passwords[user.Name] = user.Password
 	}
+	authenticator := auth.NewCached(newAuthenticator(passwords), time.Minute)
+	// Users are looked up by the name the authenticator normalizes, as ldap
+	// users may log in with their name in another case.
+	webdavHandlers := make(map[string]*webdav.Handler)
+	adminUsers := make(map[string]bool)
+	userRoots := false
+	for _, user := range users {
+		perm, err := webdav.ParsePermission(user.Permission)
+		if err != nil {
+			logrus.WithError(err).Panicf("call webdav.ParsePermission fail, user: %s", user.Name)
+		}
+		name := auth.NormalizeUser(authenticator, user.Name)
+		if _, ok := webdavHandlers[name]; ok {
+			logrus.Panicf("duplicate user: %s", user.Name)
+		}
+		webdavHandlers[name] = newHandler(user.Root, perm)
+		if perm == webdav.PermFull {
+			adminUsers[name] = true
+		}
+		if len(user.Root) > 0 && user.Root != "/" {
+			userRoots = true
+		}
+	}
+	// Users authenticated by htpasswd or ldap who are not in the config
+	// users get the whole drive with the default permission, read-only when
+	// a config file leaves it out, not the full permission of an empty one.
+	defaultPerm := webdav.PermReadOnly
+	if len(cfg.AuthDefaultPermission) > 0 {
+		var err error
+		if defaultPerm, err = webdav.ParsePermission(cfg.AuthDefaultPermission); err != nil {
+			logrus.WithError(err).Panicf("call webdav.ParsePermission fail, auth_default_permission: %s", cfg.AuthDefaultPermission)
+		}
+	}
+	// Once users are confined to their roots, the whole drive is not given
+	// to users who are not in the config users.
+	defaultHandler := newHandler("", defaultPerm)
 	webdavHandleFunc := func(c *gin.Context) {
-		webdavHandler.ServeHTTP(c.Writer, c.Request)
+		h, ok := webdavHandlers[c.GetString(gin.AuthUserKey)]
+		if !ok {
+			if userRoots {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			h = defaultHandler
+		}
+		h.ServeHTTP(c.Writer, c.Request)
 	}
+	tlsConfig := newTLSConfig()
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
-	dav := r.Group("", gin.BasicAuth(gin.Accounts{
-		cfg.User: cfg.Password,
-	}))
+	dav := r.Group("", auth.Middleware(authenticator, "Authorization Required"))
 	dav.Any("/*path", webdavHandleFunc)
 	dav.Handle("PROPFIND", "/*path", webdavHandleFunc)
 	dav.Handle("MKCOL", "/*path", webdavHandleFunc)
@@ -44,7 +211,255 @@ func main() {
 	dav.Handle("COPY", "/*path", webdavHandleFunc)
 	dav.Handle("MOVE", "/*path", webdavHandleFunc)
 
-	if err := r.Run(fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)); err != nil {
+	var servers []*http.Server
+	if len(cfg.AdminAddr) > 0 {
+		adminEngine := gin.Default()
+		adminGroup := adminEngine.Group("", auth.Middleware(authenticator, "Authorization Required"), func(c *gin.Context) {
+			if !adminUsers[c.GetString(gin.AuthUserKey)] {
+				c.AbortWithStatus(http.StatusForbidden)
+			}
+		})
+		admin.New(clients, config.Filename()).Register(adminGroup)
+		adminServer := newServer(cfg.AdminAddr, adminEngine, tlsConfig)
+		servers = append(servers, adminServer)
+		go func() {
+			if err := serve(adminServer); err != nil {
+				logrus.WithError(err).Panicf("run admin server fail, admin_addr: %s", cfg.AdminAddr)
+			}
+		}()
+	}
+
+	if tlsConfig != nil && cfg.HTTPRedirectPort > 0 {
+		addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.HTTPRedirectPort)
+		redirectServer := newServer(addr, http.HandlerFunc(redirectToHTTPS), nil)
+		servers = append(servers, redirectServer)
+		go func() {
+			if err := serve(redirectServer); err != nil {
+				logrus.WithError(err).Panicf("run http redirect server fail, http_redirect_port: %d", cfg.HTTPRedirectPort)
+			}
+		}()
+	}
+
+	server := newServer(fmt.Sprintf("%s:%d", cfg.Host, cfg.Port), r, tlsConfig)
+	servers = append(servers, server)
+	done := shutdownOnSignal(servers, metaStore)
+	if err := serve(server); err != nil {
 		logrus.Panic(err)
 	}
+	<-done
+}
+
+// shutdownTimeout is how long the requests in flight are waited for on
+// SIGINT or SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
+// shutdownOnSignal shuts servers down on SIGINT or SIGTERM, then saves store,
+// if not nil, so that the listings fetched since the last periodic save are
+// on disk. The returned channel is closed once done.
+func shutdownOnSignal(servers []*http.Server, store *metastore.Store) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(done)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, server := range servers {
+			if err := server.Shutdown(ctx); err != nil {
+				logrus.WithError(err).Errorf("call server.Shutdown fail, addr: %s", server.Addr)
+			}
+		}
+		if store != nil {
+			if err := store.Save(); err != nil {
+				logrus.WithError(err).Errorf("call store.Save fail, metadata_file: %s", cfg.MetadataFile)
+			}
+		}
+	}()
+	return done
+}
+
+// newTLSConfig returns the tls config of the servers, or nil when https is
+// not enabled. The certificate is reloaded when its files are modified.
+func newTLSConfig() *tls.Config {
+	if len(cfg.TLSCert) == 0 && len(cfg.TLSKey) == 0 {
+		return nil
+	}
+	reloader, err := certreload.New(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		logrus.WithError(err).Panicf("call certreload.New fail, tls_cert: %s, tls_key: %s", cfg.TLSCert, cfg.TLSKey)
+	}
+	go reloader.Watch(time.Minute)
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+}
+
+// newServer returns a server of handler on addr, over https when tlsConfig
+// is not nil.
+func newServer(addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+}
+
+// serve runs server until it is shut down, which is not an error.
+func serve(server *http.Server) error {
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// redirectToHTTPS redirects plain http requests to the same url on the https
+// port. Methods other than GET and HEAD get a 308, so that clients resend
+// them with their body instead of turning them into a GET.
+func redirectToHTTPS(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		// A host without a port, such as "[::1]", whose brackets are added
+		// back below.
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	if cfg.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	target := "https://" + host + req.URL.RequestURI()
+
+	code := http.StatusPermanentRedirect
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(w, req, target, code)
+}
+
+// newAuthenticator returns the authentication backend selected in the
+// config. passwords are the passwords of the config users.
+func newAuthenticator(passwords map[string]string) auth.Authenticator {
+	switch cfg.Auth {
+	case "", "config":
+		for name, password := range passwords {
+			if !auth.IsHash(password) {
+				logrus.Warnf("password of user %s is not hashed, hash it with the hash-password subcommand", name)
+			}
+		}
+		return auth.NewStatic(passwords)
+	case "htpasswd":
+		a, err := auth.NewHtpasswd(cfg.HtpasswdFile)
+		if err != nil {
+			logrus.WithError(err).Panicf("call auth.NewHtpasswd fail, htpasswd_file: %s", cfg.HtpasswdFile)
+		}
+		return a
+	case "ldap":
+		a, err := auth.NewLDAP(cfg.LDAPURL, cfg.LDAPBindDN)
+		if err != nil {
+			logrus.WithError(err).Panicf("call auth.NewLDAP fail, ldap_url: %s", cfg.LDAPURL)
+		}
+		return a
+	}
+	logrus.Panicf("invalid auth backend: %s", cfg.Auth)
+	return nil
+}
+
+// newDriveClient returns the client of a 115 account, account is the name of
+// its entry in the config accounts, or empty for the top level cookies.
+func newDriveClient(uid string, cid string, seid string, kid string, account string, opts []_115.Option) *_115.DriveClient {
+	if cfg.SessionCheckMinutes > 0 {
+		var reload func() (*_115.Cookies, error)
+		if filename := config.Filename(); len(filename) > 0 {
+			reload = cookieReloader(filename, account)
+		}
+		opts = append(opts[:len(opts):len(opts)], _115.WithSessionMonitor(time.Duration(cfg.SessionCheckMinutes)*time.Minute, reload))
+	}
+	return _115.MustNew115DriveClient(uid, cid, seid, kid, opts...)
+}
+
+// newUnionClient overlays the layers of cfg.Overlay, and adds the clients of
+// the 115 accounts among them to clients.
+func newUnionClient(clients map[string]*_115.DriveClient, driveOpts []_115.Option, metaStore *metastore.Store) *drive.UnionClient {
+	unionClient := drive.NewUnionClient()
+	for _, name := range cfg.Overlay {
+		var layer drive.DriveClient
+		switch {
+		case name == "local":
+			localClient, err := local.New(cfg.LocalDir)
+			if err != nil {
+				logrus.WithError(err).Panicf("call local.New fail, local_dir: %s", cfg.LocalDir)
+			}
+			layer = localClient
+		case name == "115" && len(cfg.Accounts) == 0:
+			opts := driveOpts
+			if metaStore != nil {
+				opts = append(opts[:len(opts):len(opts)], _115.WithMetadataStore(metaStore))
+			}
+			clients[""] = newDriveClient(cfg.Uid, cfg.Cid, cfg.Seid, cfg.Kid, "", opts)
+			layer = clients[""]
+		default:
+			var account *config.Account
+			for i := range cfg.Accounts {
+				if cfg.Accounts[i].Name == name {
+					account = &cfg.Accounts[i]
+				}
+			}
+			if account == nil {
+				logrus.Panicf("overlay layer not found, layer: %s", name)
+			}
+			opts := driveOpts
+			if metaStore != nil {
+				opts = append(opts[:len(opts):len(opts)], _115.WithMetadataStore(metaStore.Sub("account:"+account.Name+":")))
+			}
+			clients[name] = newDriveClient(account.Uid, account.Cid, account.Seid, account.Kid, account.Name, opts)
+			layer = clients[name]
+		}
+		if err := unionClient.AddLayer(name, layer); err != nil {
+			logrus.WithError(err).Panicf("call unionClient.AddLayer fail, layer: %s", name)
+		}
+	}
+	if len(cfg.OverlayUpper) > 0 {
+		if err := unionClient.SetUpper(cfg.OverlayUpper); err != nil {
+			logrus.WithError(err).Panicf("call unionClient.SetUpper fail, overlay_upper: %s", cfg.OverlayUpper)
+		}
+	}
+	return unionClient
+}
+
+// cookieReloader returns the cookies of account in the config file filename
+// each time the file is modified, so that cookies written by the login
+// subcommand are used without a restart.
+func cookieReloader(filename string, account string) func() (*_115.Cookies, error) {
+	var modTime time.Time
+	if info, err := os.Stat(filename); err == nil {
+		modTime = info.ModTime()
+	}
+	return func() (*_115.Cookies, error) {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Equal(modTime) {
+			return nil, nil
+		}
+
+		// The file is only marked as read once loaded, a file being
+		// written by hand is read again at the next check.
+		uid, cid, seid, kid, err := config.LoadCookies(filename, account)
+		if err != nil {
+			return nil, err
+		}
+		modTime = info.ModTime()
+		return &_115.Cookies{UID: uid, CID: cid, SEID: seid, KID: kid}, nil
+	}
 }
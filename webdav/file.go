@@ -6,9 +6,13 @@ package webdav
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"path"
 	"path/filepath"
+	"strings"
 
+	"github.com/gaoyb7/115drive-webdav/common"
 	"github.com/gaoyb7/115drive-webdav/common/drive"
 	"github.com/sirupsen/logrus"
 )
@@ -22,6 +26,11 @@ func slashClean(name string) string {
 	return path.Clean(name)
 }
 
+// inTree reports whether p is dir or below it.
+func inTree(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
 // walkFS traverses filesystem fs starting at name up to depth levels.
 //
 // Allowed values for depth are 0, 1 or infiniteDepth. For each visited node,
@@ -43,8 +52,14 @@ func walkFS(ctx context.Context, depth int, name string, fs drive.DriveClient, f
 		depth = 0
 	}
 
+	// Stop walking once the request is gone, every listing may cost an API
+	// call.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Read directory names.
-	files, err := fs.GetFiles(name)
+	files, err := fs.GetFiles(ctx, name)
 	if err != nil {
 		logrus.WithError(err).Errorf("call client.GetFiles fail, name: %s", name)
 		return walkFn(name, fi, err)
@@ -61,3 +76,149 @@ func walkFS(ctx context.Context, depth int, name string, fs drive.DriveClient, f
 	}
 	return nil
 }
+
+// removeAll removes the resource name, whose file is fi, and everything
+// below it. With a PropSystem ps, the dead properties of the removed
+// resources are removed too, so that they are not kept for ids that are
+// gone. Those are found by their recorded paths rather than by listing
+// every collection below name, and each is checked to still be there, as a
+// path is stale after a move by another client.
+func removeAll(ctx context.Context, fs drive.DriveClient, ps PropSystem, name string, fi drive.File) error {
+	var ids []string
+	if ps != nil {
+		ids = append(ids, fi.GetID())
+		found, err := ps.Find(name)
+		if err != nil {
+			logrus.WithError(err).Errorf("call ps.Find fail, name: %s", name)
+		}
+		for id, p := range found {
+			if id == fi.GetID() {
+				continue
+			}
+			if f, err := fs.GetFile(ctx, p); err == nil && f.GetID() == id {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if err := fs.RemoveFile(ctx, name); err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		if err := ps.Remove(ids...); err != nil {
+			logrus.WithError(err).Errorf("call ps.Remove fail, name: %s", name)
+		}
+	}
+	return nil
+}
+
+// copyFiles copies the resource at src to dst. The copy itself is done server
+// side by the drive, no file content passes through this server.
+//
+// A collection is copied by creating dst and copying each of its members
+// into it, unless depth is 0, in which case only dst is created. Members that
+// fail to copy are returned in failed, keyed by their destination path, so
+// that the caller can report them in a multistatus response.
+func copyFiles(ctx context.Context, fs drive.DriveClient, ps PropSystem, src, dst string, overwrite bool, depth int) (status int, failed map[string]error, err error) {
+	srcFi, err := fs.GetFile(ctx, src)
+	if err != nil {
+		return driveErrorStatus(err, http.StatusInternalServerError), nil, err
+	}
+
+	dstDir, _ := path.Split(slashClean(dst))
+	if dstDirFi, err := fs.GetFile(ctx, dstDir); err != nil || !dstDirFi.IsDir() {
+		// Section 9.8.5 says that "409 (Conflict) - A resource cannot be
+		// created at the destination until one or more intermediate
+		// collections have been created."
+		return http.StatusConflict, nil, errNotADirectory
+	}
+
+	created := false
+	if dstFi, err := fs.GetFile(ctx, dst); err != nil {
+		if !errors.Is(err, common.ErrNotFound) {
+			return driveErrorStatus(err, http.StatusForbidden), nil, err
+		}
+		created = true
+	} else {
+		if !overwrite {
+			return http.StatusPreconditionFailed, nil, errDestinationExists
+		}
+		if err := removeAll(ctx, fs, ps, dst, dstFi); err != nil && !errors.Is(err, common.ErrNotFound) {
+			return driveErrorStatus(err, http.StatusForbidden), nil, err
+		}
+	}
+
+	if !srcFi.IsDir() {
+		if err := fs.CopyFile(ctx, src, dst); err != nil {
+			return driveErrorStatus(err, http.StatusInternalServerError), nil, err
+		}
+	} else {
+		if err := fs.MakeDir(ctx, dst); err != nil {
+			return driveErrorStatus(err, http.StatusForbidden), nil, err
+		}
+		if depth != 0 {
+			files, err := fs.GetFiles(ctx, src)
+			if err != nil {
+				return driveErrorStatus(err, http.StatusInternalServerError), nil, err
+			}
+			for _, file := range files {
+				name := file.GetName()
+				if err := fs.CopyFile(ctx, path.Join(src, name), path.Join(dst, name)); err != nil {
+					logrus.WithError(err).Errorf("call fs.CopyFile fail, src: %s, dst: %s", path.Join(src, name), path.Join(dst, name))
+					if failed == nil {
+						failed = make(map[string]error)
+					}
+					failed[path.Join(dst, name)] = err
+				}
+			}
+		}
+	}
+
+	if created {
+		return http.StatusCreated, failed, nil
+	}
+	return http.StatusNoContent, failed, nil
+}
+
+// moveFiles moves the resource at src to dst, replacing dst if overwrite is
+// set.
+func moveFiles(ctx context.Context, fs drive.DriveClient, ps PropSystem, src, dst string, overwrite bool) (status int, err error) {
+	dstDir, _ := path.Split(slashClean(dst))
+	if dstDirFi, err := fs.GetFile(ctx, dstDir); err != nil || !dstDirFi.IsDir() {
+		// Section 9.9.4 says that "409 (Conflict) - A resource cannot be
+		// created at the destination until one or more intermediate
+		// collections have been created."
+		return http.StatusConflict, errNotADirectory
+	}
+
+	created := false
+	if dstFi, err := fs.GetFile(ctx, dst); err != nil {
+		if !errors.Is(err, common.ErrNotFound) {
+			return driveErrorStatus(err, http.StatusForbidden), err
+		}
+		created = true
+	} else {
+		if !overwrite {
+			return http.StatusPreconditionFailed, errDestinationExists
+		}
+		// Section 9.9.3 says that "If a resource exists at the destination
+		// and the Overwrite header is "T", then prior to performing the move,
+		// the server must perform a DELETE with "Depth: infinity" on the
+		// destination resource."
+		if err := removeAll(ctx, fs, ps, dst, dstFi); err != nil && !errors.Is(err, common.ErrNotFound) {
+			return driveErrorStatus(err, http.StatusForbidden), err
+		}
+	}
+
+	if err := fs.MoveFile(ctx, src, dst); err != nil {
+		return driveErrorStatus(err, http.StatusInternalServerError), err
+	}
+	if ps != nil {
+		if err := ps.Move(src, dst); err != nil {
+			logrus.WithError(err).Errorf("call ps.Move fail, src: %s, dst: %s", src, dst)
+		}
+	}
+	if created {
+		return http.StatusCreated, nil
+	}
+	return http.StatusNoContent, nil
+}
@@ -0,0 +1,148 @@
+package webdav
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gaoyb7/115drive-webdav/common/atomicfile"
+)
+
+// NewFileLS returns a LockSystem that manages locks in memory like NewMemLS,
+// and saves them to filename on every change, so that locks and their expiry
+// survive restarts. Temporary locks are not saved. Locks that expired while
+// the server was down are dropped when filename is loaded, and so are
+// infinite locks without an owner, the temporary locks of older versions,
+// which nobody holds the token of after a restart.
+func NewFileLS(filename string) (LockSystem, error) {
+	f := &fileLS{
+		memLS:    newMemLS(),
+		filename: filename,
+	}
+	if err := f.load(time.Now()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type fileLS struct {
+	*memLS
+	filename string
+	// saveMu serializes saves, so that an older snapshot never overwrites a
+	// newer one.
+	saveMu sync.Mutex
+}
+
+type fileLSLock struct {
+	Token   string      `json:"token"`
+	Details LockDetails `json:"details"`
+	Expiry  time.Time   `json:"expiry"`
+}
+
+type fileLSSnapshot struct {
+	Gen   uint64       `json:"gen"`
+	Locks []fileLSLock `json:"locks"`
+}
+
+func (f *fileLS) Create(now time.Time, details LockDetails) (string, error) {
+	token, err := f.memLS.Create(now, details)
+	if err != nil || details.Temporary {
+		return token, err
+	}
+	if err := f.save(); err != nil {
+		f.memLS.Unlock(now, token)
+		return "", err
+	}
+	return token, nil
+}
+
+func (f *fileLS) Refresh(now time.Time, token string, duration time.Duration) (LockDetails, error) {
+	details, err := f.memLS.Refresh(now, token, duration)
+	if err != nil || details.Temporary {
+		return details, err
+	}
+	if err := f.save(); err != nil {
+		return LockDetails{}, err
+	}
+	return details, nil
+}
+
+func (f *fileLS) Unlock(now time.Time, token string) error {
+	m := f.memLS
+	m.mu.Lock()
+	n := m.byToken[token]
+	temporary := n != nil && n.details.Temporary
+	m.mu.Unlock()
+	if err := f.memLS.Unlock(now, token); err != nil || temporary {
+		return err
+	}
+	return f.save()
+}
+
+func (f *fileLS) load(now time.Time) error {
+	data, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	snapshot := fileLSSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	m := f.memLS
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Tokens are generated from a counter, which must not hand out the
+	// token of a restored lock again.
+	if snapshot.Gen > m.gen {
+		m.gen = snapshot.Gen
+	}
+	for _, l := range snapshot.Locks {
+		if l.Details.Duration >= 0 && !now.Before(l.Expiry) {
+			continue
+		}
+		if l.Details.Temporary || (l.Details.Duration < 0 && len(l.Details.OwnerXML) == 0) {
+			continue
+		}
+		if !m.canCreate(slashClean(l.Details.Root), l.Details.ZeroDepth) {
+			continue
+		}
+		l.Details.Root = slashClean(l.Details.Root)
+		m.restore(l.Token, l.Details, l.Expiry)
+	}
+	return nil
+}
+
+func (f *fileLS) save() error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	m := f.memLS
+	m.mu.Lock()
+	snapshot := fileLSSnapshot{
+		Gen:   m.gen,
+		Locks: make([]fileLSLock, 0, len(m.byToken)),
+	}
+	for token, n := range m.byToken {
+		if n.details.Temporary {
+			continue
+		}
+		snapshot.Locks = append(snapshot.Locks, fileLSLock{
+			Token:   token,
+			Details: n.details,
+			Expiry:  n.expiry,
+		})
+	}
+	m.mu.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(f.filename, data)
+}
@@ -91,6 +91,19 @@ type LockSystem interface {
 	// See http://www.webdav.org/specs/rfc4918.html#rfc.section.9.11.1 for
 	// when to use each error.
 	Unlock(now time.Time, token string) error
+
+	// Discover returns the active locks that cover the named resource, that
+	// is a lock rooted at name, or an infinite depth lock rooted at one of
+	// its ancestors. It is used to report the DAV:lockdiscovery property.
+	Discover(now time.Time, name string) ([]ActiveLock, error)
+}
+
+// ActiveLock is a lock reported by a LockSystem's Discover method.
+type ActiveLock struct {
+	// Token identifies the lock, as returned by Create.
+	Token string
+	// Details are the lock's metadata.
+	Details LockDetails
 }
 
 // LockDetails are a lock's metadata.
@@ -109,10 +122,18 @@ type LockDetails struct {
 	// ZeroDepth is whether the lock has zero depth. If it does not have zero
 	// depth, it has infinite depth.
 	ZeroDepth bool
+	// Temporary is whether the lock is only held for the duration of a
+	// request, as the locks taken by write requests without an If header.
+	// A LockSystem need not persist it.
+	Temporary bool `json:",omitempty"`
 }
 
 // NewMemLS returns a new in-memory LockSystem.
 func NewMemLS() LockSystem {
+	return newMemLS()
+}
+
+func newMemLS() *memLS {
 	return &memLS{
 		byName:  make(map[string]*memLSNode),
 		byToken: make(map[string]*memLSNode),
@@ -288,6 +309,38 @@ func (m *memLS) Unlock(now time.Time, token string) error {
 	return nil
 }
 
+func (m *memLS) Discover(now time.Time, name string) ([]ActiveLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectExpiredNodes(now)
+
+	var locks []ActiveLock
+	walkToRoot(slashClean(name), func(name0 string, first bool) bool {
+		n := m.byName[name0]
+		if n != nil && n.token != "" && (first || !n.details.ZeroDepth) {
+			locks = append(locks, ActiveLock{
+				Token:   n.token,
+				Details: n.details,
+			})
+		}
+		return true
+	})
+	return locks, nil
+}
+
+// restore recreates a lock that was created by an earlier memLS, such as one
+// loaded by a fileLS. The caller must hold m.mu.
+func (m *memLS) restore(token string, details LockDetails, expiry time.Time) {
+	n := m.create(details.Root)
+	n.token = token
+	m.byToken[n.token] = n
+	n.details = details
+	if n.details.Duration >= 0 {
+		n.expiry = expiry
+		heap.Push(&m.byExpiry, n)
+	}
+}
+
 func (m *memLS) canCreate(name string, zeroDepth bool) bool {
 	return walkToRoot(name, func(name0 string, first bool) bool {
 		n := m.byName[name0]
@@ -0,0 +1,347 @@
+package webdav
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// lockSystems are the LockSystems that must honour the contract of
+// LockSystem, each made by a function returning a new empty one.
+var lockSystems = []struct {
+	name string
+	new  func(t *testing.T) LockSystem
+}{
+	{"mem", func(t *testing.T) LockSystem { return NewMemLS() }},
+	{"file", func(t *testing.T) LockSystem {
+		ls, err := NewFileLS(filepath.Join(tempDir(t), "locks.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ls
+	}},
+}
+
+// tempDir returns a new temp dir, removed at the end of the test.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "webdav-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func forEachLockSystem(t *testing.T, test func(t *testing.T, ls LockSystem)) {
+	for _, s := range lockSystems {
+		t.Run(s.name, func(t *testing.T) { test(t, s.new(t)) })
+	}
+}
+
+func TestLockSystemCreate(t *testing.T) {
+	testCases := []struct {
+		root      string
+		zeroDepth bool
+		want      error
+	}{
+		{"/a/b", false, nil},
+		{"/a/b", true, ErrLocked},
+		{"/a/b/c", true, ErrLocked},
+		{"/a", false, ErrLocked},
+		// A zero depth lock of an ancestor does not cover /a/b.
+		{"/a", true, nil},
+		{"/", true, nil},
+		{"/", false, ErrLocked},
+		{"/a/c", false, nil},
+		{"/a/c/", true, ErrLocked},
+		{"/a/b/../c/d", true, ErrLocked},
+	}
+	forEachLockSystem(t, func(t *testing.T, ls LockSystem) {
+		now := time.Now()
+		for _, tc := range testCases {
+			_, err := ls.Create(now, LockDetails{Root: tc.root, Duration: -1, ZeroDepth: tc.zeroDepth})
+			if err != tc.want {
+				t.Errorf("Create(%q, zero depth %v): got %v, want %v", tc.root, tc.zeroDepth, err, tc.want)
+			}
+		}
+	})
+}
+
+func TestLockSystemRefreshUnlock(t *testing.T) {
+	forEachLockSystem(t, func(t *testing.T, ls LockSystem) {
+		now := time.Now()
+		token, err := ls.Create(now, LockDetails{Root: "/a", Duration: time.Minute, OwnerXML: "<owner/>"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		details, err := ls.Refresh(now, token, time.Hour)
+		if err != nil || details.Root != "/a" || details.Duration != time.Hour || details.OwnerXML != "<owner/>" {
+			t.Errorf("Refresh: got %+v, %v", details, err)
+		}
+		if _, err := ls.Refresh(now, "nope", time.Hour); err != ErrNoSuchLock {
+			t.Errorf("Refresh of a missing lock: got %v, want %v", err, ErrNoSuchLock)
+		}
+
+		// Locks can not be refreshed or unlocked while they are held.
+		release, err := ls.Confirm(now, "/a/b", "", Condition{Token: token})
+		if err != nil {
+			t.Fatalf("Confirm: %v", err)
+		}
+		if _, err := ls.Refresh(now, token, time.Hour); err != ErrLocked {
+			t.Errorf("Refresh of a held lock: got %v, want %v", err, ErrLocked)
+		}
+		if err := ls.Unlock(now, token); err != ErrLocked {
+			t.Errorf("Unlock of a held lock: got %v, want %v", err, ErrLocked)
+		}
+		release()
+
+		if err := ls.Unlock(now, "nope"); err != ErrNoSuchLock {
+			t.Errorf("Unlock of a missing lock: got %v, want %v", err, ErrNoSuchLock)
+		}
+		if err := ls.Unlock(now, token); err != nil {
+			t.Errorf("Unlock: %v", err)
+		}
+		if err := ls.Unlock(now, token); err != ErrNoSuchLock {
+			t.Errorf("Unlock twice: got %v, want %v", err, ErrNoSuchLock)
+		}
+		if _, err := ls.Create(now, LockDetails{Root: "/a/b", Duration: -1}); err != nil {
+			t.Errorf("Create below an unlocked lock: %v", err)
+		}
+	})
+}
+
+func TestLockSystemConfirm(t *testing.T) {
+	forEachLockSystem(t, func(t *testing.T, ls LockSystem) {
+		now := time.Now()
+		tokenA, err := ls.Create(now, LockDetails{Root: "/a", Duration: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenC, err := ls.Create(now, LockDetails{Root: "/c", Duration: -1, ZeroDepth: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testCases := []struct {
+			name0, name1 string
+			conditions   []Condition
+			ok           bool
+		}{
+			{"/a", "", []Condition{{Token: tokenA}}, true},
+			{"/a/b/c", "", []Condition{{Token: tokenA}}, true},
+			{"/a", "/a/b", []Condition{{Token: tokenA}}, true},
+			{"/b", "", []Condition{{Token: tokenA}}, false},
+			{"/a", "", []Condition{{Token: "nope"}}, false},
+			{"/c", "", []Condition{{Token: tokenC}}, true},
+			{"/c/d", "", []Condition{{Token: tokenC}}, false},
+			{"/a/b", "/c", []Condition{{Token: tokenA}, {Token: tokenC}}, true},
+			{"/a/b", "/c", []Condition{{Token: tokenA}}, false},
+		}
+		for _, tc := range testCases {
+			release, err := ls.Confirm(now, tc.name0, tc.name1, tc.conditions...)
+			if tc.ok != (err == nil) {
+				t.Errorf("Confirm(%q, %q, %v): got %v, want ok %v", tc.name0, tc.name1, tc.conditions, err, tc.ok)
+			}
+			if err == nil {
+				release()
+			} else if err != ErrConfirmationFailed {
+				t.Errorf("Confirm(%q, %q, %v): got %v, want %v", tc.name0, tc.name1, tc.conditions, err, ErrConfirmationFailed)
+			}
+		}
+
+		// A held lock can not be confirmed again until released.
+		release, err := ls.Confirm(now, "/a", "", Condition{Token: tokenA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ls.Confirm(now, "/a/b", "", Condition{Token: tokenA}); err != ErrConfirmationFailed {
+			t.Errorf("Confirm of a held lock: got %v, want %v", err, ErrConfirmationFailed)
+		}
+		release()
+		if release, err := ls.Confirm(now, "/a/b", "", Condition{Token: tokenA}); err != nil {
+			t.Errorf("Confirm of a released lock: %v", err)
+		} else {
+			release()
+		}
+	})
+}
+
+func TestLockSystemExpiry(t *testing.T) {
+	forEachLockSystem(t, func(t *testing.T, ls LockSystem) {
+		now := time.Now()
+		token, err := ls.Create(now, LockDetails{Root: "/a", Duration: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ls.Create(now.Add(30*time.Second), LockDetails{Root: "/a", Duration: -1}); err != ErrLocked {
+			t.Errorf("Create before the expiry: got %v, want %v", err, ErrLocked)
+		}
+		// A refresh starts the duration again.
+		if _, err := ls.Refresh(now.Add(30*time.Second), token, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if locks, _ := ls.Discover(now.Add(80*time.Second), "/a"); len(locks) != 1 {
+			t.Errorf("Discover before the refreshed expiry: got %v, want the lock", locks)
+		}
+		later := now.Add(2 * time.Minute)
+		if locks, _ := ls.Discover(later, "/a"); len(locks) != 0 {
+			t.Errorf("Discover after the expiry: got %v, want none", locks)
+		}
+		if _, err := ls.Refresh(later, token, time.Minute); err != ErrNoSuchLock {
+			t.Errorf("Refresh after the expiry: got %v, want %v", err, ErrNoSuchLock)
+		}
+		if _, err := ls.Create(later, LockDetails{Root: "/a", Duration: -1}); err != nil {
+			t.Errorf("Create after the expiry: %v", err)
+		}
+	})
+}
+
+func TestLockSystemDiscover(t *testing.T) {
+	forEachLockSystem(t, func(t *testing.T, ls LockSystem) {
+		now := time.Now()
+		tokenA, _ := ls.Create(now, LockDetails{Root: "/a/", Duration: -1})
+		tokenB, _ := ls.Create(now, LockDetails{Root: "/b", Duration: -1, ZeroDepth: true})
+
+		testCases := []struct {
+			name string
+			want []string
+		}{
+			{"/a", []string{tokenA}},
+			{"/a/x/y", []string{tokenA}},
+			{"/b", []string{tokenB}},
+			{"/b/x", nil},
+			{"/c", nil},
+		}
+		for _, tc := range testCases {
+			locks, err := ls.Discover(now, tc.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string(nil)
+			for _, l := range locks {
+				got = append(got, l.Token)
+			}
+			if len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+				t.Errorf("Discover(%q): got %v, want %v", tc.name, got, tc.want)
+			}
+		}
+		if locks, _ := ls.Discover(now, "/a"); len(locks) == 1 && locks[0].Details.Root != "/a" {
+			t.Errorf("Discover: got root %q, want the clean root /a", locks[0].Details.Root)
+		}
+	})
+}
+
+func TestFileLSRestart(t *testing.T) {
+	filename := filepath.Join(tempDir(t), "locks.json")
+	ls, err := NewFileLS(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	kept, err := ls.Create(now, LockDetails{Root: "/a", Duration: time.Hour, OwnerXML: "<owner/>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	infinite, err := ls.Create(now, LockDetails{Root: "/b", Duration: -1, OwnerXML: "<owner/>", ZeroDepth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Expired by the time the file is loaded again.
+	if _, err := ls.Create(now.Add(-time.Hour), LockDetails{Root: "/c", Duration: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	unlocked, err := ls.Create(now, LockDetails{Root: "/d", Duration: -1, OwnerXML: "<owner/>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.Unlock(now, unlocked); err != nil {
+		t.Fatal(err)
+	}
+	// Held by a request when the server stops.
+	if _, err := ls.Create(now, LockDetails{Root: "/f", Duration: -1, ZeroDepth: true, Temporary: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err = NewFileLS(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"/a": kept, "/b": infinite, "/c": "", "/d": "", "/f": ""} {
+		locks, err := ls.Discover(now, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case want == "" && len(locks) != 0:
+			t.Errorf("Discover(%q) after a restart: got %v, want none", name, locks)
+		case want != "" && (len(locks) != 1 || locks[0].Token != want):
+			t.Errorf("Discover(%q) after a restart: got %v, want %s", name, locks, want)
+		}
+	}
+	if details, err := ls.Refresh(now, kept, time.Hour); err != nil || details.OwnerXML != "<owner/>" {
+		t.Errorf("Refresh of a restored lock: got %+v, %v", details, err)
+	}
+	if _, err := ls.Create(now, LockDetails{Root: "/a/x", Duration: -1}); err != ErrLocked {
+		t.Errorf("Create below a restored lock: got %v, want %v", err, ErrLocked)
+	}
+	token, err := ls.Create(now, LockDetails{Root: "/e", Duration: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == kept || token == infinite || token == unlocked {
+		t.Errorf("Create after a restart: got the token %s of an earlier lock", token)
+	}
+}
+
+func TestFileLSTemporary(t *testing.T) {
+	filename := filepath.Join(tempDir(t), "locks.json")
+	ls, err := NewFileLS(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, err := ls.Create(now, LockDetails{Root: "/a", Duration: -1, ZeroDepth: true, Temporary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Create of a temporary lock: got the file saved, %v", err)
+	}
+	if _, err := ls.Create(now, LockDetails{Root: "/a", Duration: -1}); err != ErrLocked {
+		t.Errorf("Create below a temporary lock: got %v, want %v", err, ErrLocked)
+	}
+	if err := ls.Unlock(now, token); err != nil {
+		t.Errorf("Unlock of a temporary lock: %v", err)
+	}
+
+	// The infinite locks without an owner written by older versions for
+	// the duration of a request.
+	old := `{"gen":2,"locks":[` +
+		`{"token":"1","details":{"Root":"/a","Duration":-1,"OwnerXML":"","ZeroDepth":true},"expiry":"0001-01-01T00:00:00Z"},` +
+		`{"token":"2","details":{"Root":"/b","Duration":-1,"OwnerXML":"<owner/>","ZeroDepth":true},"expiry":"0001-01-01T00:00:00Z"}]}`
+	if err := ioutil.WriteFile(filename, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ls, err = NewFileLS(filename); err != nil {
+		t.Fatal(err)
+	}
+	if locks, _ := ls.Discover(now, "/a"); len(locks) != 0 {
+		t.Errorf("Discover of an infinite lock without an owner: got %v, want none", locks)
+	}
+	if locks, _ := ls.Discover(now, "/b"); len(locks) != 1 {
+		t.Errorf("Discover of an infinite lock with an owner: got %v, want it", locks)
+	}
+}
+
+func TestFileLSInvalidFile(t *testing.T) {
+	filename := filepath.Join(tempDir(t), "locks.json")
+	if err := ioutil.WriteFile(filename, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileLS(filename); err == nil {
+		t.Errorf("NewFileLS of an invalid file: got no error")
+	}
+}
@@ -0,0 +1,104 @@
+package webdav
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Permission is what a Handler lets its user do.
+type Permission int
+
+const (
+	// PermFull allows every method.
+	PermFull Permission = iota
+	// PermNoDelete allows creating and moving resources, but not deleting
+	// them, nor replacing them with PUT, COPY or MOVE. Empty files can be
+	// replaced by PUT, as LOCK creates them before the content is put.
+	PermNoDelete
+	// PermReadOnly only allows methods that do not change resources.
+	PermReadOnly
+)
+
+// ParsePermission parses "full", "no-delete" or "read-only". An empty string
+// is full permission.
+func ParsePermission(s string) (Permission, error) {
+	switch s {
+	case "", "full":
+		return PermFull, nil
+	case "no-delete":
+		return PermNoDelete, nil
+	case "read-only":
+		return PermReadOnly, nil
+	}
+	return PermFull, fmt.Errorf("invalid permission: %s", s)
+}
+
+func (p Permission) String() string {
+	switch p {
+	case PermNoDelete:
+		return "no-delete"
+	case PermReadOnly:
+		return "read-only"
+	}
+	return "full"
+}
+
+// allows reports whether p allows method.
+func (p Permission) allows(method string) bool {
+	switch method {
+	case "OPTIONS", "GET", "HEAD", "POST", "PROPFIND":
+		return true
+	case "DELETE":
+		return p == PermFull
+	}
+	return p != PermReadOnly
+}
+
+// rootPath returns the drive path of the request path p, which is relative
+// to h.Root.
+func (h *Handler) rootPath(p string) string {
+	if h.Root == "" || h.Root == "/" {
+		return p
+	}
+	return path.Join(h.Root, slashClean(p))
+}
+
+// userPath returns the request path of the drive path p, the reverse of
+// rootPath.
+func (h *Handler) userPath(p string) string {
+	if h.Root == "" || h.Root == "/" {
+		return p
+	}
+	root := slashClean(h.Root)
+	if p == root {
+		return "/"
+	}
+	return strings.TrimPrefix(p, root)
+}
+
+// userLockSystem returns h.LockSystem, with the roots of discovered locks
+// given as request paths.
+func (h *Handler) userLockSystem() LockSystem {
+	if h.LockSystem == nil || h.Root == "" || h.Root == "/" {
+		return h.LockSystem
+	}
+	return &userLS{LockSystem: h.LockSystem, h: h}
+}
+
+type userLS struct {
+	LockSystem
+	h *Handler
+}
+
+func (ls *userLS) Discover(now time.Time, name string) ([]ActiveLock, error) {
+	locks, err := ls.LockSystem.Discover(now, name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range locks {
+		locks[i].Details.Root = ls.h.userPath(locks[i].Details.Root)
+	}
+	return locks, nil
+}
@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/gaoyb7/115drive-webdav/common/drive"
 )
@@ -103,7 +104,7 @@ type DeadPropsHolder interface {
 var liveProps = map[xml.Name]struct {
 	// findFn implements the propfind function of this property. If nil,
 	// it indicates a hidden property.
-	findFn func(context.Context, string, drive.File) (string, error)
+	findFn func(context.Context, LockSystem, string, drive.File) (string, error)
 	// dir is true if the property applies to directories.
 	dir bool
 }{
@@ -151,9 +152,10 @@ var liveProps = map[xml.Name]struct {
 		dir: false,
 	},
 
-	// TODO: The lockdiscovery property requires LockSystem to list the
-	// active locks on a resource.
-	{Space: "DAV:", Local: "lockdiscovery"}: {},
+	{Space: "DAV:", Local: "lockdiscovery"}: {
+		findFn: findLockDiscovery,
+		dir:    true,
+	},
 	{Space: "DAV:", Local: "supportedlock"}: {
 		findFn: findSupportedLock,
 		dir:    true,
@@ -166,8 +168,15 @@ var liveProps = map[xml.Name]struct {
 //
 // Each Propstat has a unique status and each property name will only be part
 // of one Propstat element.
-func props(ctx context.Context, fi drive.File, pnames []xml.Name) ([]Propstat, error) {
+func props(ctx context.Context, ls LockSystem, name string, fi drive.File, pnames []xml.Name) ([]Propstat, error) {
 	var deadProps map[xml.Name]Property
+	if dph, ok := fi.(DeadPropsHolder); ok {
+		var err error
+		deadProps, err = dph.DeadProps()
+		if err != nil {
+			return nil, err
+		}
+	}
 	isDir := fi.IsDir()
 	pstatOK := Propstat{Status: http.StatusOK}
 	pstatNotFound := Propstat{Status: http.StatusNotFound}
@@ -179,7 +188,7 @@ func props(ctx context.Context, fi drive.File, pnames []xml.Name) ([]Propstat, e
 		}
 		// Otherwise, it must either be a live property or we don't know it.
 		if prop := liveProps[pn]; prop.findFn != nil && (prop.dir || !isDir) {
-			innerXML, err := prop.findFn(ctx, fi.GetName(), fi)
+			innerXML, err := prop.findFn(ctx, ls, name, fi)
 			if err != nil {
 				return nil, err
 			}
@@ -197,8 +206,15 @@ func props(ctx context.Context, fi drive.File, pnames []xml.Name) ([]Propstat, e
 }
 
 // Propnames returns the property names defined for resource name.
-func propnames(ctx context.Context, fi drive.File) ([]xml.Name, error) {
+func propnames(ctx context.Context, ls LockSystem, name string, fi drive.File) ([]xml.Name, error) {
 	var deadProps map[xml.Name]Property
+	if dph, ok := fi.(DeadPropsHolder); ok {
+		var err error
+		deadProps, err = dph.DeadProps()
+		if err != nil {
+			return nil, err
+		}
+	}
 	isDir := fi.IsDir()
 	pnames := make([]xml.Name, 0, len(liveProps)+len(deadProps))
 	for pn, prop := range liveProps {
@@ -220,8 +236,8 @@ func propnames(ctx context.Context, fi drive.File) ([]xml.Name, error) {
 // returned if they are named in 'include'.
 //
 // See http://www.webdav.org/specs/rfc4918.html#METHOD_PROPFIND
-func allprop(ctx context.Context, fi drive.File, include []xml.Name) ([]Propstat, error) {
-	pnames, err := propnames(ctx, fi)
+func allprop(ctx context.Context, ls LockSystem, name string, fi drive.File, include []xml.Name) ([]Propstat, error) {
+	pnames, err := propnames(ctx, ls, name, fi)
 	if err != nil {
 		return nil, err
 	}
@@ -235,7 +251,66 @@ func allprop(ctx context.Context, fi drive.File, include []xml.Name) ([]Propstat
 			pnames = append(pnames, pn)
 		}
 	}
-	return props(ctx, fi, pnames)
+	return props(ctx, ls, name, fi, pnames)
+}
+
+// Patch patches the properties of resource fi. The return values are
+// described by DeadPropsHolder's Patch method.
+func patch(ctx context.Context, fi drive.File, patches []Proppatch) ([]Propstat, error) {
+	conflict := false
+loop:
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			if _, ok := liveProps[p.XMLName]; ok {
+				conflict = true
+				break loop
+			}
+		}
+	}
+	if conflict {
+		pstatForbidden := Propstat{
+			Status:   http.StatusForbidden,
+			XMLError: `<D:cannot-modify-protected-property xmlns:D="DAV:"/>`,
+		}
+		pstatFailedDep := Propstat{
+			Status: StatusFailedDependency,
+		}
+		for _, patch := range patches {
+			for _, p := range patch.Props {
+				if _, ok := liveProps[p.XMLName]; ok {
+					pstatForbidden.Props = append(pstatForbidden.Props, Property{XMLName: p.XMLName})
+				} else {
+					pstatFailedDep.Props = append(pstatFailedDep.Props, Property{XMLName: p.XMLName})
+				}
+			}
+		}
+		return makePropstats(pstatForbidden, pstatFailedDep), nil
+	}
+
+	if dph, ok := fi.(DeadPropsHolder); ok {
+		ret, err := dph.Patch(patches)
+		if err != nil {
+			return nil, err
+		}
+		// http://www.webdav.org/specs/rfc4918.html#ELEMENT_propstat says that
+		// "The contents of the prop XML element must only list the names of
+		// properties to which the result in the status element applies."
+		for _, pstat := range ret {
+			for i, p := range pstat.Props {
+				pstat.Props[i] = Property{XMLName: p.XMLName}
+			}
+		}
+		return ret, nil
+	}
+	// The file doesn't implement the optional DeadPropsHolder interface, so
+	// all patches are forbidden.
+	pstat := Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, Property{XMLName: p.XMLName})
+		}
+	}
+	return []Propstat{pstat}, nil
 }
 
 func escapeXML(s string) string {
@@ -258,14 +333,14 @@ func escapeXML(s string) string {
 	return s
 }
 
-func findResourceType(ctx context.Context, name string, fi drive.File) (string, error) {
+func findResourceType(ctx context.Context, ls LockSystem, name string, fi drive.File) (string, error) {
 	if fi.IsDir() {
 		return `<D:collection xmlns:D="DAV:"/>`, nil
 	}
 	return "", nil
 }
 
-func findDisplayName(ctx context.Context, name string, fi drive.File) (string, error) {
+func findDisplayName(ctx context.Context, ls LockSystem, name string, fi drive.File) (string, error) {
 	if slashClean(name) == "/" {
 		// Hide the real name of a possibly prefixed root directory.
 		return "", nil
@@ -273,11 +348,11 @@ func findDisplayName(ctx context.Context, name string, fi drive.File) (string, e
 	return escapeXML(fi.GetName()), nil
 }
 
-func findContentLength(ctx context.Context, name string, fi drive.File) (string, error) {
+func findContentLength(ctx context.Context, ls LockSystem, name string, fi drive.File) (string, error) {
 	return strconv.FormatInt(fi.GetSize(), 10), nil
 }
 
-func findLastModified(ctx context.Context, name string, fi drive.File) (string, error) {
+func findLastModified(ctx context.Context, ls LockSystem, name string, fi drive.File) (string, error) {
 	return fi.GetUpdateTime().Format(http.TimeFormat), nil
 }
 
@@ -302,7 +377,7 @@ type ContentTyper interface {
 	ContentType(ctx context.Context) (string, error)
 }
 
-func findContentType(ctx context.Context, name string, fi drive.File) (string, error) {
+func findContentType(ctx context.Context, ls LockSystem, name string, fi drive.File) (string, error) {
 	ctype := mime.TypeByExtension(filepath.Ext(name))
 	if ctype != "" {
 		return ctype, nil
@@ -328,17 +403,32 @@ type ETager interface {
 	ETag(ctx context.Context) (string, error)
 }
 
-func findETag(ctx context.Context, name string, fi drive.File) (string, error) {
+func findETag(ctx context.Context, ls LockSystem, name string, fi drive.File) (string, error) {
 	// The Apache http 2.4 web server by default concatenates the
 	// modification time and size of a file. We replicate the heuristic
 	// with nanosecond granularity.
 	return fmt.Sprintf(`"%x%x"`, fi.GetUpdateTime().UnixNano(), fi.GetSize()), nil
 }
 
-func findSupportedLock(ctx context.Context, name string, fi drive.File) (string, error) {
+func findSupportedLock(ctx context.Context, ls LockSystem, name string, fi drive.File) (string, error) {
 	return `` +
 		`<D:lockentry xmlns:D="DAV:">` +
 		`<D:lockscope><D:exclusive/></D:lockscope>` +
 		`<D:locktype><D:write/></D:locktype>` +
 		`</D:lockentry>`, nil
 }
+
+func findLockDiscovery(ctx context.Context, ls LockSystem, name string, fi drive.File) (string, error) {
+	if ls == nil {
+		return "", nil
+	}
+	locks, err := ls.Discover(time.Now(), name)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for _, l := range locks {
+		buf.WriteString(activeLockXML(l.Token, l.Details))
+	}
+	return buf.String(), nil
+}
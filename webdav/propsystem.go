@@ -0,0 +1,301 @@
+package webdav
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gaoyb7/115drive-webdav/common/atomicfile"
+	"github.com/gaoyb7/115drive-webdav/common/drive"
+)
+
+// PropSystem stores the dead properties of resources. Properties are keyed
+// by drive.File's GetID rather than by path, so that they follow a resource
+// when it is renamed or moved. The path of each resource is recorded too, so
+// that the properties below a removed collection are found without listing
+// it.
+type PropSystem interface {
+	// DeadProps returns a copy of the dead properties of the resource id.
+	DeadProps(id string) (map[xml.Name]Property, error)
+
+	// Patch patches the dead properties of the resource id at name, as
+	// described by DeadPropsHolder's Patch method.
+	Patch(id string, name string, patches []Proppatch) ([]Propstat, error)
+
+	// Find returns the ids of the resources with dead properties recorded
+	// at name or below it, with their recorded paths. A path is stale if
+	// the resource was moved by another client.
+	Find(name string) (map[string]string, error)
+
+	// Move records the resources at src or below it as moved to dst.
+	Move(src string, dst string) error
+
+	// Remove removes all dead properties of the resources ids.
+	Remove(ids ...string) error
+}
+
+// NewMemPS returns a new in-memory PropSystem.
+func NewMemPS() PropSystem {
+	return newMemPS()
+}
+
+func newMemPS() *memPS {
+	return &memPS{
+		byID:  make(map[string]map[xml.Name]Property),
+		paths: make(map[string]string),
+	}
+}
+
+type memPS struct {
+	mu    sync.Mutex
+	byID  map[string]map[xml.Name]Property
+	paths map[string]string
+}
+
+func (m *memPS) DeadProps(id string) (map[xml.Name]Property, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	props := make(map[xml.Name]Property, len(m.byID[id]))
+	for k, v := range m.byID[id] {
+		props[k] = v
+	}
+	return props, nil
+}
+
+func (m *memPS) Patch(id string, name string, patches []Proppatch) ([]Propstat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.patch(id, name, patches, nil)
+}
+
+// patch applies patches to the properties of id, at name. If commit is
+// non-nil, it is called with the patched properties before they replace the
+// current ones, and the patch is abandoned if it fails. The caller must hold
+// m.mu.
+func (m *memPS) patch(id string, name string, patches []Proppatch, commit func() error) ([]Propstat, error) {
+	props := make(map[xml.Name]Property, len(m.byID[id]))
+	for k, v := range m.byID[id] {
+		props[k] = v
+	}
+
+	pstat := Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, Property{XMLName: p.XMLName})
+			if patch.Remove {
+				delete(props, p.XMLName)
+				continue
+			}
+			props[p.XMLName] = p
+		}
+	}
+
+	old, existed := m.byID[id]
+	oldPath := m.paths[id]
+	m.set(id, slashClean(name), props)
+	if commit != nil {
+		if err := commit(); err != nil {
+			if existed {
+				m.byID[id], m.paths[id] = old, oldPath
+			} else {
+				delete(m.byID, id)
+				delete(m.paths, id)
+			}
+			return nil, err
+		}
+	}
+	return []Propstat{pstat}, nil
+}
+
+func (m *memPS) set(id string, name string, props map[xml.Name]Property) {
+	if len(props) == 0 {
+		delete(m.byID, id)
+		delete(m.paths, id)
+		return
+	}
+	m.byID[id] = props
+	m.paths[id] = name
+}
+
+func (m *memPS) Find(name string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = slashClean(name)
+	found := make(map[string]string)
+	for id, p := range m.paths {
+		if inTree(p, name) {
+			found[id] = p
+		}
+	}
+	return found, nil
+}
+
+func (m *memPS) Move(src string, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.move(src, dst)
+	return nil
+}
+
+// move rebases the paths at src or below it to dst, and reports whether
+// there were any. The caller must hold m.mu.
+func (m *memPS) move(src string, dst string) bool {
+	src, dst = slashClean(src), slashClean(dst)
+	moved := false
+	for id, p := range m.paths {
+		if inTree(p, src) {
+			m.paths[id] = dst + strings.TrimPrefix(p, src)
+			moved = true
+		}
+	}
+	return moved
+}
+
+func (m *memPS) Remove(ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(ids)
+	return nil
+}
+
+// remove removes the properties of ids, and reports whether any of them had
+// properties. The caller must hold m.mu.
+func (m *memPS) remove(ids []string) bool {
+	removed := false
+	for _, id := range ids {
+		if _, ok := m.byID[id]; ok {
+			delete(m.byID, id)
+			delete(m.paths, id)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// propFileVersion is the version of the file of NewFilePS. Version 0 was a
+// map of the properties by id, without their paths.
+const propFileVersion = 1
+
+// NewFilePS returns a PropSystem that keeps dead properties in memory like
+// NewMemPS, and saves them to filename on every change.
+func NewFilePS(filename string) (PropSystem, error) {
+	f := &filePS{
+		memPS:    newMemPS(),
+		filename: filename,
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type filePS struct {
+	*memPS
+	filename string
+}
+
+type filePSSnapshot struct {
+	Version int                   `json:"version"`
+	Props   map[string][]Property `json:"props"`
+	Paths   map[string]string     `json:"paths"`
+}
+
+func (f *filePS) Patch(id string, name string, patches []Proppatch) ([]Propstat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.patch(id, name, patches, f.save)
+}
+
+func (f *filePS) Move(src string, dst string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.move(src, dst) {
+		return nil
+	}
+	return f.save()
+}
+
+func (f *filePS) Remove(ids ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.remove(ids) {
+		return nil
+	}
+	return f.save()
+}
+
+func (f *filePS) load() error {
+	data, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	snapshot := filePSSnapshot{}
+	if _, ok := raw["version"]; ok {
+		err = json.Unmarshal(data, &snapshot)
+	} else {
+		err = json.Unmarshal(data, &snapshot.Props)
+	}
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, props := range snapshot.Props {
+		m := make(map[xml.Name]Property, len(props))
+		for _, p := range props {
+			m[p.XMLName] = p
+		}
+		// Properties of version 0 have no path, they are only removed
+		// along with the resource itself.
+		f.set(id, snapshot.Paths[id], m)
+	}
+	return nil
+}
+
+// save writes all properties to f.filename. The caller must hold f.mu.
+func (f *filePS) save() error {
+	snapshot := filePSSnapshot{
+		Version: propFileVersion,
+		Props:   make(map[string][]Property, len(f.byID)),
+		Paths:   f.paths,
+	}
+	for id, props := range f.byID {
+		for _, p := range props {
+			snapshot.Props[id] = append(snapshot.Props[id], p)
+		}
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(f.filename, data)
+}
+
+// propFile is the drive.File at name, whose dead properties are held by a
+// PropSystem.
+type propFile struct {
+	drive.File
+	name string
+	ps   PropSystem
+}
+
+func (f *propFile) DeadProps() (map[xml.Name]Property, error) {
+	return f.ps.DeadProps(f.GetID())
+}
+
+func (f *propFile) Patch(patches []Proppatch) ([]Propstat, error) {
+	return f.ps.Patch(f.GetID(), f.name, patches)
+}
@@ -0,0 +1,119 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// propSystems are the PropSystems that must honour the contract of
+// PropSystem, each made by a function returning a new empty one.
+var propSystems = []struct {
+	name string
+	new  func(t *testing.T) PropSystem
+}{
+	{"mem", func(t *testing.T) PropSystem { return NewMemPS() }},
+	{"file", func(t *testing.T) PropSystem {
+		ps, err := NewFilePS(filepath.Join(tempDir(t), "props.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ps
+	}},
+}
+
+func forEachPropSystem(t *testing.T, test func(t *testing.T, ps PropSystem)) {
+	for _, s := range propSystems {
+		t.Run(s.name, func(t *testing.T) { test(t, s.new(t)) })
+	}
+}
+
+var colorProp = xml.Name{Space: "urn:test", Local: "color"}
+
+func setColor(t *testing.T, ps PropSystem, id, name, color string) {
+	t.Helper()
+	patches := []Proppatch{{Props: []Property{{XMLName: colorProp, InnerXML: []byte(color)}}}}
+	if _, err := ps.Patch(id, name, patches); err != nil {
+		t.Fatalf("Patch(%s, %s): %v", id, name, err)
+	}
+}
+
+func TestPropSystemFindMove(t *testing.T) {
+	forEachPropSystem(t, func(t *testing.T, ps PropSystem) {
+		setColor(t, ps, "1", "/a", "red")
+		setColor(t, ps, "2", "/a/b/c", "green")
+		setColor(t, ps, "3", "/ab", "blue")
+
+		found, err := ps.Find("/a/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"1": "/a", "2": "/a/b/c"}; !reflect.DeepEqual(found, want) {
+			t.Errorf("Find(/a/): got %v, want %v", found, want)
+		}
+
+		if err := ps.Move("/a/b", "/x"); err != nil {
+			t.Fatal(err)
+		}
+		found, _ = ps.Find("/x")
+		if want := map[string]string{"2": "/x/c"}; !reflect.DeepEqual(found, want) {
+			t.Errorf("Find(/x) after a move: got %v, want %v", found, want)
+		}
+		if props, _ := ps.DeadProps("2"); string(props[colorProp].InnerXML) != "green" {
+			t.Errorf("DeadProps after a move: got %v", props)
+		}
+
+		if err := ps.Remove("1", "2", "missing"); err != nil {
+			t.Fatal(err)
+		}
+		found, _ = ps.Find("/")
+		if want := map[string]string{"3": "/ab"}; !reflect.DeepEqual(found, want) {
+			t.Errorf("Find(/) after a remove: got %v, want %v", found, want)
+		}
+		// Removing the last property forgets the path.
+		if _, err := ps.Patch("3", "/ab", []Proppatch{{Remove: true, Props: []Property{{XMLName: colorProp}}}}); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ = ps.Find("/"); len(found) != 0 {
+			t.Errorf("Find(/) without properties: got %v, want none", found)
+		}
+	})
+}
+
+func TestFilePSReload(t *testing.T) {
+	filename := filepath.Join(tempDir(t), "props.json")
+	ps, err := NewFilePS(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setColor(t, ps, "1", "/a/b", "red")
+	if err := ps.Move("/a", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	if ps, err = NewFilePS(filename); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := ps.Find("/c"); !reflect.DeepEqual(found, map[string]string{"1": "/c/b"}) {
+		t.Errorf("Find after a reload: got %v", found)
+	}
+	if props, _ := ps.DeadProps("1"); string(props[colorProp].InnerXML) != "red" {
+		t.Errorf("DeadProps after a reload: got %v", props)
+	}
+
+	// A file of version 0, with the properties by id and no paths.
+	old := `{"1":[{"XMLName":{"Space":"urn:test","Local":"color"},"Lang":"","InnerXML":"cmVk"}]}`
+	if err := ioutil.WriteFile(filename, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ps, err = NewFilePS(filename); err != nil {
+		t.Fatal(err)
+	}
+	if props, _ := ps.DeadProps("1"); string(props[colorProp].InnerXML) != "red" {
+		t.Errorf("DeadProps of a file of version 0: got %v", props)
+	}
+	if found, _ := ps.Find("/"); len(found) != 0 {
+		t.Errorf("Find in a file of version 0: got %v, want none", found)
+	}
+}
@@ -6,11 +6,14 @@
 package webdav // import "golang.org/x/net/webdav"
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -26,44 +29,70 @@ type Handler struct {
 	DriveClient drive.DriveClient
 	// LockSystem is the lock management system.
 	LockSystem LockSystem
+	// PropSystem is the dead property store. If nil, PROPPATCH is refused
+	// for all properties.
+	PropSystem PropSystem
+	// Root is the drive directory served as the root of the WebDAV tree.
+	// Request paths, including the Destination of COPY and MOVE, can not
+	// leave it. Empty serves the whole drive.
+	Root string
+	// Permission is what the user of the handler may do. The zero value
+	// allows every method.
+	Permission Permission
 	// Logger is an optional error logger. If non-nil, it will be called
 	// for all HTTP requests.
 	Logger func(*http.Request, error)
 }
 
+// withDeadProps returns fi, the file at name, with the optional
+// DeadPropsHolder interface backed by h.PropSystem, or fi itself if h has no
+// PropSystem.
+func (h *Handler) withDeadProps(name string, fi drive.File) drive.File {
+	if h.PropSystem == nil {
+		return fi
+	}
+	return &propFile{File: fi, name: name, ps: h.PropSystem}
+}
+
+// stripPrefix returns the drive path of the URL path p, which is p without
+// h.Prefix, within h.Root.
 func (h *Handler) stripPrefix(p string) (string, int, error) {
 	if h.Prefix == "" {
-		return p, http.StatusOK, nil
+		return h.rootPath(p), http.StatusOK, nil
 	}
 	if r := strings.TrimPrefix(p, h.Prefix); len(r) < len(p) {
-		return r, http.StatusOK, nil
+		return h.rootPath(r), http.StatusOK, nil
 	}
 	return p, http.StatusNotFound, errPrefixMismatch
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	status, err := http.StatusBadRequest, errUnsupportedMethod
-	switch r.Method {
-	case "OPTIONS":
-		status, err = h.handleOptions(w, r)
-	case "GET", "HEAD", "POST":
-		status, err = h.handleGetHeadPost(w, r)
-	case "DELETE":
-		status, err = h.handleDelete(w, r)
-	case "PUT":
-		status, err = http.StatusMethodNotAllowed, errUnsupportedMethod
-	case "MKCOL":
-		status, err = h.handleMkcol(w, r)
-	case "MOVE":
-		status, err = h.handleMove(w, r)
-	case "LOCK":
-		status, err = http.StatusMethodNotAllowed, errUnsupportedMethod
-	case "UNLOCK":
-		status, err = http.StatusMethodNotAllowed, errUnsupportedMethod
-	case "PROPFIND":
-		status, err = h.handlePropfind(w, r)
-	case "PROPPATCH":
-		status, err = http.StatusMethodNotAllowed, errUnsupportedMethod
+	if !h.Permission.allows(r.Method) {
+		status, err = http.StatusForbidden, errPermissionDenied
+	} else {
+		switch r.Method {
+		case "OPTIONS":
+			status, err = h.handleOptions(w, r)
+		case "GET", "HEAD", "POST":
+			status, err = h.handleGetHeadPost(w, r)
+		case "DELETE":
+			status, err = h.handleDelete(w, r)
+		case "PUT":
+			status, err = h.handlePut(w, r)
+		case "MKCOL":
+			status, err = h.handleMkcol(w, r)
+		case "COPY", "MOVE":
+			status, err = h.handleCopyMove(w, r)
+		case "LOCK":
+			status, err = h.handleLock(w, r)
+		case "UNLOCK":
+			status, err = h.handleUnlock(w, r)
+		case "PROPFIND":
+			status, err = h.handlePropfind(w, r)
+		case "PROPPATCH":
+			status, err = h.handleProppatch(w, r)
+		}
 	}
 
 	if status != 0 {
@@ -82,20 +111,19 @@ func (h *Handler) handleOptions(w http.ResponseWriter, r *http.Request) (status
 	if err != nil {
 		return status, err
 	}
-	// allow := "OPTIONS, LOCK, PUT, MKCOL"
 	allow := "OPTIONS"
-	if fi, err := h.DriveClient.GetFile(reqPath); err == nil {
+	if fi, err := h.DriveClient.GetFile(r.Context(), reqPath); err == nil {
 		if fi.IsDir() {
 			// allow = "OPTIONS, LOCK, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND"
-			allow = "OPTIONS, DELETE, MOVE, PROPFIND"
+			allow = "OPTIONS, LOCK, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND"
 		} else {
 			// allow = "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND, PUT"
-			allow = "OPTIONS, GET, HEAD, POST, DELETE, MOVE, PROPFIND"
+			allow = "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND, PUT"
 		}
+	} else if errors.Is(err, common.ErrNotFound) {
+		allow = "OPTIONS, LOCK, PUT, MKCOL"
 	} else {
-		if !errors.Is(err, common.ErrNotFound) {
-			logrus.WithError(err).Errorf("handleOptions, call h.DriveClient.GetFile fail, req_path: %s", reqPath)
-		}
+		logrus.WithError(err).Errorf("handleOptions, call h.DriveClient.GetFile fail, req_path: %s", reqPath)
 	}
 	w.Header().Set("Allow", allow)
 	// http://www.webdav.org/specs/rfc4918.html#dav.compliance.classes
@@ -111,16 +139,16 @@ func (h *Handler) handleGetHeadPost(w http.ResponseWriter, r *http.Request) (sta
 		return status, err
 	}
 
-	fi, err := h.DriveClient.GetFile(reqPath)
+	fi, err := h.DriveClient.GetFile(r.Context(), reqPath)
 	if err != nil {
 		logrus.WithError(err).Errorf("handleGetHeadPost, call h.DriveClient.GetFile fail, req_path: %v", reqPath)
-		return http.StatusNotFound, err
+		return driveErrorStatus(err, http.StatusNotFound), err
 	}
 	if fi.IsDir() {
 		return http.StatusMethodNotAllowed, nil
 	}
 
-	etag, err := findETag(r.Context(), reqPath, fi)
+	etag, err := findETag(r.Context(), h.LockSystem, reqPath, fi)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -146,13 +174,66 @@ func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request) (status i
 	// "godoc os RemoveAll" says that "If the path does not exist, RemoveAll
 	// returns nil (no error)." WebDAV semantics are that it should return a
 	// "404 Not Found". We therefore have to Stat before we RemoveAll.
-	if err := h.DriveClient.RemoveFile(reqPath); err != nil {
+	fi, err := h.DriveClient.GetFile(r.Context(), reqPath)
+	if err != nil {
+		return driveErrorStatus(err, http.StatusMethodNotAllowed), err
+	}
+	if err := removeAll(r.Context(), h.DriveClient, h.PropSystem, reqPath, fi); err != nil {
+		return driveErrorStatus(err, http.StatusMethodNotAllowed), err
+	}
+	return http.StatusNoContent, nil
+}
+
+func (h *Handler) handlePut(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	release, status, err := h.confirmLocks(r, reqPath, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
+	// TODO(rost): Support the If-Match, If-None-Match headers? See bradfitz'
+	// comments in http.checkEtag.
+
+	// Section 9.7.1 says that a PUT that creates a resource answers 201, one
+	// that replaces it 200 or 204.
+	created := true
+	if fi, err := h.DriveClient.GetFile(r.Context(), reqPath); err == nil {
+		created = false
+		// Replacing the content deletes it. An empty file, as created by a
+		// LOCK of an unmapped URL before the content is put, has none.
+		if h.Permission == PermNoDelete && (fi.IsDir() || fi.GetSize() > 0) {
+			return http.StatusForbidden, errPermissionDenied
+		}
+	} else if !errors.Is(err, common.ErrNotFound) {
+		return driveErrorStatus(err, http.StatusInternalServerError), err
+	}
+	status = http.StatusNoContent
+	if created {
+		status = http.StatusCreated
+	}
+
+	if err := h.DriveClient.UploadFile(r.Context(), reqPath, r.Body, r.ContentLength); err != nil {
+		logrus.WithError(err).Errorf("call h.DriveClient.UploadFile fail, req_path: %s", reqPath)
 		if errors.Is(err, common.ErrNotFound) {
-			return http.StatusNotFound, err
+			// The parent collection is missing.
+			return http.StatusConflict, err
 		}
-		return http.StatusMethodNotAllowed, err
+		return driveErrorStatus(err, http.StatusMethodNotAllowed), err
 	}
-	return http.StatusNoContent, nil
+
+	fi, err := h.DriveClient.GetFile(r.Context(), reqPath)
+	if err != nil {
+		return status, nil
+	}
+	etag, err := findETag(r.Context(), h.LockSystem, reqPath, fi)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("ETag", etag)
+	return status, nil
 }
 
 func (h *Handler) handleMkcol(w http.ResponseWriter, r *http.Request) (status int, err error) {
@@ -169,16 +250,24 @@ func (h *Handler) handleMkcol(w http.ResponseWriter, r *http.Request) (status in
 	if r.ContentLength > 0 {
 		return http.StatusUnsupportedMediaType, nil
 	}
-	if err := h.DriveClient.MakeDir(reqPath); err != nil {
-		if errors.Is(err, common.ErrNotFound) {
-			return http.StatusNotFound, err
+	if err := h.DriveClient.MakeDir(r.Context(), reqPath); err != nil {
+		switch {
+		case errors.Is(err, common.ErrNotFound):
+			// Section 9.3.1 says that "409 (Conflict) - A collection cannot
+			// be made at the Request-URI until one or more intermediate
+			// collections have been created."
+			return http.StatusConflict, err
+		case errors.Is(err, common.ErrExists):
+			// Section 9.3.1 says that "405 (Method Not Allowed) - MKCOL can
+			// only be executed on an unmapped URL."
+			return http.StatusMethodNotAllowed, err
 		}
-		return http.StatusMethodNotAllowed, err
+		return driveErrorStatus(err, http.StatusMethodNotAllowed), err
 	}
 	return http.StatusCreated, nil
 }
 
-func (h *Handler) handleMove(w http.ResponseWriter, r *http.Request) (status int, err error) {
+func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status int, err error) {
 	hdr := r.Header.Get("Destination")
 	if hdr == "" {
 		return http.StatusBadRequest, errInvalidDestination
@@ -207,6 +296,51 @@ func (h *Handler) handleMove(w http.ResponseWriter, r *http.Request) (status int
 	if dst == src {
 		return http.StatusForbidden, errDestinationEqualsSource
 	}
+	if inTree(slashClean(dst), slashClean(src)) || inTree(slashClean(src), slashClean(dst)) {
+		// The resource would be copied or moved into itself, or replacing
+		// the destination would delete it.
+		return http.StatusForbidden, errDestinationEqualsSource
+	}
+	if h.Permission == PermNoDelete {
+		// Replacing the destination would delete it.
+		if _, err := h.DriveClient.GetFile(r.Context(), dst); err == nil {
+			return http.StatusForbidden, errPermissionDenied
+		}
+	}
+
+	if r.Method == "COPY" {
+		// Section 7.5.1 says that a COPY only needs to lock the destination,
+		// not both destination and source. Strictly speaking, this is racy,
+		// even though a COPY doesn't modify the source, if a concurrent
+		// operation modifies the source. However, the litmus test explicitly
+		// checks that COPYing a locked-by-another source is OK.
+		release, status, err := h.confirmLocks(r, "", dst)
+		if err != nil {
+			return status, err
+		}
+		defer release()
+
+		// Section 9.8.3 says that "The COPY method on a collection without a Depth
+		// header must act as if a Depth header with value "infinity" was included".
+		depth := infiniteDepth
+		if hdr := r.Header.Get("Depth"); hdr != "" {
+			depth = parseDepth(hdr)
+			if depth != 0 && depth != infiniteDepth {
+				// Section 9.8.3 says that "A client may submit a Depth header on a
+				// COPY on a collection with a value of "0" or "infinity"."
+				return http.StatusBadRequest, errInvalidDepth
+			}
+		}
+		status, failed, err := copyFiles(r.Context(), h.DriveClient, h.PropSystem, src, dst, r.Header.Get("Overwrite") != "F", depth)
+		if err != nil {
+			logrus.WithError(err).Errorf("call copyFiles fail, src: %s, dst: %s", src, dst)
+			return status, err
+		}
+		if len(failed) > 0 {
+			return h.writeCopyFailures(w, failed)
+		}
+		return status, nil
+	}
 
 	release, status, err := h.confirmLocks(r, src, dst)
 	if err != nil {
@@ -222,13 +356,111 @@ func (h *Handler) handleMove(w http.ResponseWriter, r *http.Request) (status int
 			return http.StatusBadRequest, errInvalidDepth
 		}
 	}
-	err = h.DriveClient.MoveFile(src, dst)
+	status, err = moveFiles(r.Context(), h.DriveClient, h.PropSystem, src, dst, r.Header.Get("Overwrite") != "F")
+	if err != nil {
+		logrus.WithError(err).Errorf("call moveFiles fail, src: %s, dst: %s", src, dst)
+		return status, err
+	}
+	return status, nil
+}
+
+func (h *Handler) handleProppatch(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	release, status, err := h.confirmLocks(r, reqPath, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
+
+	ctx := r.Context()
+	fi, err := h.DriveClient.GetFile(ctx, reqPath)
+	if err != nil {
+		return driveErrorStatus(err, http.StatusMethodNotAllowed), err
+	}
+	patches, status, err := readProppatch(r.Body)
+	if err != nil {
+		return status, err
+	}
+	pstats, err := patch(ctx, h.withDeadProps(reqPath, fi), patches)
 	if err != nil {
-		logrus.WithError(err).Errorf("call h.DriveClient.MoveFile fail, src: %s, dst: %s", src, dst)
 		return http.StatusInternalServerError, err
 	}
-	return http.StatusNoContent, nil
+	mw := multistatusWriter{w: w}
+	writeErr := mw.write(makePropstatResponse(r.URL.Path, pstats))
+	closeErr := mw.close()
+	if writeErr != nil {
+		return http.StatusInternalServerError, writeErr
+	}
+	if closeErr != nil {
+		return http.StatusInternalServerError, closeErr
+	}
+	return 0, nil
+}
 
+// driveErrorStatus returns the status of a request that failed with err, an
+// error of the DriveClient, or fallback if err is not one of the errors of
+// package common.
+func driveErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, common.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrExists):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, common.ErrNameConflict):
+		return http.StatusConflict
+	case errors.Is(err, common.ErrInvalidName):
+		return http.StatusBadRequest
+	case errors.Is(err, common.ErrQuotaExceeded):
+		// Section 11.5 of RFC 4918.
+		return StatusInsufficientStorage
+	case errors.Is(err, common.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, common.ErrLocked):
+		// The drive locks the file, not a lock of this server, so there is
+		// no lock-token-submitted precondition to report.
+		return StatusLocked
+	case errors.Is(err, common.ErrLoginExpired), errors.Is(err, common.ErrRateLimited):
+		// The drive is unavailable for now, the request may succeed later.
+		return http.StatusServiceUnavailable
+	case errors.Is(err, common.ErrCrossAccount):
+		// Section 9.9.4 says that "502 (Bad Gateway) - This may occur
+		// when the destination is on another server".
+		return http.StatusBadGateway
+	case errors.Is(err, context.DeadlineExceeded):
+		// The drive did not answer before the deadline of the request.
+		return http.StatusGatewayTimeout
+	}
+	return fallback
+}
+
+// writeCopyFailures reports the members of a collection that failed to copy
+// in a 207 Multi-Status response, keyed by their destination path.
+func (h *Handler) writeCopyFailures(w http.ResponseWriter, failed map[string]error) (status int, err error) {
+	dsts := make([]string, 0, len(failed))
+	for dst := range failed {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+
+	mw := multistatusWriter{w: w}
+	for _, dst := range dsts {
+		status := driveErrorStatus(failed[dst], http.StatusInternalServerError)
+		err := mw.write(&response{
+			Href:                []string{(&url.URL{Path: path.Join(h.Prefix, h.userPath(dst))}).EscapedPath()},
+			Status:              fmt.Sprintf("HTTP/1.1 %d %s", status, StatusText(status)),
+			ResponseDescription: failed[dst].Error(),
+		})
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	if err := mw.close(); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
 }
 
 func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status int, err error) {
@@ -238,13 +470,12 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 	}
 
 	ctx := r.Context()
-	fi, err := h.DriveClient.GetFile(reqPath)
+	fi, err := h.DriveClient.GetFile(ctx, reqPath)
 	if err != nil {
-		if errors.Is(err, common.ErrNotFound) {
-			return http.StatusNotFound, err
+		if !errors.Is(err, common.ErrNotFound) {
+			logrus.WithError(err).Errorf("handlePropfind, call h.DriveClient.GetFile fail, req_path: %s", reqPath)
 		}
-		logrus.WithError(err).Errorf("handlePropfind, call h.DriveClient.GetFile fail, req_path: %s", reqPath)
-		return http.StatusMethodNotAllowed, err
+		return driveErrorStatus(err, http.StatusMethodNotAllowed), err
 	}
 	depth := infiniteDepth
 	if hdr := r.Header.Get("Depth"); hdr != "" {
@@ -259,14 +490,16 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 	}
 
 	mw := multistatusWriter{w: w}
+	ls := h.userLockSystem()
 
 	walkFn := func(reqPath string, info drive.File, err error) error {
 		if err != nil {
 			return err
 		}
+		info = h.withDeadProps(reqPath, info)
 		var pstats []Propstat
 		if pf.Propname != nil {
-			pnames, err := propnames(ctx, info)
+			pnames, err := propnames(ctx, ls, reqPath, info)
 			if err != nil {
 				return err
 			}
@@ -276,14 +509,14 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 			}
 			pstats = append(pstats, pstat)
 		} else if pf.Allprop != nil {
-			pstats, err = allprop(ctx, info, pf.Prop)
+			pstats, err = allprop(ctx, ls, reqPath, info, pf.Prop)
 		} else {
-			pstats, err = props(ctx, info, pf.Prop)
+			pstats, err = props(ctx, ls, reqPath, info, pf.Prop)
 		}
 		if err != nil {
 			return err
 		}
-		href := path.Join(h.Prefix, reqPath)
+		href := path.Join(h.Prefix, h.userPath(reqPath))
 		if href != "/" && info.IsDir() {
 			href += "/"
 		}
@@ -301,11 +534,133 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 	return 0, nil
 }
 
+func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request) (retStatus int, retErr error) {
+	duration, err := parseTimeout(r.Header.Get("Timeout"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	li, status, err := readLockInfo(r.Body)
+	if err != nil {
+		return status, err
+	}
+
+	token, ld, now, created := "", LockDetails{}, time.Now(), false
+	if li == (lockInfo{}) {
+		// An empty lockInfo means to refresh the lock.
+		ih, ok := parseIfHeader(r.Header.Get("If"))
+		if !ok {
+			return http.StatusBadRequest, errInvalidIfHeader
+		}
+		if len(ih.lists) == 1 && len(ih.lists[0].conditions) == 1 {
+			token = ih.lists[0].conditions[0].Token
+		}
+		if token == "" {
+			return http.StatusBadRequest, errInvalidLockToken
+		}
+		ld, err = h.LockSystem.Refresh(now, token, duration)
+		if err != nil {
+			if err == ErrNoSuchLock {
+				return http.StatusPreconditionFailed, err
+			}
+			if err == ErrLocked {
+				return StatusLocked, err
+			}
+			return http.StatusInternalServerError, err
+		}
+
+	} else {
+		// Section 9.10.3 says that "If no Depth header is submitted on a LOCK request,
+		// then the request MUST act as if a "Depth:infinity" had been submitted."
+		depth := infiniteDepth
+		if hdr := r.Header.Get("Depth"); hdr != "" {
+			depth = parseDepth(hdr)
+			if depth != 0 && depth != infiniteDepth {
+				// Section 9.10.3 says that "Values other than 0 or infinity must not be
+				// used with the Depth header on a LOCK method".
+				return http.StatusBadRequest, errInvalidDepth
+			}
+		}
+		reqPath, status, err := h.stripPrefix(r.URL.Path)
+		if err != nil {
+			return status, err
+		}
+		ld = LockDetails{
+			Root:      reqPath,
+			Duration:  duration,
+			OwnerXML:  li.Owner.InnerXML,
+			ZeroDepth: depth == 0,
+		}
+		token, err = h.LockSystem.Create(now, ld)
+		if err != nil {
+			if err == ErrLocked {
+				return StatusLocked, err
+			}
+			return http.StatusInternalServerError, err
+		}
+		defer func() {
+			if retErr != nil {
+				h.LockSystem.Unlock(now, token)
+			}
+		}()
+
+		// Create the resource if it didn't previously exist.
+		if _, err := h.DriveClient.GetFile(r.Context(), reqPath); err != nil {
+			if !errors.Is(err, common.ErrNotFound) {
+				return http.StatusInternalServerError, err
+			}
+			if err := h.DriveClient.UploadFile(r.Context(), reqPath, bytes.NewReader(nil), 0); err != nil {
+				// TODO: detect missing intermediate dirs and return http.StatusConflict?
+				return http.StatusInternalServerError, err
+			}
+			created = true
+		}
+
+		// http://www.webdav.org/specs/rfc4918.html#HEADER_Lock-Token says that the
+		// Lock-Token value is a Coded-URL. We add angle brackets.
+		w.Header().Set("Lock-Token", "<"+token+">")
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	if created {
+		// This is "w.WriteHeader(http.StatusCreated)" and not "return
+		// http.StatusCreated, nil" because we write our own (XML) response to w
+		// and Handler.ServeHTTP would otherwise write "Created".
+		w.WriteHeader(http.StatusCreated)
+	}
+	ld.Root = h.userPath(ld.Root)
+	writeLockInfo(w, token, ld)
+	return 0, nil
+}
+
+func (h *Handler) handleUnlock(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	// http://www.webdav.org/specs/rfc4918.html#HEADER_Lock-Token says that the
+	// Lock-Token value is a Coded-URL. We strip its angle brackets.
+	t := r.Header.Get("Lock-Token")
+	if len(t) < 2 || t[0] != '<' || t[len(t)-1] != '>' {
+		return http.StatusBadRequest, errInvalidLockToken
+	}
+	t = t[1 : len(t)-1]
+
+	switch err = h.LockSystem.Unlock(time.Now(), t); err {
+	case nil:
+		return http.StatusNoContent, err
+	case ErrForbidden:
+		return http.StatusForbidden, err
+	case ErrLocked:
+		return StatusLocked, err
+	case ErrNoSuchLock:
+		return http.StatusConflict, err
+	default:
+		return http.StatusInternalServerError, err
+	}
+}
+
 func (h *Handler) lock(now time.Time, root string) (token string, status int, err error) {
 	token, err = h.LockSystem.Create(now, LockDetails{
 		Root:      root,
 		Duration:  infiniteTimeout,
 		ZeroDepth: true,
+		Temporary: true,
 	})
 	if err != nil {
 		if err == ErrLocked {
@@ -463,6 +818,7 @@ func StatusText(code int) string {
 
 var (
 	errDestinationEqualsSource = errors.New("webdav: destination equals source")
+	errDestinationExists       = errors.New("webdav: destination exists")
 	errDirectoryNotEmpty       = errors.New("webdav: directory not empty")
 	errInvalidDepth            = errors.New("webdav: invalid depth")
 	errInvalidDestination      = errors.New("webdav: invalid destination")
@@ -476,6 +832,7 @@ var (
 	errNoFileSystem            = errors.New("webdav: no file system")
 	errNoLockSystem            = errors.New("webdav: no lock system")
 	errNotADirectory           = errors.New("webdav: not a directory")
+	errPermissionDenied        = errors.New("webdav: permission denied")
 	errPrefixMismatch          = errors.New("webdav: prefix mismatch")
 	errRecursionTooDeep        = errors.New("webdav: recursion too deep")
 	errUnsupportedLockInfo     = errors.New("webdav: unsupported lock info")
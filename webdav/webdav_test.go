@@ -0,0 +1,653 @@
+package webdav
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gaoyb7/115drive-webdav/common"
+	"github.com/gaoyb7/115drive-webdav/local"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.PanicLevel)
+	os.Exit(m.Run())
+}
+
+// newTestHandler returns a Handler serving a temp dir through the local
+// drive, and the temp dir.
+func newTestHandler(t *testing.T) (*Handler, string) {
+	t.Helper()
+	dir := tempDir(t)
+	client, err := local.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{
+		DriveClient: client,
+		LockSystem:  NewMemLS(),
+		PropSystem:  NewMemPS(),
+	}, dir
+}
+
+// do serves a request of method to urlPath, with body and the headers given
+// as name, value pairs.
+func do(h http.Handler, method, urlPath, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, urlPath, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile returns the content of name in dir, or "<missing>".
+func readFile(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return "<missing>"
+	}
+	return string(data)
+}
+
+func TestHandlerPutGet(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	if w := do(h, "PUT", "/f.txt", "hello world"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT of a new file: got %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w := do(h, "PUT", "/f.txt", "hello again"); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT over a file: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := readFile(dir, "f.txt"); got != "hello again" {
+		t.Errorf("after PUT: got %q, want %q", got, "hello again")
+	}
+	if w := do(h, "PUT", "/nope/f.txt", "x"); w.Code != http.StatusConflict {
+		t.Errorf("PUT into a missing dir: got %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w := do(h, "GET", "/f.txt", "")
+	if w.Code != http.StatusOK || w.Body.String() != "hello again" {
+		t.Errorf("GET: got %d %q, want 200 %q", w.Code, w.Body.String(), "hello again")
+	}
+	w = do(h, "GET", "/f.txt", "", "Range", "bytes=6-")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "again" {
+		t.Errorf("GET of a range: got %d %q, want 206 %q", w.Code, w.Body.String(), "again")
+	}
+	if w := do(h, "GET", "/nope.txt", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing file: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandlerPropfind(t *testing.T) {
+	h, dir := newTestHandler(t)
+	writeFile(t, dir, "a/f.txt", "f")
+	writeFile(t, dir, "a/b/g.txt", "g")
+
+	w := do(h, "PROPFIND", "/a", "", "Depth", "1")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND: got %d, want %d", w.Code, http.StatusMultiStatus)
+	}
+	body := w.Body.String()
+	for _, href := range []string{"<D:href>/a/</D:href>", "<D:href>/a/f.txt</D:href>", "<D:href>/a/b/</D:href>"} {
+		if !strings.Contains(body, href) {
+			t.Errorf("PROPFIND: %s is missing from %s", href, body)
+		}
+	}
+	if strings.Contains(body, "g.txt") {
+		t.Errorf("PROPFIND with depth 1: got the members of /a/b in %s", body)
+	}
+	if w := do(h, "PROPFIND", "/nope", "", "Depth", "0"); w.Code != http.StatusNotFound {
+		t.Errorf("PROPFIND of a missing file: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandlerMkcolDelete(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	if w := do(h, "MKCOL", "/a", ""); w.Code != http.StatusCreated {
+		t.Fatalf("MKCOL: got %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w := do(h, "MKCOL", "/a", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("MKCOL of an existing dir: got %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if w := do(h, "MKCOL", "/nope/a", ""); w.Code != http.StatusConflict {
+		t.Errorf("MKCOL in a missing dir: got %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	writeFile(t, dir, "a/f.txt", "f")
+	if w := do(h, "DELETE", "/a", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("after DELETE: got %v, want the dir removed", err)
+	}
+	if w := do(h, "DELETE", "/a", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a missing file: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandlerCopyMove(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		method    string
+		src, dst  string
+		overwrite string
+		want      int
+		// files is the content of files after the request, "<missing>"
+		// for the ones that must not exist.
+		files map[string]string
+	}{{
+		desc:   "copy",
+		method: "COPY", src: "/a/f.txt", dst: "/b/f2.txt",
+		want:  http.StatusCreated,
+		files: map[string]string{"a/f.txt": "f", "b/f2.txt": "f"},
+	}, {
+		desc:   "copy a dir",
+		method: "COPY", src: "/a", dst: "/c",
+		want:  http.StatusCreated,
+		files: map[string]string{"a/f.txt": "f", "c/f.txt": "f", "c/d/h.txt": "h"},
+	}, {
+		desc:   "copy over a file",
+		method: "COPY", src: "/a/f.txt", dst: "/b/g.txt",
+		want:  http.StatusNoContent,
+		files: map[string]string{"a/f.txt": "f", "b/g.txt": "f"},
+	}, {
+		desc:   "copy over a file without overwrite",
+		method: "COPY", src: "/a/f.txt", dst: "/b/g.txt", overwrite: "F",
+		want:  http.StatusPreconditionFailed,
+		files: map[string]string{"a/f.txt": "f", "b/g.txt": "g"},
+	}, {
+		desc:   "copy into a missing dir",
+		method: "COPY", src: "/a/f.txt", dst: "/nope/f.txt",
+		want: http.StatusConflict,
+	}, {
+		desc:   "copy a dir into itself",
+		method: "COPY", src: "/a", dst: "/a/d/a",
+		want:  http.StatusForbidden,
+		files: map[string]string{"a/d/a/f.txt": "<missing>"},
+	}, {
+		desc:   "move",
+		method: "MOVE", src: "/a/f.txt", dst: "/b/f2.txt",
+		want:  http.StatusCreated,
+		files: map[string]string{"a/f.txt": "<missing>", "b/f2.txt": "f"},
+	}, {
+		desc:   "move a dir",
+		method: "MOVE", src: "/a", dst: "/c",
+		want:  http.StatusCreated,
+		files: map[string]string{"a/f.txt": "<missing>", "c/f.txt": "f", "c/d/h.txt": "h"},
+	}, {
+		desc:   "move over a file",
+		method: "MOVE", src: "/a/f.txt", dst: "/b/g.txt",
+		want:  http.StatusNoContent,
+		files: map[string]string{"a/f.txt": "<missing>", "b/g.txt": "f"},
+	}, {
+		desc:   "move over a file without overwrite",
+		method: "MOVE", src: "/a/f.txt", dst: "/b/g.txt", overwrite: "F",
+		want:  http.StatusPreconditionFailed,
+		files: map[string]string{"a/f.txt": "f", "b/g.txt": "g"},
+	}, {
+		desc:   "move into a missing dir",
+		method: "MOVE", src: "/a/f.txt", dst: "/nope/f.txt",
+		want:  http.StatusConflict,
+		files: map[string]string{"a/f.txt": "f"},
+	}, {
+		desc:   "move a dir over a dir below it",
+		method: "MOVE", src: "/a", dst: "/a/d",
+		want:  http.StatusForbidden,
+		files: map[string]string{"a/f.txt": "f", "a/d/h.txt": "h"},
+	}, {
+		desc:   "move a dir over the dir above it",
+		method: "MOVE", src: "/a/d", dst: "/a",
+		want:  http.StatusForbidden,
+		files: map[string]string{"a/f.txt": "f", "a/d/h.txt": "h"},
+	}, {
+		desc:   "move a missing file",
+		method: "MOVE", src: "/a/nope.txt", dst: "/b/nope.txt",
+		want: http.StatusNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			h, dir := newTestHandler(t)
+			writeFile(t, dir, "a/f.txt", "f")
+			writeFile(t, dir, "a/d/h.txt", "h")
+			writeFile(t, dir, "b/g.txt", "g")
+
+			headers := []string{"Destination", tc.dst}
+			if tc.overwrite != "" {
+				headers = append(headers, "Overwrite", tc.overwrite)
+			}
+			if w := do(h, tc.method, tc.src, "", headers...); w.Code != tc.want {
+				t.Errorf("%s %s to %s: got %d, want %d", tc.method, tc.src, tc.dst, w.Code, tc.want)
+			}
+			for name, want := range tc.files {
+				if got := readFile(dir, name); got != want {
+					t.Errorf("after %s: %s is %q, want %q", tc.method, name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerSymlinkOutOfRoot(t *testing.T) {
+	h, dir := newTestHandler(t)
+	outside, err := ioutil.TempDir("", "webdav-test-outside-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	writeFile(t, outside, "secret.txt", "secret")
+	writeFile(t, dir, "a/f.txt", "f")
+	if err := os.Symlink(outside, filepath.Join(dir, "out")); err != nil {
+		t.Skipf("can not create a symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a"), filepath.Join(dir, "in")); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := do(h, "GET", "/out/secret.txt", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET through a symlink out of root: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := do(h, "PUT", "/out/new.txt", "x"); w.Code < 400 {
+		t.Errorf("PUT through a symlink out of root: got %d, want an error", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("PUT through a symlink out of root: got a file outside of root")
+	}
+	w := do(h, "PROPFIND", "/", "", "Depth", "1")
+	if strings.Contains(w.Body.String(), "/out") {
+		t.Errorf("PROPFIND: got a symlink out of root in %s", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "<D:href>/in/</D:href>") {
+		t.Errorf("PROPFIND: a symlink inside root is missing from %s", w.Body.String())
+	}
+	if w := do(h, "GET", "/in/f.txt", ""); w.Code != http.StatusOK || w.Body.String() != "f" {
+		t.Errorf("GET through a symlink inside root: got %d %q, want 200 %q", w.Code, w.Body.String(), "f")
+	}
+}
+
+func TestHandlerNoDelete(t *testing.T) {
+	h, dir := newTestHandler(t)
+	h.Permission = PermNoDelete
+	writeFile(t, dir, "a/f.txt", "f")
+	writeFile(t, dir, "a/g.txt", "g")
+
+	testCases := []struct {
+		desc    string
+		method  string
+		urlPath string
+		body    string
+		headers []string
+		want    int
+	}{
+		{"put a new file", "PUT", "/a/new.txt", "new", nil, http.StatusCreated},
+		{"put over a file", "PUT", "/a/f.txt", "x", nil, http.StatusForbidden},
+		{"delete", "DELETE", "/a/g.txt", "", nil, http.StatusForbidden},
+		{"copy over a file", "COPY", "/a/f.txt", "", []string{"Destination", "/a/g.txt"}, http.StatusForbidden},
+		{"move over a file", "MOVE", "/a/f.txt", "", []string{"Destination", "/a/g.txt"}, http.StatusForbidden},
+		{"move", "MOVE", "/a/new.txt", "", []string{"Destination", "/a/moved.txt"}, http.StatusCreated},
+		// A LOCK of an unmapped URL creates an empty file, whose content
+		// is put next.
+		{"lock a new file", "LOCK", "/a/locked.txt", lockBody, nil, http.StatusCreated},
+		{"put over a file created by lock", "PUT", "/a/locked.txt", "locked", nil, http.StatusNoContent},
+	}
+	var token string
+	for _, tc := range testCases {
+		headers := tc.headers
+		if token != "" {
+			headers = append(headers, "If", "("+token+")")
+		}
+		w := do(h, tc.method, tc.urlPath, tc.body, headers...)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.desc, w.Code, tc.want)
+		}
+		if tc.method == "LOCK" {
+			token = w.Header().Get("Lock-Token")
+		}
+	}
+	want := map[string]string{"a/f.txt": "f", "a/g.txt": "g", "a/moved.txt": "new", "a/locked.txt": "locked"}
+	for name, content := range want {
+		if got := readFile(dir, name); got != content {
+			t.Errorf("%s is %q, want %q", name, got, content)
+		}
+	}
+}
+
+const lockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+	<D:lockscope><D:exclusive/></D:lockscope>
+	<D:locktype><D:write/></D:locktype>
+	<D:owner>test</D:owner>
+</D:lockinfo>`
+
+const proppatchBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test">
+	<D:set><D:prop><Z:color>red</Z:color></D:prop></D:set>
+</D:propertyupdate>`
+
+func TestHandlerDeadPropsRemoved(t *testing.T) {
+	h, dir := newTestHandler(t)
+	for _, name := range []string{"a/b/f.txt", "c/g.txt", "d/h.txt", "e/i.txt"} {
+		writeFile(t, dir, name, name)
+	}
+	patched := []string{"/a", "/a/b", "/a/b/f.txt", "/c", "/c/g.txt", "/d/h.txt", "/e/i.txt"}
+	for _, name := range patched {
+		if w := do(h, "PROPPATCH", name, proppatchBody); w.Code != http.StatusMultiStatus {
+			t.Fatalf("PROPPATCH %s: got %d", name, w.Code)
+		}
+	}
+	ps := h.PropSystem.(*memPS)
+	count := func() int {
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+		return len(ps.byID)
+	}
+
+	if w := do(h, "DELETE", "/a", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d", w.Code)
+	}
+	if got := count(); got != len(patched)-3 {
+		t.Errorf("props after a DELETE of a tree: got %d, want %d", got, len(patched)-3)
+	}
+	// The props of the moved file follow it, the ones of the overwritten
+	// tree go.
+	if w := do(h, "MOVE", "/d/h.txt", "", "Destination", "/c"); w.Code != http.StatusNoContent {
+		t.Fatalf("MOVE: got %d", w.Code)
+	}
+	if got := count(); got != len(patched)-5 {
+		t.Errorf("props after a MOVE over a tree: got %d, want %d", got, len(patched)-5)
+	}
+	if w := do(h, "COPY", "/c", "", "Destination", "/e"); w.Code != http.StatusNoContent {
+		t.Fatalf("COPY: got %d", w.Code)
+	}
+	if got := count(); got != len(patched)-6 {
+		t.Errorf("props after a COPY over a tree: got %d, want %d", got, len(patched)-6)
+	}
+}
+
+func TestHandlerDeadPropsMovedElsewhere(t *testing.T) {
+	h, dir := newTestHandler(t)
+	writeFile(t, dir, "a/f.txt", "f")
+	writeFile(t, dir, "b/keep", "")
+	if w := do(h, "PROPPATCH", "/a/f.txt", proppatchBody); w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPPATCH: got %d", w.Code)
+	}
+	// Moved by another client, and replaced by another file.
+	if err := os.Rename(filepath.Join(dir, "a", "f.txt"), filepath.Join(dir, "b", "f.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "a/f.txt", "new")
+
+	if w := do(h, "DELETE", "/a", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d", w.Code)
+	}
+	w := do(h, "PROPFIND", "/b/f.txt", "", "Depth", "0")
+	if !strings.Contains(w.Body.String(), "red") {
+		t.Errorf("PROPFIND of the file moved away from a deleted dir: got %s, want its props", w.Body.String())
+	}
+}
+
+const lockDiscoveryBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:lockdiscovery/></D:prop></D:propfind>`
+
+// lockDiscovery returns the body of a PROPFIND of the DAV:lockdiscovery of
+// urlPath.
+func lockDiscovery(t *testing.T, h http.Handler, urlPath string) string {
+	t.Helper()
+	w := do(h, "PROPFIND", urlPath, lockDiscoveryBody, "Depth", "0")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND of %s: got %d, want %d", urlPath, w.Code, http.StatusMultiStatus)
+	}
+	return w.Body.String()
+}
+
+func TestHandlerLockUnlock(t *testing.T) {
+	h, dir := newTestHandler(t)
+	writeFile(t, dir, "a/f.txt", "f")
+
+	w := do(h, "LOCK", "/a/f.txt", lockBody, "Timeout", "Second-100")
+	if w.Code != http.StatusOK {
+		t.Fatalf("LOCK: got %d, want %d", w.Code, http.StatusOK)
+	}
+	lockToken := w.Header().Get("Lock-Token")
+	if len(lockToken) < 2 || lockToken[0] != '<' || lockToken[len(lockToken)-1] != '>' {
+		t.Fatalf("LOCK: got the Lock-Token %q, want a coded url", lockToken)
+	}
+	token := lockToken[1 : len(lockToken)-1]
+	body := w.Body.String()
+	for _, want := range []string{
+		"<D:owner>test</D:owner>",
+		"<D:depth>infinity</D:depth>",
+		"<D:timeout>Second-100</D:timeout>",
+		"<D:locktoken><D:href>" + token + "</D:href></D:locktoken>",
+		"<D:lockroot><D:href>/a/f.txt</D:href></D:lockroot>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("LOCK: %s is missing from %s", want, body)
+		}
+	}
+	if body := lockDiscovery(t, h, "/a/f.txt"); !strings.Contains(body, token) {
+		t.Errorf("lockdiscovery of the locked file: the lock is missing from %s", body)
+	}
+	if body := lockDiscovery(t, h, "/a"); strings.Contains(body, token) {
+		t.Errorf("lockdiscovery of the parent dir: got the lock of a member in %s", body)
+	}
+
+	if w := do(h, "LOCK", "/a/f.txt", lockBody); w.Code != StatusLocked {
+		t.Errorf("LOCK of a locked file: got %d, want %d", w.Code, StatusLocked)
+	}
+	if w := do(h, "PUT", "/a/f.txt", "x"); w.Code != StatusLocked {
+		t.Errorf("PUT without the token: got %d, want %d", w.Code, StatusLocked)
+	}
+	if w := do(h, "PUT", "/a/f.txt", "x", "If", "(<opaquelocktoken:nope>)"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with another token: got %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+	if w := do(h, "PUT", "/a/f.txt", "x", "If", "("+lockToken+")"); w.Code != http.StatusNoContent {
+		t.Errorf("PUT with the token: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := readFile(dir, "a/f.txt"); got != "x" {
+		t.Errorf("after PUT with the token: a/f.txt is %q, want %q", got, "x")
+	}
+
+	// A LOCK without a body refreshes the lock of the token in If.
+	w = do(h, "LOCK", "/a/f.txt", "", "If", "("+lockToken+")", "Timeout", "Second-200")
+	if w.Code != http.StatusOK {
+		t.Fatalf("LOCK refresh: got %d, want %d", w.Code, http.StatusOK)
+	}
+	body = w.Body.String()
+	if !strings.Contains(body, "<D:timeout>Second-200</D:timeout>") || !strings.Contains(body, token) {
+		t.Errorf("LOCK refresh: got %s, want the lock with the new timeout", body)
+	}
+	if w := do(h, "LOCK", "/a/f.txt", "", "If", "(<opaquelocktoken:nope>)"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("LOCK refresh of another token: got %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+	if w := do(h, "LOCK", "/a/f.txt", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("LOCK refresh without a token: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w := do(h, "UNLOCK", "/a/f.txt", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("UNLOCK without a token: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := do(h, "UNLOCK", "/a/f.txt", "", "Lock-Token", "<opaquelocktoken:nope>"); w.Code != http.StatusConflict {
+		t.Errorf("UNLOCK of another token: got %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := do(h, "UNLOCK", "/a/f.txt", "", "Lock-Token", lockToken); w.Code != http.StatusNoContent {
+		t.Errorf("UNLOCK: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if body := lockDiscovery(t, h, "/a/f.txt"); strings.Contains(body, token) {
+		t.Errorf("lockdiscovery after UNLOCK: got the lock in %s", body)
+	}
+	if w := do(h, "PUT", "/a/f.txt", "y"); w.Code != http.StatusNoContent {
+		t.Errorf("PUT after UNLOCK: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestHandlerLockDepth(t *testing.T) {
+	h, dir := newTestHandler(t)
+	writeFile(t, dir, "a/f.txt", "f")
+	writeFile(t, dir, "a/b/g.txt", "g")
+
+	if w := do(h, "LOCK", "/a", lockBody, "Depth", "1"); w.Code != http.StatusBadRequest {
+		t.Errorf("LOCK with depth 1: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// A lock of depth 0 only locks the dir itself.
+	w := do(h, "LOCK", "/a/b", lockBody, "Depth", "0")
+	if w.Code != http.StatusOK {
+		t.Fatalf("LOCK with depth 0: got %d, want %d", w.Code, http.StatusOK)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "<D:depth>0</D:depth>") {
+		t.Errorf("LOCK with depth 0: got %s, want depth 0", body)
+	}
+	if w := do(h, "PUT", "/a/b/g.txt", "x"); w.Code != http.StatusNoContent {
+		t.Errorf("PUT below a lock of depth 0: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := do(h, "UNLOCK", "/a/b", "", "Lock-Token", w.Header().Get("Lock-Token")); w.Code != http.StatusNoContent {
+		t.Fatalf("UNLOCK: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	// An infinite lock locks everything below the dir, and is discovered
+	// there.
+	w = do(h, "LOCK", "/a", lockBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("LOCK: got %d, want %d", w.Code, http.StatusOK)
+	}
+	lockToken := w.Header().Get("Lock-Token")
+	for _, urlPath := range []string{"/a/f.txt", "/a/b/g.txt"} {
+		if w := do(h, "PUT", urlPath, "x"); w.Code != StatusLocked {
+			t.Errorf("PUT of %s below the lock: got %d, want %d", urlPath, w.Code, StatusLocked)
+		}
+		if body := lockDiscovery(t, h, urlPath); !strings.Contains(body, "<D:lockroot><D:href>/a</D:href></D:lockroot>") {
+			t.Errorf("lockdiscovery of %s: the lock of /a is missing from %s", urlPath, body)
+		}
+	}
+	if w := do(h, "DELETE", "/a/b", ""); w.Code != StatusLocked {
+		t.Errorf("DELETE below the lock: got %d, want %d", w.Code, StatusLocked)
+	}
+	if w := do(h, "LOCK", "/a/b/g.txt", lockBody); w.Code != StatusLocked {
+		t.Errorf("LOCK below the lock: got %d, want %d", w.Code, StatusLocked)
+	}
+	if w := do(h, "PUT", "/a/b/g.txt", "x", "If", "("+lockToken+")"); w.Code != http.StatusNoContent {
+		t.Errorf("PUT below the lock with its token: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := readFile(dir, "a/f.txt"); got != "f" {
+		t.Errorf("a/f.txt is %q, want %q", got, "f")
+	}
+}
+
+func TestHandlerRoot(t *testing.T) {
+	h, dir := newTestHandler(t)
+	h.Root = "/u"
+	writeFile(t, dir, "u/f.txt", "f")
+	writeFile(t, dir, "secret.txt", "secret")
+
+	if w := do(h, "GET", "/f.txt", ""); w.Code != http.StatusOK || w.Body.String() != "f" {
+		t.Errorf("GET /f.txt: got %d %q, want the file of the root", w.Code, w.Body.String())
+	}
+	for _, urlPath := range []string{"/../secret.txt", "/%2e%2e/secret.txt", "/..%2fsecret.txt", "/a/../../secret.txt"} {
+		if w := do(h, "GET", urlPath, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d %q, want %d", urlPath, w.Code, w.Body.String(), http.StatusNotFound)
+		}
+	}
+
+	w := do(h, "PROPFIND", "/", "", "Depth", "1")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND: got %d, want %d", w.Code, http.StatusMultiStatus)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "<D:href>/f.txt</D:href>") || strings.Contains(body, "/u/") || strings.Contains(body, "secret") {
+		t.Errorf("PROPFIND of the root: got %s, want the files of /u with paths under the root", body)
+	}
+
+	w = do(h, "LOCK", "/f.txt", lockBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("LOCK: got %d, want %d", w.Code, http.StatusOK)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "<D:lockroot><D:href>/f.txt</D:href></D:lockroot>") {
+		t.Errorf("LOCK: got %s, want the lock root under the root", body)
+	}
+	if body := lockDiscovery(t, h, "/f.txt"); !strings.Contains(body, "<D:lockroot><D:href>/f.txt</D:href></D:lockroot>") {
+		t.Errorf("lockdiscovery: got %s, want the lock root under the root", body)
+	}
+	if w := do(h, "UNLOCK", "/f.txt", "", "Lock-Token", w.Header().Get("Lock-Token")); w.Code != http.StatusNoContent {
+		t.Fatalf("UNLOCK: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestHandlerRootDestination(t *testing.T) {
+	testCases := []struct {
+		method string
+		dst    string
+		// file is the path of the copy or the moved file, in the root.
+		file string
+	}{
+		{"COPY", "/g.txt", "u/g.txt"},
+		{"COPY", "/../g.txt", "u/g.txt"},
+		{"COPY", "/%2e%2e/g.txt", "u/g.txt"},
+		{"COPY", "http://example.com/a/../../g.txt", "u/g.txt"},
+		{"MOVE", "/../secret.txt", "u/secret.txt"},
+		{"MOVE", "/..%2f..%2fg.txt", "u/g.txt"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.dst, func(t *testing.T) {
+			h, dir := newTestHandler(t)
+			h.Root = "/u"
+			writeFile(t, dir, "u/f.txt", "f")
+			writeFile(t, dir, "secret.txt", "secret")
+
+			if w := do(h, tc.method, "/f.txt", "", "Destination", tc.dst); w.Code != http.StatusCreated {
+				t.Fatalf("%s to %s: got %d, want %d", tc.method, tc.dst, w.Code, http.StatusCreated)
+			}
+			if got := readFile(dir, tc.file); got != "f" {
+				t.Errorf("%s to %s: %s is %q, want %q", tc.method, tc.dst, tc.file, got, "f")
+			}
+			for name, want := range map[string]string{"secret.txt": "secret", "g.txt": "<missing>"} {
+				if got := readFile(dir, name); got != want {
+					t.Errorf("%s to %s: %s is %q, want %q", tc.method, tc.dst, name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDriveErrorStatus(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want int
+	}{
+		{common.ErrNotFound, http.StatusNotFound},
+		{common.ErrExists, http.StatusPreconditionFailed},
+		{common.ErrNameConflict, http.StatusConflict},
+		{common.ErrQuotaExceeded, StatusInsufficientStorage},
+		{common.ErrForbidden, http.StatusForbidden},
+		{common.ErrLocked, StatusLocked},
+		{common.ErrRateLimited, http.StatusServiceUnavailable},
+		{fmt.Errorf("api move file fail, err: %w", common.ErrLocked), StatusLocked},
+		{errors.New("other"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		if got := driveErrorStatus(tc.err, http.StatusInternalServerError); got != tc.want {
+			t.Errorf("driveErrorStatus(%v): got %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	// As of https://go-review.googlesource.com/#/c/12772/ which was submitted
 	// in July 2015, this package uses an internal fork of the standard
@@ -34,11 +35,41 @@ import (
 	ixml "github.com/gaoyb7/115drive-webdav/webdav/internal/xml"
 )
 
+// http://www.webdav.org/specs/rfc4918.html#ELEMENT_lockinfo
+type lockInfo struct {
+	XMLName   ixml.Name `xml:"lockinfo"`
+	Exclusive *struct{} `xml:"lockscope>exclusive"`
+	Shared    *struct{} `xml:"lockscope>shared"`
+	Write     *struct{} `xml:"locktype>write"`
+	Owner     owner     `xml:"owner"`
+}
+
 // http://www.webdav.org/specs/rfc4918.html#ELEMENT_owner
 type owner struct {
 	InnerXML string `xml:",innerxml"`
 }
 
+func readLockInfo(r io.Reader) (li lockInfo, status int, err error) {
+	c := &countingReader{r: r}
+	if err = ixml.NewDecoder(c).Decode(&li); err != nil {
+		if err == io.EOF {
+			if c.n == 0 {
+				// An empty body means to refresh the lock.
+				// http://www.webdav.org/specs/rfc4918.html#refreshing-locks
+				return lockInfo{}, 0, nil
+			}
+			err = errInvalidLockInfo
+		}
+		return lockInfo{}, http.StatusBadRequest, err
+	}
+	// We only support exclusive (non-shared) write locks. In practice, these are
+	// the only types of locks that seem to matter.
+	if li.Exclusive == nil || li.Shared != nil || li.Write == nil {
+		return lockInfo{}, http.StatusNotImplemented, errUnsupportedLockInfo
+	}
+	return li, 0, nil
+}
+
 type countingReader struct {
 	n int
 	r io.Reader
@@ -50,6 +81,39 @@ func (c *countingReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+func writeLockInfo(w io.Writer, token string, ld LockDetails) (int, error) {
+	return fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"+
+		"<D:prop xmlns:D=\"DAV:\"><D:lockdiscovery>%s</D:lockdiscovery></D:prop>",
+		activeLockXML(token, ld),
+	)
+}
+
+// activeLockXML returns the DAV:activelock element of a lock. The element
+// declares its own DAV: namespace prefix, so that it can also be used as the
+// value of a DAV:lockdiscovery property.
+// http://www.webdav.org/specs/rfc4918.html#ELEMENT_activelock
+func activeLockXML(token string, ld LockDetails) string {
+	depth := "infinity"
+	if ld.ZeroDepth {
+		depth = "0"
+	}
+	timeout := "Infinite"
+	if ld.Duration >= 0 {
+		timeout = fmt.Sprintf("Second-%d", ld.Duration/time.Second)
+	}
+	return fmt.Sprintf("<D:activelock xmlns:D=\"DAV:\">\n"+
+		"	<D:locktype><D:write/></D:locktype>\n"+
+		"	<D:lockscope><D:exclusive/></D:lockscope>\n"+
+		"	<D:depth>%s</D:depth>\n"+
+		"	<D:owner>%s</D:owner>\n"+
+		"	<D:timeout>%s</D:timeout>\n"+
+		"	<D:locktoken><D:href>%s</D:href></D:locktoken>\n"+
+		"	<D:lockroot><D:href>%s</D:href></D:lockroot>\n"+
+		"</D:activelock>",
+		depth, ld.OwnerXML, timeout, escape(token), escape(ld.Root),
+	)
+}
+
 func escape(s string) string {
 	for i := 0; i < len(s); i++ {
 		switch s[i] {